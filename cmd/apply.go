@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/hanshal101/snapwall/database/psql"
+	"github.com/hanshal101/snapwall/internal/audit"
+	"github.com/hanshal101/snapwall/internal/manifest"
+)
+
+// runApply implements `snapwall apply -f <file|dir>`, which makes the
+// database match a policy document.
+func runApply(args []string) {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	path := fs.String("f", "", "Policy document, or a directory of documents, to apply")
+	prune := fs.Bool("prune", false, "Delete objects that are not in the document")
+	dryRun := fs.Bool("dry-run", false, "Print the changes without applying them")
+	fs.Parse(args)
+
+	if *path == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	info, err := os.Stat(*path)
+	if err != nil {
+		log.Fatalf("Error in reading %s: %v", *path, err)
+	}
+
+	var doc manifest.Document
+	if info.IsDir() {
+		doc, err = manifest.LoadDir(*path)
+	} else {
+		doc, err = manifest.LoadFile(*path)
+	}
+	if err != nil {
+		log.Fatalf("Error in loading document: %v", err)
+	}
+
+	changes, err := manifest.Apply(ctx, psql.DB, doc, manifest.Options{Prune: *prune, DryRun: *dryRun}, cliActor())
+	for _, change := range changes {
+		fmt.Printf("%s %s %q\n", change.Action, change.Kind, change.Name)
+	}
+	if err != nil {
+		log.Fatalf("Error in applying document: %v", err)
+	}
+	if len(changes) == 0 {
+		fmt.Println("No changes")
+	}
+}
+
+func cliActor() audit.Actor {
+	name := "cli"
+	if u, err := user.Current(); err == nil {
+		name = "cli:" + u.Username
+	}
+	return audit.Actor{Name: name}
+}
+
+// startPolicySync watches POLICY_SYNC_DIR, if set, and applies its documents
+// whenever they change. POLICY_SYNC_INTERVAL sets how often the directory is
+// checked and POLICY_SYNC_PRUNE=true makes it the only source of truth.
+func startPolicySync(ctx context.Context) {
+	dir := os.Getenv("POLICY_SYNC_DIR")
+	if dir == "" {
+		return
+	}
+
+	interval := 30 * time.Second
+	if value := os.Getenv("POLICY_SYNC_INTERVAL"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Error in parsing POLICY_SYNC_INTERVAL: %v", err)
+		}
+		interval = d
+	}
+
+	log.Printf("Syncing policies from %s every %v", dir, interval)
+	go manifest.Watch(ctx, psql.DB, dir, interval, os.Getenv("POLICY_SYNC_PRUNE") == "true")
+}
@@ -4,12 +4,15 @@ import (
 	"context"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/hanshal101/snapwall/database/clickhouse"
 	"github.com/hanshal101/snapwall/database/migrate"
 	"github.com/hanshal101/snapwall/database/psql"
+	"github.com/hanshal101/snapwall/internal/audit"
+	"github.com/hanshal101/snapwall/internal/logs"
 	"github.com/hanshal101/snapwall/internal/router"
 	"github.com/hanshal101/snapwall/internal/sysinfo"
 	"github.com/joho/godotenv"
@@ -30,9 +33,31 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "apply" {
+		runApply(os.Args[2:])
+		return
+	}
+
+	startPolicySync(ctx)
+	logs.Listen(ctx, os.Getenv("POSTGRES_DB_URL"))
+
 	r := gin.Default()
+	// Client IPs decide the lockout check and the audit trail, so
+	// X-Forwarded-For is only believed from the proxies in TRUSTED_PROXIES.
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatalf("Error in setting trusted proxies: %v", err)
+	}
 	r.Use(cors.Default())
 
+	tokens, err := audit.ParseTokens(os.Getenv("API_TOKENS"))
+	if err != nil {
+		log.Fatalf("Error in parsing API_TOKENS: %v", err)
+	}
+	if len(tokens) == 0 {
+		log.Printf("API_TOKENS is not set: the API is open and changes are recorded as anonymous")
+	}
+	r.Use(audit.Authenticate(tokens))
+
 	r.GET("/sysinfo", sysinfo.GetSystemInfo)
 	r.GET("/node", sysinfo.ServeNodeInfo)
 	// POLICY Routes
@@ -51,5 +76,49 @@ func main() {
 	application := r.Group("/application")
 	router.ApplicationRoutes(application)
 
+	// GROUP Routes
+	groups := r.Group("/groups")
+	router.GroupRoutes(groups)
+
+	// AUDIT Routes
+	auditEvents := r.Group("/audit")
+	router.AuditRoutes(auditEvents)
+
+	// MANIFEST Routes
+	manifest := r.Group("/manifest")
+	router.ManifestRoutes(manifest)
+
+	// CLASSIFICATION RULE Routes
+	rules := r.Group("/rules")
+	router.RuleRoutes(rules)
+
+	// DETECTION Routes
+	detections := r.Group("/detections")
+	router.DetectionRoutes(detections)
+
+	// AUTOBLOCK Routes
+	autoblocks := r.Group("/autoblocks")
+	router.AutoBlockRoutes(autoblocks)
+
+	// FEED Routes
+	feeds := r.Group("/feeds")
+	router.FeedRoutes(feeds)
+
+	// ALERT Routes
+	alerts := r.Group("/alerts")
+	router.AlertRoutes(alerts)
+
 	r.Run(os.Getenv("APP_ADDRESS"))
 }
+
+// trustedProxies parses TRUSTED_PROXIES, a comma separated list of IPs and
+// CIDRs. None are trusted by default.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
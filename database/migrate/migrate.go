@@ -15,7 +15,27 @@ func MigrateModels(DB *gorm.DB) {
 	DB.AutoMigrate(&models.Policy{})
 	DB.AutoMigrate(&models.IP{})
 	DB.AutoMigrate(&models.Port{})
+	DB.AutoMigrate(&models.AddressGroup{})
+	DB.AutoMigrate(&models.GroupAddress{})
+	DB.AutoMigrate(&models.ServiceGroup{})
+	DB.AutoMigrate(&models.GroupService{})
 	DB.AutoMigrate(&models.Application{})
 	DB.AutoMigrate(&models.Tags{})
+	DB.AutoMigrate(&models.PolicyApplicationTag{})
+	DB.AutoMigrate(&models.PolicyRevision{})
+	DB.AutoMigrate(&models.AuditEvent{})
+	DB.AutoMigrate(&models.ConfigVersion{})
+	DB.AutoMigrate(&models.PolicyHit{})
+	DB.AutoMigrate(&models.ClassificationRule{})
+	DB.AutoMigrate(&models.Detection{})
+	DB.AutoMigrate(&models.DetectionThreshold{})
+	DB.AutoMigrate(&models.AutoBlock{})
+	DB.AutoMigrate(&models.Feed{})
+	DB.AutoMigrate(&models.PolicyGeoNetwork{})
+	DB.AutoMigrate(&models.PolicyHostname{})
+	DB.AutoMigrate(&models.AlertDestination{})
+	DB.AutoMigrate(&models.AlertRule{})
+	DB.AutoMigrate(&models.Alert{})
+	DB.AutoMigrate(&models.AlertSuppression{})
 	log.Println("DB Migrated Successfully")
 }
@@ -0,0 +1,320 @@
+package alerts
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hanshal101/snapwall/internal/detect"
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
+)
+
+// KindClassification is the kind of events for flows the server classified.
+const KindClassification = "classification"
+
+// Kinds lists the event kinds rules can select.
+var Kinds = append([]string{KindClassification}, detect.Kinds...)
+
+// DefaultMinSeverity applies to rules without a severity of their own.
+const DefaultMinSeverity = models.SEVERITY_HIGH
+
+const (
+	// queueSize bounds the events waiting to be grouped and the deliveries
+	// waiting to be sent; more are dropped rather than slowing down
+	// ingestion.
+	queueSize = 1000
+	workers   = 4
+)
+
+// Event is what is alerted on: a flow classified by the server or a
+// detection. Sent events describe the alert they belong to: AlertRule is
+// the rule that matched, and Count, FirstSeen and LastSeen cover the events
+// grouped into the alert so far.
+type Event struct {
+	ID          string            `json:"id"`
+	Time        time.Time         `json:"time"`
+	Kind        string            `json:"kind"`
+	Severity    models.SEVERITY   `json:"severity"`
+	Source      string            `json:"source"`
+	Destination string            `json:"destination,omitempty"`
+	Port        string            `json:"port,omitempty"`
+	Protocol    string            `json:"protocol,omitempty"`
+	Direction   string            `json:"direction,omitempty"`
+	Policies    []string          `json:"policies,omitempty"`
+	Rules       []string          `json:"classification_rules,omitempty"`
+	Detection   *models.Detection `json:"detection,omitempty"`
+
+	AlertID   uint      `json:"alert_id,omitempty"`
+	AlertRule string    `json:"alert_rule,omitempty"`
+	Status    string    `json:"status,omitempty"`
+	Count     int       `json:"count,omitempty"`
+	FirstSeen time.Time `json:"first_seen,omitempty"`
+	LastSeen  time.Time `json:"last_seen,omitempty"`
+}
+
+// Statuses of sent events: the first event of an alert, and the summary
+// sent when an alert that grouped more events closes.
+const (
+	StatusOpen    = "open"
+	StatusSummary = "summary"
+)
+
+// DetectionEvent is the event for a detection.
+func DetectionEvent(detection models.Detection) Event {
+	event := Event{
+		Time:      detection.LastSeen,
+		Kind:      detection.Kind,
+		Severity:  detection.Severity,
+		Source:    detection.Source,
+		Detection: &detection,
+	}
+	if len(detection.Ports) == 1 {
+		event.Port = detection.Ports[0]
+	}
+	if len(detection.Destinations) == 1 {
+		event.Destination = detection.Destinations[0]
+	}
+	return event
+}
+
+// Sender delivers events to one type of destination.
+type Sender interface {
+	Send(ctx context.Context, destination models.AlertDestination, event Event) error
+}
+
+// senders holds the Sender of each destination type.
+var senders = map[string]Sender{}
+
+// Types lists the destination types events can be sent to.
+var Types []string
+
+func register(destinationType string, sender Sender) {
+	senders[destinationType] = sender
+	Types = append(Types, destinationType)
+}
+
+// PermanentError marks a failed delivery that is not worth retrying, such as
+// a webhook rejecting the request.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// Send delivers an event to a destination once.
+func Send(ctx context.Context, destination models.AlertDestination, event Event) error {
+	sender, ok := senders[destination.Type]
+	if !ok {
+		return &PermanentError{Err: fmt.Errorf("unknown destination type %q", destination.Type)}
+	}
+	return sender.Send(ctx, destination, event)
+}
+
+// Config sets how deliveries are retried: up to Attempts times, waiting
+// Backoff after the first failure and twice as long after each further
+// one, at most MaxBackoff.
+type Config struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// Dispatcher matches events against the alert rules, groups them into
+// alerts and sends those to the rules' destinations in the background.
+type Dispatcher struct {
+	db     *gorm.DB
+	config Config
+	events chan Event
+	queue  chan delivery
+	// groups holds the open alerts by groupKey. Only the aggregating
+	// goroutine uses it.
+	groups map[string]*group
+
+	mu           sync.RWMutex
+	rules        []models.AlertRule
+	destinations map[uint]models.AlertDestination
+	suppressions []suppression
+}
+
+type delivery struct {
+	destination models.AlertDestination
+	event       Event
+}
+
+func New(db *gorm.DB, config Config) *Dispatcher {
+	if config.Attempts < 1 {
+		config.Attempts = 1
+	}
+	return &Dispatcher{
+		db:     db,
+		config: config,
+		events: make(chan Event, queueSize),
+		queue:  make(chan delivery, queueSize),
+		groups: make(map[string]*group),
+	}
+}
+
+// Start loads the rules, destinations and suppressions every interval,
+// groups notified events and sends queued deliveries until ctx is done.
+func (d *Dispatcher) Start(ctx context.Context, interval time.Duration) {
+	// Alerts left open by a previous run have lost their events.
+	if err := d.db.Model(&models.Alert{}).Where("closed_at IS NULL").Update("closed_at", time.Now()).Error; err != nil {
+		log.Printf("Error in closing stale alerts: %v", err)
+	}
+
+	for i := 0; i < workers; i++ {
+		go d.work(ctx)
+	}
+	go d.aggregate(ctx)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := d.Reload(); err != nil {
+				log.Printf("Error in loading alert rules: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Reload reads the enabled rules, destinations and suppressions.
+func (d *Dispatcher) Reload() error {
+	var rules []models.AlertRule
+	if err := d.db.Where("enabled").Find(&rules).Error; err != nil {
+		return err
+	}
+	var destinations []models.AlertDestination
+	if err := d.db.Where("enabled").Find(&destinations).Error; err != nil {
+		return err
+	}
+	suppressions, err := loadSuppressions(d.db)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[uint]models.AlertDestination, len(destinations))
+	for _, destination := range destinations {
+		byID[destination.ID] = destination
+	}
+	d.mu.Lock()
+	d.rules, d.destinations, d.suppressions = rules, byID, suppressions
+	d.mu.Unlock()
+	return nil
+}
+
+// Notify hands an event matched by some rule to the dispatcher. It does not
+// block; a nil Dispatcher drops every event.
+func (d *Dispatcher) Notify(event Event) {
+	if d == nil {
+		return
+	}
+	d.mu.RLock()
+	matched := false
+	for _, rule := range d.rules {
+		if Matches(rule, event) {
+			matched = true
+			break
+		}
+	}
+	d.mu.RUnlock()
+	if !matched {
+		return
+	}
+
+	select {
+	case d.events <- event:
+	default:
+		log.Printf("Dropping %s event from %s: queue is full", event.Kind, event.Source)
+	}
+}
+
+// Matches reports whether a rule selects an event.
+func Matches(rule models.AlertRule, event Event) bool {
+	minSeverity := rule.MinSeverity
+	if minSeverity == "" {
+		minSeverity = DefaultMinSeverity
+	}
+	if event.Severity.Rank() < minSeverity.Rank() {
+		return false
+	}
+	if len(rule.Kinds) == 0 {
+		return true
+	}
+	for _, kind := range rule.Kinds {
+		if kind == event.Kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Dispatcher) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case next := <-d.queue:
+			err := d.deliver(ctx, next)
+			d.record(next.destination, err)
+		}
+	}
+}
+
+// deliver sends one delivery, retrying with backoff until it succeeds, fails
+// permanently or runs out of attempts.
+func (d *Dispatcher) deliver(ctx context.Context, next delivery) error {
+	backoff := d.config.Backoff
+	var err error
+	for attempt := 1; attempt <= d.config.Attempts; attempt++ {
+		if err = Send(ctx, next.destination, next.event); err == nil {
+			return nil
+		}
+		var permanent *PermanentError
+		if errors.Is(err, context.Canceled) || errors.As(err, &permanent) || attempt == d.config.Attempts {
+			break
+		}
+		log.Printf("Error in sending alert %s to %s (attempt %d): %v", next.event.ID, next.destination.Name, attempt, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; d.config.MaxBackoff > 0 && backoff > d.config.MaxBackoff {
+			backoff = d.config.MaxBackoff
+		}
+	}
+	log.Printf("Giving up on alert %s to %s: %v", next.event.ID, next.destination.Name, err)
+	return err
+}
+
+// record keeps the outcome of a delivery on its destination.
+func (d *Dispatcher) record(destination models.AlertDestination, err error) {
+	message := ""
+	if err != nil {
+		message = err.Error()
+	}
+	if err := d.db.Model(&models.AlertDestination{}).Where("id = ?", destination.ID).
+		Updates(map[string]interface{}{"delivered_at": time.Now(), "error": message}).Error; err != nil {
+		log.Printf("Error in saving delivery to %s: %v", destination.Name, err)
+	}
+}
+
+// NewID returns a random event ID.
+func NewID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,725 @@
+package alerts
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanshal101/snapwall/database/psql"
+	"github.com/hanshal101/snapwall/internal/audit"
+	"github.com/hanshal101/snapwall/internal/policies"
+	"github.com/hanshal101/snapwall/models"
+)
+
+// DestinationRequest creates or updates a destination. A nil Secret keeps
+// the secret of an existing destination; an empty one removes it. For smtp
+// destinations Secret is the password.
+type DestinationRequest struct {
+	Name    string            `json:"name"`
+	Type    string            `json:"type"`
+	URL     string            `json:"url"`
+	Secret  *string           `json:"secret"`
+	Headers map[string]string `json:"headers"`
+	Enabled bool              `json:"enabled"`
+
+	SMTPAddress     string   `json:"smtp_address"`
+	SMTPSecurity    string   `json:"smtp_security"`
+	Username        string   `json:"username"`
+	From            string   `json:"from"`
+	To              []string `json:"to"`
+	SubjectTemplate string   `json:"subject_template"`
+	BodyTemplate    string   `json:"body_template"`
+}
+
+// validateDestination checks a request to create a destination, or to update
+// the destination with the given ID.
+func validateDestination(req DestinationRequest, id uint) []policies.FieldError {
+	var errs []policies.FieldError
+	if strings.TrimSpace(req.Name) == "" {
+		errs = append(errs, policies.FieldError{Field: "name", Message: "is required"})
+	} else if len(req.Name) > 200 {
+		errs = append(errs, policies.FieldError{Field: "name", Message: "must be at most 200 characters"})
+	} else {
+		var count int64
+		if err := psql.DB.Model(&models.AlertDestination{}).Where("name = ? AND id <> ?", req.Name, id).Count(&count).Error; err != nil || count > 0 {
+			errs = append(errs, policies.FieldError{Field: "name", Message: fmt.Sprintf("destination %q already exists", req.Name)})
+		}
+	}
+
+	if _, ok := senders[req.Type]; !ok {
+		errs = append(errs, policies.FieldError{Field: "type", Message: "must be one of " + strings.Join(Types, ", ")})
+	}
+	if req.Type == TypeWebhook {
+		if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, policies.FieldError{Field: "url", Message: "must be an http or https URL"})
+		}
+	}
+	for name, value := range req.Headers {
+		if strings.TrimSpace(name) == "" || strings.ContainsAny(name+value, "\r\n") || strings.ContainsAny(name, " :") {
+			errs = append(errs, policies.FieldError{Field: "headers", Message: fmt.Sprintf("invalid header %q", name)})
+		}
+	}
+	if req.Type == TypeSMTP {
+		errs = append(errs, validateSMTP(req)...)
+	}
+	return errs
+}
+
+// validateSMTP checks the fields of an smtp destination.
+func validateSMTP(req DestinationRequest) []policies.FieldError {
+	var errs []policies.FieldError
+	host, port, err := net.SplitHostPort(req.SMTPAddress)
+	if err != nil || host == "" || port == "" {
+		errs = append(errs, policies.FieldError{Field: "smtp_address", Message: "must be host:port"})
+	}
+
+	validSecurity := req.SMTPSecurity == ""
+	for _, security := range Securities {
+		if req.SMTPSecurity == security {
+			validSecurity = true
+		}
+	}
+	if !validSecurity {
+		errs = append(errs, policies.FieldError{Field: "smtp_security", Message: "must be one of " + strings.Join(Securities, ", ")})
+	} else if req.SMTPSecurity == SecurityNone && req.Username != "" && host != "localhost" && !net.ParseIP(host).IsLoopback() {
+		errs = append(errs, policies.FieldError{Field: "smtp_security", Message: "must not be none when logging in to a remote server"})
+	}
+
+	if _, err := mail.ParseAddress(req.From); err != nil {
+		errs = append(errs, policies.FieldError{Field: "from", Message: "must be an email address"})
+	}
+	if len(req.To) == 0 {
+		errs = append(errs, policies.FieldError{Field: "to", Message: "at least one recipient is required"})
+	}
+	for i, recipient := range req.To {
+		if _, err := mail.ParseAddress(recipient); err != nil {
+			errs = append(errs, policies.FieldError{Field: fmt.Sprintf("to[%d]", i), Message: "must be an email address"})
+		}
+	}
+
+	// Rendering samples catches references to fields that do not exist, with
+	// and without a detection since only detection events have one.
+	samples := []Email{{}, {Event: Event{Detection: &models.Detection{}}}}
+	for field, text := range map[string]string{"subject_template": req.SubjectTemplate, "body_template": req.BodyTemplate} {
+		for _, sample := range samples {
+			if _, err := execute(field, text, "", sample); err != nil {
+				errs = append(errs, policies.FieldError{Field: field, Message: err.Error()})
+				break
+			}
+		}
+	}
+	return errs
+}
+
+func (req DestinationRequest) apply(destination *models.AlertDestination) {
+	destination.Name = req.Name
+	destination.Type = req.Type
+	destination.URL = req.URL
+	if req.Secret != nil {
+		destination.Secret = *req.Secret
+	}
+	destination.Headers = req.Headers
+	destination.Enabled = req.Enabled
+
+	destination.SMTPAddress = req.SMTPAddress
+	destination.SMTPSecurity = req.SMTPSecurity
+	if req.Type == TypeSMTP && req.SMTPSecurity == "" {
+		destination.SMTPSecurity = SecuritySTARTTLS
+	}
+	destination.Username = req.Username
+	destination.From = req.From
+	destination.To = req.To
+	destination.SubjectTemplate = req.SubjectTemplate
+	destination.BodyTemplate = req.BodyTemplate
+}
+
+type RuleRequest struct {
+	Name           string   `json:"name"`
+	Enabled        bool     `json:"enabled"`
+	MinSeverity    string   `json:"min_severity"`
+	Kinds          []string `json:"kinds"`
+	DestinationIDs []uint   `json:"destination_ids"`
+	GroupWindow    int      `json:"group_window"`
+}
+
+// validateRule checks a request to create a rule, or to update the rule
+// with the given ID.
+func validateRule(req RuleRequest, id uint) []policies.FieldError {
+	var errs []policies.FieldError
+	if strings.TrimSpace(req.Name) == "" {
+		errs = append(errs, policies.FieldError{Field: "name", Message: "is required"})
+	} else if len(req.Name) > 200 {
+		errs = append(errs, policies.FieldError{Field: "name", Message: "must be at most 200 characters"})
+	} else {
+		var count int64
+		if err := psql.DB.Model(&models.AlertRule{}).Where("name = ? AND id <> ?", req.Name, id).Count(&count).Error; err != nil || count > 0 {
+			errs = append(errs, policies.FieldError{Field: "name", Message: fmt.Sprintf("rule %q already exists", req.Name)})
+		}
+	}
+
+	if _, ok := models.ParseSeverity(req.MinSeverity); req.MinSeverity != "" && !ok {
+		errs = append(errs, policies.FieldError{Field: "min_severity", Message: fmt.Sprintf("%q is not a severity", req.MinSeverity)})
+	}
+
+	seenKinds := make(map[string]int)
+	for i, kind := range req.Kinds {
+		field := fmt.Sprintf("kinds[%d]", i)
+		valid := false
+		for _, known := range Kinds {
+			if kind == known {
+				valid = true
+			}
+		}
+		if !valid {
+			errs = append(errs, policies.FieldError{Field: field, Message: "must be one of " + strings.Join(Kinds, ", ")})
+			continue
+		}
+		if first, ok := seenKinds[kind]; ok {
+			errs = append(errs, policies.FieldError{Field: field, Message: fmt.Sprintf("duplicates kinds[%d]", first)})
+			continue
+		}
+		seenKinds[kind] = i
+	}
+
+	if req.GroupWindow < 0 {
+		errs = append(errs, policies.FieldError{Field: "group_window", Message: "must not be negative"})
+	}
+
+	if len(req.DestinationIDs) == 0 {
+		errs = append(errs, policies.FieldError{Field: "destination_ids", Message: "at least one destination is required"})
+	}
+	seenDestinations := make(map[uint]int)
+	for i, destinationID := range req.DestinationIDs {
+		field := fmt.Sprintf("destination_ids[%d]", i)
+		if first, ok := seenDestinations[destinationID]; ok {
+			errs = append(errs, policies.FieldError{Field: field, Message: fmt.Sprintf("duplicates destination_ids[%d]", first)})
+			continue
+		}
+		seenDestinations[destinationID] = i
+		var count int64
+		if err := psql.DB.Model(&models.AlertDestination{}).Where("id = ?", destinationID).Count(&count).Error; err != nil || count == 0 {
+			errs = append(errs, policies.FieldError{Field: field, Message: fmt.Sprintf("%d does not exist", destinationID)})
+		}
+	}
+	return errs
+}
+
+func (req RuleRequest) apply(rule *models.AlertRule) {
+	rule.Name = req.Name
+	rule.Enabled = req.Enabled
+	rule.MinSeverity, _ = models.ParseSeverity(req.MinSeverity)
+	if req.MinSeverity == "" {
+		rule.MinSeverity = DefaultMinSeverity
+	}
+	rule.Kinds = req.Kinds
+	rule.DestinationIDs = req.DestinationIDs
+	rule.GroupWindow = req.GroupWindow
+}
+
+// SuppressionRequest creates or updates a suppression. StartsAt and EndsAt
+// are RFC 3339 times.
+type SuppressionRequest struct {
+	Name     string     `json:"name"`
+	Comment  string     `json:"comment"`
+	Sources  []string   `json:"sources"`
+	Kinds    []string   `json:"kinds"`
+	StartsAt *time.Time `json:"starts_at"`
+	EndsAt   *time.Time `json:"ends_at"`
+	Enabled  bool       `json:"enabled"`
+}
+
+// validateSuppression checks a request to create a suppression, or to
+// update the suppression with the given ID. A suppression without sources
+// must end, so nothing is silenced for good by accident.
+func validateSuppression(req SuppressionRequest, id uint) []policies.FieldError {
+	var errs []policies.FieldError
+	if strings.TrimSpace(req.Name) == "" {
+		errs = append(errs, policies.FieldError{Field: "name", Message: "is required"})
+	} else if len(req.Name) > 200 {
+		errs = append(errs, policies.FieldError{Field: "name", Message: "must be at most 200 characters"})
+	} else {
+		var count int64
+		if err := psql.DB.Model(&models.AlertSuppression{}).Where("name = ? AND id <> ?", req.Name, id).Count(&count).Error; err != nil || count > 0 {
+			errs = append(errs, policies.FieldError{Field: "name", Message: fmt.Sprintf("suppression %q already exists", req.Name)})
+		}
+	}
+
+	for i, source := range req.Sources {
+		if _, err := policies.ParseAddress(source); err != nil {
+			errs = append(errs, policies.FieldError{Field: fmt.Sprintf("sources[%d]", i), Message: err.Error()})
+		}
+	}
+	for i, kind := range req.Kinds {
+		valid := false
+		for _, known := range Kinds {
+			if kind == known {
+				valid = true
+			}
+		}
+		if !valid {
+			errs = append(errs, policies.FieldError{Field: fmt.Sprintf("kinds[%d]", i), Message: "must be one of " + strings.Join(Kinds, ", ")})
+		}
+	}
+
+	if len(req.Sources) == 0 && req.EndsAt == nil {
+		errs = append(errs, policies.FieldError{Field: "ends_at", Message: "is required without sources"})
+	}
+	if req.StartsAt != nil && req.EndsAt != nil && !req.EndsAt.After(*req.StartsAt) {
+		errs = append(errs, policies.FieldError{Field: "ends_at", Message: "must be after starts_at"})
+	}
+	return errs
+}
+
+func (req SuppressionRequest) apply(s *models.AlertSuppression) {
+	s.Name = req.Name
+	s.Comment = req.Comment
+	s.Sources = req.Sources
+	s.Kinds = req.Kinds
+	s.StartsAt = req.StartsAt
+	s.EndsAt = req.EndsAt
+	s.Enabled = req.Enabled
+}
+
+// ALERTS
+
+// GetAlerts lists alerts, most recently seen first. It accepts the filters
+// source, rule (an alert rule ID), kind, open=true for alerts still
+// grouping events, suppressed=true or false, since/until as RFC 3339 times
+// of the last event, and limit.
+func GetAlerts(c *gin.Context) {
+	query := psql.DB.Model(&models.Alert{})
+
+	if source := c.Query("source"); source != "" {
+		query = query.Where("source = ?", source)
+	}
+	if rule := c.Query("rule"); rule != "" {
+		query = query.Where("rule_id = ?", rule)
+	}
+	if kind := c.Query("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	if c.Query("open") == "true" {
+		query = query.Where("closed_at IS NULL")
+	}
+	switch c.Query("suppressed") {
+	case "true":
+		query = query.Where("suppressed <> ''")
+	case "false":
+		query = query.Where("suppressed = ''")
+	}
+	for param, cond := range map[string]string{"since": "last_seen >= ?", "until": "last_seen <= ?"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s: expected RFC 3339 time", param)})
+			return
+		}
+		query = query.Where(cond, t)
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		query = query.Limit(limit)
+	}
+
+	var alerts []models.Alert
+	if err := query.Order("last_seen DESC").Find(&alerts).Error; err != nil {
+		log.Printf("Error in fetching alerts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching alerts"})
+		return
+	}
+	c.JSON(http.StatusOK, alerts)
+}
+
+func GetAlert(c *gin.Context) {
+	var alert models.Alert
+	if err := psql.DB.First(&alert, c.Param("alertID")).Error; err != nil {
+		log.Printf("Error fetching alert: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert not found"})
+		return
+	}
+	c.JSON(http.StatusOK, alert)
+}
+
+// DESTINATIONS
+
+func GetDestinations(c *gin.Context) {
+	var destinations []models.AlertDestination
+	if err := psql.DB.Order("name").Find(&destinations).Error; err != nil {
+		log.Printf("Error in fetching alert destinations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching alert destinations"})
+		return
+	}
+	c.JSON(http.StatusOK, destinations)
+}
+
+func CreateDestination(c *gin.Context) {
+	var req DestinationRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Printf("Error in binding alert destination: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error in binding alert destination"})
+		return
+	}
+
+	if errs := validateDestination(req, 0); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert destination", "errors": errs})
+		return
+	}
+
+	var destination models.AlertDestination
+	req.apply(&destination)
+
+	tx := psql.DB.Begin()
+	if err := tx.Create(&destination).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error in creating alert destination: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in creating alert destination"})
+		return
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "create", audit.ObjectAlertDestination, destination.ID, nil, destination); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing alert destination: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing alert destination"})
+		return
+	}
+	tx.Commit()
+	c.JSON(http.StatusOK, destination)
+}
+
+func UpdateDestination(c *gin.Context) {
+	var req DestinationRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Printf("Error in binding alert destination: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error in binding alert destination"})
+		return
+	}
+
+	var destination models.AlertDestination
+	if err := psql.DB.First(&destination, c.Param("destinationID")).Error; err != nil {
+		log.Printf("Error fetching alert destination: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert destination not found"})
+		return
+	}
+
+	if errs := validateDestination(req, destination.ID); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert destination", "errors": errs})
+		return
+	}
+
+	previous := destination
+	req.apply(&destination)
+
+	tx := psql.DB.Begin()
+	if err := tx.Save(&destination).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error saving alert destination: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving alert destination"})
+		return
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "update", audit.ObjectAlertDestination, destination.ID, previous, destination); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing alert destination: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing alert destination"})
+		return
+	}
+	tx.Commit()
+	c.JSON(http.StatusOK, destination)
+}
+
+// DeleteDestination refuses to delete a destination that rules still send
+// to.
+func DeleteDestination(c *gin.Context) {
+	var destination models.AlertDestination
+	if err := psql.DB.First(&destination, c.Param("destinationID")).Error; err != nil {
+		log.Printf("Error fetching alert destination: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert destination not found"})
+		return
+	}
+
+	var dependents []uint
+	if err := psql.DB.Model(&models.AlertRule{}).
+		Where("destination_ids @> ?", fmt.Sprintf("[%d]", destination.ID)).
+		Pluck("id", &dependents).Error; err != nil {
+		log.Printf("Error fetching dependent alert rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching dependent alert rules"})
+		return
+	}
+	if len(dependents) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Destination is referenced by alert rules", "rules": dependents})
+		return
+	}
+
+	tx := psql.DB.Begin()
+	if err := tx.Delete(&destination).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error in deleting alert destination: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in deleting alert destination"})
+		return
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "delete", audit.ObjectAlertDestination, destination.ID, destination, nil); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing alert destination: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing alert destination"})
+		return
+	}
+	tx.Commit()
+	c.JSON(http.StatusOK, gin.H{"success": "Alert Destination Deleted Successfully"})
+}
+
+// TestDestination sends a test event to a destination once and reports the
+// outcome, so receivers can be checked without waiting for an intruder.
+func TestDestination(c *gin.Context) {
+	var destination models.AlertDestination
+	if err := psql.DB.First(&destination, c.Param("destinationID")).Error; err != nil {
+		log.Printf("Error fetching alert destination: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert destination not found"})
+		return
+	}
+
+	event := Event{
+		ID:       NewID(),
+		Time:     time.Now(),
+		Kind:     "test",
+		Severity: models.SEVERITY_LOW,
+		Source:   c.ClientIP(),
+	}
+	if err := Send(c.Request.Context(), destination, event); err != nil {
+		log.Printf("Error in sending test alert to %s: %v", destination.Name, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Error in sending test alert", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": "Test Alert Sent Successfully", "id": event.ID})
+}
+
+// RULES
+
+func GetRules(c *gin.Context) {
+	var rules []models.AlertRule
+	if err := psql.DB.Order("name").Find(&rules).Error; err != nil {
+		log.Printf("Error in fetching alert rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching alert rules"})
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+func CreateRule(c *gin.Context) {
+	var req RuleRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Printf("Error in binding alert rule: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error in binding alert rule"})
+		return
+	}
+
+	if errs := validateRule(req, 0); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert rule", "errors": errs})
+		return
+	}
+
+	var rule models.AlertRule
+	req.apply(&rule)
+
+	tx := psql.DB.Begin()
+	if err := tx.Create(&rule).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error in creating alert rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in creating alert rule"})
+		return
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "create", audit.ObjectAlertRule, rule.ID, nil, rule); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing alert rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing alert rule"})
+		return
+	}
+	tx.Commit()
+	c.JSON(http.StatusOK, rule)
+}
+
+func UpdateRule(c *gin.Context) {
+	var req RuleRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Printf("Error in binding alert rule: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error in binding alert rule"})
+		return
+	}
+
+	var rule models.AlertRule
+	if err := psql.DB.First(&rule, c.Param("ruleID")).Error; err != nil {
+		log.Printf("Error fetching alert rule: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert rule not found"})
+		return
+	}
+
+	if errs := validateRule(req, rule.ID); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert rule", "errors": errs})
+		return
+	}
+
+	previous := rule
+	req.apply(&rule)
+
+	tx := psql.DB.Begin()
+	if err := tx.Save(&rule).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error saving alert rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving alert rule"})
+		return
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "update", audit.ObjectAlertRule, rule.ID, previous, rule); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing alert rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing alert rule"})
+		return
+	}
+	tx.Commit()
+	c.JSON(http.StatusOK, rule)
+}
+
+func DeleteRule(c *gin.Context) {
+	var rule models.AlertRule
+	if err := psql.DB.First(&rule, c.Param("ruleID")).Error; err != nil {
+		log.Printf("Error fetching alert rule: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert rule not found"})
+		return
+	}
+
+	tx := psql.DB.Begin()
+	if err := tx.Delete(&rule).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error in deleting alert rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in deleting alert rule"})
+		return
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "delete", audit.ObjectAlertRule, rule.ID, rule, nil); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing alert rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing alert rule"})
+		return
+	}
+	tx.Commit()
+	c.JSON(http.StatusOK, gin.H{"success": "Alert Rule Deleted Successfully"})
+}
+
+// SUPPRESSIONS
+
+func GetSuppressions(c *gin.Context) {
+	var suppressions []models.AlertSuppression
+	if err := psql.DB.Order("name").Find(&suppressions).Error; err != nil {
+		log.Printf("Error in fetching alert suppressions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching alert suppressions"})
+		return
+	}
+	c.JSON(http.StatusOK, suppressions)
+}
+
+func CreateSuppression(c *gin.Context) {
+	var req SuppressionRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Printf("Error in binding alert suppression: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error in binding alert suppression"})
+		return
+	}
+
+	if errs := validateSuppression(req, 0); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert suppression", "errors": errs})
+		return
+	}
+
+	var suppression models.AlertSuppression
+	req.apply(&suppression)
+
+	tx := psql.DB.Begin()
+	if err := tx.Create(&suppression).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error in creating alert suppression: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in creating alert suppression"})
+		return
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "create", audit.ObjectAlertSuppression, suppression.ID, nil, suppression); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing alert suppression: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing alert suppression"})
+		return
+	}
+	tx.Commit()
+	c.JSON(http.StatusOK, suppression)
+}
+
+func UpdateSuppression(c *gin.Context) {
+	var req SuppressionRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Printf("Error in binding alert suppression: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error in binding alert suppression"})
+		return
+	}
+
+	var suppression models.AlertSuppression
+	if err := psql.DB.First(&suppression, c.Param("suppressionID")).Error; err != nil {
+		log.Printf("Error fetching alert suppression: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert suppression not found"})
+		return
+	}
+
+	if errs := validateSuppression(req, suppression.ID); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert suppression", "errors": errs})
+		return
+	}
+
+	previous := suppression
+	req.apply(&suppression)
+
+	tx := psql.DB.Begin()
+	if err := tx.Save(&suppression).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error saving alert suppression: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving alert suppression"})
+		return
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "update", audit.ObjectAlertSuppression, suppression.ID, previous, suppression); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing alert suppression: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing alert suppression"})
+		return
+	}
+	tx.Commit()
+	c.JSON(http.StatusOK, suppression)
+}
+
+func DeleteSuppression(c *gin.Context) {
+	var suppression models.AlertSuppression
+	if err := psql.DB.First(&suppression, c.Param("suppressionID")).Error; err != nil {
+		log.Printf("Error fetching alert suppression: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert suppression not found"})
+		return
+	}
+
+	tx := psql.DB.Begin()
+	if err := tx.Delete(&suppression).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error in deleting alert suppression: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in deleting alert suppression"})
+		return
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "delete", audit.ObjectAlertSuppression, suppression.ID, suppression, nil); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing alert suppression: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing alert suppression"})
+		return
+	}
+	tx.Commit()
+	c.JSON(http.StatusOK, gin.H{"success": "Alert Suppression Deleted Successfully"})
+}
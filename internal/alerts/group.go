@@ -0,0 +1,164 @@
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hanshal101/snapwall/models"
+)
+
+// DefaultGroupWindow applies to rules without a group window of their own.
+const DefaultGroupWindow = 5 * time.Minute
+
+// closeCheck is how often open alerts are checked for having closed.
+const closeCheck = time.Second
+
+// group is an open alert: the first event of a rule's group and the count
+// of events since.
+type group struct {
+	alert  models.Alert
+	rule   models.AlertRule
+	first  Event
+	closes time.Time
+}
+
+// groupKey identifies the events a rule groups into one alert.
+func groupKey(rule models.AlertRule, event Event) string {
+	return fmt.Sprintf("%d|%s|%s|%s|%s", rule.ID, event.Source, event.Kind,
+		strings.Join(event.Policies, ","), strings.Join(event.Rules, ","))
+}
+
+// GroupWindow is how long a rule groups events into one alert.
+func GroupWindow(rule models.AlertRule) time.Duration {
+	if rule.GroupWindow <= 0 {
+		return DefaultGroupWindow
+	}
+	return time.Duration(rule.GroupWindow) * time.Second
+}
+
+// aggregate groups notified events into alerts and closes alerts whose
+// window has passed, until ctx is done.
+func (d *Dispatcher) aggregate(ctx context.Context) {
+	ticker := time.NewTicker(closeCheck)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			d.closeGroups(time.Now(), true)
+			return
+		case event := <-d.events:
+			d.add(event, time.Now())
+		case now := <-ticker.C:
+			d.closeGroups(now, false)
+		}
+	}
+}
+
+// add counts an event into the open alert of every rule it matches, opening
+// and sending new alerts as needed.
+func (d *Dispatcher) add(event Event, now time.Time) {
+	d.mu.RLock()
+	rules, suppressions := d.rules, d.suppressions
+	d.mu.RUnlock()
+
+	suppressedBy := suppressing(suppressions, event, now)
+	for _, rule := range rules {
+		if !Matches(rule, event) {
+			continue
+		}
+		key := groupKey(rule, event)
+		if g, ok := d.groups[key]; ok {
+			g.alert.Count++
+			g.alert.LastSeen = event.Time
+			if event.Severity.Rank() > g.alert.Severity.Rank() {
+				g.alert.Severity = event.Severity
+			}
+			continue
+		}
+
+		g := &group{
+			rule:   rule,
+			first:  event,
+			closes: now.Add(GroupWindow(rule)),
+			alert: models.Alert{
+				RuleID:     rule.ID,
+				Kind:       event.Kind,
+				Source:     event.Source,
+				Policies:   event.Policies,
+				Rules:      event.Rules,
+				Severity:   event.Severity,
+				Count:      1,
+				FirstSeen:  event.Time,
+				LastSeen:   event.Time,
+				Suppressed: suppressedBy,
+			},
+		}
+		if err := d.db.Create(&g.alert).Error; err != nil {
+			log.Printf("Error in storing alert: %v", err)
+		}
+		d.groups[key] = g
+		if suppressedBy == "" {
+			d.send(g, StatusOpen)
+		}
+	}
+}
+
+// closeGroups closes the alerts whose window has passed, or all of them,
+// sending a summary of those that grouped more events than were sent.
+func (d *Dispatcher) closeGroups(now time.Time, all bool) {
+	d.mu.RLock()
+	suppressions := d.suppressions
+	d.mu.RUnlock()
+
+	for key, g := range d.groups {
+		if !all && now.Before(g.closes) {
+			continue
+		}
+		delete(d.groups, key)
+
+		if g.alert.Count > 1 && g.alert.Suppressed == "" {
+			if name := suppressing(suppressions, g.first, now); name != "" {
+				g.alert.Suppressed = name
+			} else {
+				d.send(g, StatusSummary)
+			}
+		}
+		g.alert.ClosedAt = &now
+		if err := d.db.Model(&models.Alert{ID: g.alert.ID}).
+			Select("Severity", "Count", "LastSeen", "ClosedAt", "Suppressed").
+			Updates(g.alert).Error; err != nil {
+			log.Printf("Error in saving alert %d: %v", g.alert.ID, err)
+		}
+	}
+}
+
+// send queues an alert for the destinations of its rule.
+func (d *Dispatcher) send(g *group, status string) {
+	event := g.first
+	event.ID = NewID()
+	event.AlertID = g.alert.ID
+	event.AlertRule = g.rule.Name
+	event.Status = status
+	event.Severity = g.alert.Severity
+	event.Count = g.alert.Count
+	event.FirstSeen = g.alert.FirstSeen
+	event.LastSeen = g.alert.LastSeen
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, id := range g.rule.DestinationIDs {
+		destination, ok := d.destinations[id]
+		if !ok {
+			continue
+		}
+		select {
+		case d.queue <- delivery{destination: destination, event: event}:
+		default:
+			log.Printf("Dropping alert %d for %s: queue is full", g.alert.ID, destination.Name)
+		}
+	}
+}
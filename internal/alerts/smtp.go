@@ -0,0 +1,267 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hanshal101/snapwall/internal/logs"
+	"github.com/hanshal101/snapwall/models"
+)
+
+// TypeSMTP destinations receive events as plain text emails.
+const TypeSMTP = "smtp"
+
+// Securities of the connection to an SMTP server: upgraded with STARTTLS,
+// TLS from the start (usually port 465), or unencrypted. Servers other than
+// localhost only accept a password over an encrypted connection.
+const (
+	SecuritySTARTTLS = "starttls"
+	SecurityTLS      = "tls"
+	SecurityNone     = "none"
+)
+
+// Securities lists the supported securities.
+var Securities = []string{SecuritySTARTTLS, SecurityTLS, SecurityNone}
+
+const (
+	// smtpTimeout bounds each delivery, from dialing to QUIT.
+	smtpTimeout = 30 * time.Second
+	// recentLogs is how many logs of the source an email lists.
+	recentLogs = 10
+)
+
+// DefaultSubject and DefaultBody are the templates of emails whose
+// destination has none of its own. Templates are executed with an Email.
+const (
+	DefaultSubject = `[snapwall] {{.Severity}} {{.Kind}} from {{.Source}}` +
+		`{{if eq .Status "summary"}} ({{.Count}} events){{end}}`
+
+	DefaultBody = `{{.Severity}} {{.Kind}} alert from {{.Source}}
+{{- if eq .Status "summary"}}: {{.Count}} events between {{time .FirstSeen}} and {{time .LastSeen}}{{end}}.
+
+Source:       {{.Source}}
+{{- if .Destination}}
+Destination:  {{.Destination}}{{end}}
+Ports:        {{if .Ports}}{{join .Ports ", "}}{{else}}-{{end}}
+Policies:     {{if .Policies}}{{join .Policies ", "}}{{else}}none{{end}}
+{{- if .Rules}}
+Rules:        {{join .Rules ", "}}{{end}}
+{{- with .Detection}}
+Detection:    {{.Count}} targets between {{time .FirstSeen}} and {{time .LastSeen}}{{end}}
+{{- if .AlertRule}}
+Alert rule:   {{.AlertRule}} (alert {{.AlertID}}){{end}}
+Time:         {{time .Time}}
+
+Recent logs from {{.Source}}:
+{{range .Logs}}  {{time .Time}}  {{.Type}} {{.Protocol}} {{.Source}} -> {{.Destination}}:{{.Port}}  {{.Severity}}
+{{else}}  none
+{{end}}`
+)
+
+// Email is what subject and body templates are executed with: the event,
+// the ports it targeted and the latest logs of its source.
+type Email struct {
+	Event
+	Ports []string
+	Logs  []models.Log
+}
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"time": func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+}
+
+// ParseTemplate parses a subject or body template.
+func ParseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+func init() {
+	register(TypeSMTP, &mailer{recent: logs.Recent})
+}
+
+type mailer struct {
+	// recent returns the latest logs of a source.
+	recent func(ctx context.Context, source string, limit int) ([]models.Log, error)
+}
+
+// Send emails the event. Answers of the server in the 5xx range, such as a
+// rejected login or recipient, are permanent failures.
+func (m *mailer) Send(ctx context.Context, destination models.AlertDestination, event Event) error {
+	ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+	defer cancel()
+
+	from, err := mail.ParseAddress(destination.From)
+	if err != nil {
+		return &PermanentError{Err: fmt.Errorf("invalid from address: %w", err)}
+	}
+	to := make([]*mail.Address, 0, len(destination.To))
+	for _, recipient := range destination.To {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return &PermanentError{Err: fmt.Errorf("invalid to address %q: %w", recipient, err)}
+		}
+		to = append(to, address)
+	}
+	msg, err := m.compose(ctx, destination, event, from, to)
+	if err != nil {
+		return &PermanentError{Err: err}
+	}
+
+	err = deliverMail(ctx, destination, from, to, msg)
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return &PermanentError{Err: err}
+	}
+	return err
+}
+
+// compose renders the email of an event.
+func (m *mailer) compose(ctx context.Context, destination models.AlertDestination, event Event, from *mail.Address, to []*mail.Address) ([]byte, error) {
+	email := Email{Event: event}
+	if event.Detection != nil {
+		email.Ports = event.Detection.Ports
+	} else if event.Port != "" {
+		email.Ports = []string{event.Port}
+	}
+	recent, err := m.recent(ctx, event.Source, recentLogs)
+	if err != nil {
+		// The alert is worth sending without them.
+		log.Printf("Error in fetching recent logs of %s: %v", event.Source, err)
+	}
+	email.Logs = recent
+
+	subject, err := render("subject", destination.SubjectTemplate, DefaultSubject, email)
+	if err != nil {
+		return nil, err
+	}
+	body, err := render("body", destination.BodyTemplate, DefaultBody, email)
+	if err != nil {
+		return nil, err
+	}
+	// Folding the subject onto one line keeps it from adding headers.
+	subject = strings.Join(strings.Fields(subject), " ")
+
+	recipients := make([]string, 0, len(to))
+	for _, address := range to {
+		recipients = append(recipients, address.String())
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@snapwall>\r\n", event.ID)
+	fmt.Fprintf(&msg, "%s: %s\r\n", EventHeader, event.Kind)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&msg)
+	qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	qp.Close()
+	return msg.Bytes(), nil
+}
+
+// execute renders text, or fallback if text is empty, with email.
+func execute(name, text, fallback string, email Email) (string, error) {
+	if text == "" {
+		text = fallback
+	}
+	tmpl, err := ParseTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, email); err != nil {
+		return "", fmt.Errorf("error executing %s template: %w", name, err)
+	}
+	return out.String(), nil
+}
+
+// render executes a template of a destination, falling back to the default
+// if it fails on this event, so the alert is still sent.
+func render(name, text, fallback string, email Email) (string, error) {
+	out, err := execute(name, text, fallback, email)
+	if err != nil && text != "" {
+		log.Printf("Error in rendering %s template, using the default: %v", name, err)
+		return execute(name, "", fallback, email)
+	}
+	return out, err
+}
+
+// deliverMail sends msg through the SMTP server of a destination.
+func deliverMail(ctx context.Context, destination models.AlertDestination, from *mail.Address, to []*mail.Address, msg []byte) error {
+	host, _, err := net.SplitHostPort(destination.SMTPAddress)
+	if err != nil {
+		return &PermanentError{Err: fmt.Errorf("invalid smtp address: %w", err)}
+	}
+	tlsConfig := &tls.Config{ServerName: host}
+
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	if destination.SMTPSecurity == SecurityTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", destination.SMTPAddress)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", destination.SMTPAddress)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if destination.SMTPSecurity == "" || destination.SMTPSecurity == SecuritySTARTTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return &PermanentError{Err: fmt.Errorf("%s does not support STARTTLS", destination.SMTPAddress)}
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if destination.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", destination.Username, destination.Secret, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, address := range to {
+		if err := client.Rcpt(address.Address); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,88 @@
+package alerts
+
+import (
+	"log"
+	"net/netip"
+	"time"
+
+	"github.com/hanshal101/snapwall/internal/policies"
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
+)
+
+// suppression is an AlertSuppression with its sources parsed.
+type suppression struct {
+	models.AlertSuppression
+	sources []netip.Prefix
+}
+
+// loadSuppressions reads the enabled suppressions that have not ended.
+func loadSuppressions(db *gorm.DB) ([]suppression, error) {
+	var all []models.AlertSuppression
+	if err := db.Where("enabled AND (ends_at IS NULL OR ends_at > ?)", time.Now()).Find(&all).Error; err != nil {
+		return nil, err
+	}
+
+	suppressions := make([]suppression, 0, len(all))
+	for _, s := range all {
+		compiled := suppression{AlertSuppression: s}
+		for _, source := range s.Sources {
+			prefix, err := policies.ParseAddress(source)
+			if err != nil {
+				log.Printf("Skipping source %q of suppression %s: %v", source, s.Name, err)
+				continue
+			}
+			compiled.sources = append(compiled.sources, prefix)
+		}
+		suppressions = append(suppressions, compiled)
+	}
+	return suppressions, nil
+}
+
+// matches reports whether the suppression applies to an event at now.
+func (s suppression) matches(event Event, now time.Time) bool {
+	if s.StartsAt != nil && now.Before(*s.StartsAt) {
+		return false
+	}
+	if s.EndsAt != nil && !now.Before(*s.EndsAt) {
+		return false
+	}
+
+	if len(s.Kinds) > 0 {
+		found := false
+		for _, kind := range s.Kinds {
+			if kind == event.Kind {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(s.Sources) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(event.Source)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range s.sources {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// suppressing returns the name of the first suppression applying to an
+// event, or "" if none does.
+func suppressing(suppressions []suppression, event Event, now time.Time) string {
+	for _, s := range suppressions {
+		if s.matches(event, now) {
+			return s.Name
+		}
+	}
+	return ""
+}
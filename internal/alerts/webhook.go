@@ -0,0 +1,94 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hanshal101/snapwall/models"
+)
+
+// TypeWebhook destinations receive events as JSON POST requests.
+const TypeWebhook = "webhook"
+
+// Headers set on webhook requests. The signature lets receivers check that
+// a request comes from snapwall, see Sign.
+const (
+	EventHeader     = "X-Snapwall-Event"
+	DeliveryHeader  = "X-Snapwall-Delivery"
+	TimestampHeader = "X-Snapwall-Timestamp"
+	SignatureHeader = "X-Snapwall-Signature"
+)
+
+// webhookTimeout bounds each webhook request.
+const webhookTimeout = 10 * time.Second
+
+func init() {
+	register(TypeWebhook, &webhook{client: &http.Client{Timeout: webhookTimeout}})
+}
+
+// Sign returns the signature of a webhook body: "sha256=" and the hex
+// HMAC-SHA256, keyed with the destination's secret, of the Unix timestamp
+// sent in TimestampHeader, a dot and the body. Including the timestamp lets
+// receivers reject replayed requests.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type webhook struct {
+	client *http.Client
+}
+
+// Send posts the event. Network errors, 429 and 5xx answers can be retried;
+// other answers outside 2xx are permanent failures.
+func (w *webhook) Send(ctx context.Context, destination models.AlertDestination, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return &PermanentError{Err: fmt.Errorf("error encoding event: %w", err)}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, destination.URL, bytes.NewReader(body))
+	if err != nil {
+		return &PermanentError{Err: err}
+	}
+	for name, value := range destination.Headers {
+		req.Header.Set(name, value)
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "snapwall-alerts")
+	req.Header.Set(EventHeader, event.Kind)
+	req.Header.Set(DeliveryHeader, event.ID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	if destination.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(destination.Secret, timestamp, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("%s answered %s", destination.URL, resp.Status)
+	default:
+		return &PermanentError{Err: fmt.Errorf("%s answered %s", destination.URL, resp.Status)}
+	}
+}
@@ -1,11 +1,16 @@
 package application
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hanshal101/snapwall/database/psql"
+	"github.com/hanshal101/snapwall/internal/audit"
+	"github.com/hanshal101/snapwall/internal/policies"
 	"github.com/hanshal101/snapwall/models"
 )
 
@@ -16,6 +21,26 @@ type CreateApplicationRequest struct {
 	Tags        []string `json:"tags"`
 }
 
+// Validate checks an application request. The port may be empty for
+// applications only referenced by tag.
+func Validate(req CreateApplicationRequest) []policies.FieldError {
+	var errs []policies.FieldError
+	if strings.TrimSpace(req.Name) == "" {
+		errs = append(errs, policies.FieldError{Field: "name", Message: "is required"})
+	}
+	if req.Port != "" {
+		if _, _, err := policies.ParsePort(req.Port); err != nil {
+			errs = append(errs, policies.FieldError{Field: "port", Message: err.Error()})
+		}
+	}
+	for i, tag := range req.Tags {
+		if strings.TrimSpace(tag) == "" {
+			errs = append(errs, policies.FieldError{Field: fmt.Sprintf("tags[%d]", i), Message: "is empty"})
+		}
+	}
+	return errs
+}
+
 func GetApplications(c *gin.Context) {
 	var applications []models.Application
 	if err := psql.DB.Preload("Tags").Find(&applications).Error; err != nil {
@@ -30,8 +55,12 @@ func GetApplications(c *gin.Context) {
 func CreateApplication(c *gin.Context) {
 	var request CreateApplicationRequest
 	if err := c.BindJSON(&request); err != nil {
-		log.Fatalf("Error in binding request: %v\n", err)
-		c.JSON(http.StatusBadGateway, gin.H{"error": "error in binding request"})
+		log.Printf("Error in binding request: %v\n", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "error in binding request"})
+		return
+	}
+	if errs := Validate(request); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid application", "errors": errs})
 		return
 	}
 	var application = models.Application{
@@ -40,40 +69,149 @@ func CreateApplication(c *gin.Context) {
 		Description: request.Description,
 	}
 
-	var tags []models.Tags
-	for _, tagReq := range request.Tags {
-		tags = append(tags, models.Tags{
-			ApplicationID: application.ID,
-			Tag:           tagReq,
-		})
+	before, err := policies.ApplicationDependents(psql.DB, 0, request.Tags)
+	if err != nil {
+		log.Printf("Error in fetching dependent policies: %v\n", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "error in fetching dependent policies"})
+		return
 	}
 
 	tx := psql.DB.Begin()
 	if err := tx.Create(&application).Error; err != nil {
-		defer tx.Rollback()
-		log.Fatalf("Error in creating application: %v\n", err)
+		tx.Rollback()
+		log.Printf("Error in creating application: %v\n", err)
 		c.JSON(http.StatusBadGateway, gin.H{"error": "error in creating application"})
 		return
 	}
-	for _, tag := range tags {
+	for _, tagReq := range request.Tags {
+		tag := models.Tags{
+			ApplicationID: application.ID,
+			Tag:           tagReq,
+		}
 		if err := tx.Create(&tag).Error; err != nil {
-			log.Fatalf("Error in creating tag: %v\n", err)
+			tx.Rollback()
+			log.Printf("Error in creating tag: %v\n", err)
 			c.JSON(http.StatusBadGateway, gin.H{"error": "error in creating tag"})
 			return
 		}
+		application.Tags = append(application.Tags, tag)
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "create", audit.ObjectApplication, application.ID, nil, application); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing application: %v\n", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "error in auditing application"})
+		return
 	}
 	tx.Commit()
 
+	reenforceDependents(before, application.ID, request.Tags)
 	c.JSON(http.StatusOK, gin.H{"success": "Application created successfully"})
 }
 
+// UpdateApplication changes an application in place. Policies bound to the
+// application, directly or by tag, are re-enforced so their protection
+// follows the new port.
+func UpdateApplication(c *gin.Context) {
+	var request CreateApplicationRequest
+	if err := c.BindJSON(&request); err != nil {
+		log.Printf("Error in binding request: %v\n", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "error in binding request"})
+		return
+	}
+	if errs := Validate(request); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid application", "errors": errs})
+		return
+	}
+
+	var application models.Application
+	if err := psql.DB.Preload("Tags").First(&application, c.Param("applicationID")).Error; err != nil {
+		log.Printf("Error in fetching application: %v\n", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "application not found"})
+		return
+	}
+
+	before, err := policies.ApplicationDependents(psql.DB, application.ID, applicationTags(application))
+	if err != nil {
+		log.Printf("Error in fetching dependent policies: %v\n", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "error in fetching dependent policies"})
+		return
+	}
+
+	previous := application
+	application.Name = request.Name
+	application.Port = request.Port
+	application.Description = request.Description
+	application.Tags = nil
+
+	tx := psql.DB.Begin()
+	if err := tx.Omit("Tags").Save(&application).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error in updating application: %v\n", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "error in updating application"})
+		return
+	}
+	if err := tx.Where("application_id = ?", application.ID).Delete(&models.Tags{}).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error in deleting tags: %v\n", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "error in deleting tags"})
+		return
+	}
+	for _, tagReq := range request.Tags {
+		tag := models.Tags{ApplicationID: application.ID, Tag: tagReq}
+		if err := tx.Create(&tag).Error; err != nil {
+			tx.Rollback()
+			log.Printf("Error in creating tag: %v\n", err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "error in creating tag"})
+			return
+		}
+		application.Tags = append(application.Tags, tag)
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "update", audit.ObjectApplication, application.ID, previous, application); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing application: %v\n", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "error in auditing application"})
+		return
+	}
+	tx.Commit()
+
+	reenforceDependents(before, application.ID, request.Tags)
+	c.JSON(http.StatusOK, gin.H{"success": "Application updated successfully"})
+}
+
 func DeleteApplication(c *gin.Context) {
 	id := c.Param("applicationID")
-	if err := psql.DB.Where("id = ?", id).Delete(&models.Application{}).Error; err != nil {
-		log.Fatalf("Error in deleting application: %v\n", err)
+
+	var application models.Application
+	if err := psql.DB.Preload("Tags").First(&application, id).Error; err != nil {
+		log.Printf("Error in fetching application: %v\n", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "application not found"})
+		return
+	}
+
+	before, err := policies.ApplicationDependents(psql.DB, application.ID, applicationTags(application))
+	if err != nil {
+		log.Printf("Error in fetching dependent policies: %v\n", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "error in fetching dependent policies"})
+		return
+	}
+
+	tx := psql.DB.Begin()
+	if err := tx.Where("id = ?", id).Delete(&models.Application{}).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error in deleting application: %v\n", err)
 		c.JSON(http.StatusBadGateway, gin.H{"error": "error in deleting application"})
 		return
 	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "delete", audit.ObjectApplication, application.ID, application, nil); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing application: %v\n", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "error in auditing application"})
+		return
+	}
+	tx.Commit()
+
+	policies.ReenforceDependents(context.TODO(), psql.DB, before, nil)
+	c.JSON(http.StatusOK, gin.H{"success": "Application deleted successfully"})
 }
 
 func GetApplicationsbyPort(c *gin.Context) {
@@ -86,3 +224,20 @@ func GetApplicationsbyPort(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, applications)
 }
+
+func applicationTags(application models.Application) []string {
+	var tags []string
+	for _, tag := range application.Tags {
+		tags = append(tags, tag.Tag)
+	}
+	return tags
+}
+
+func reenforceDependents(before []models.Policy, applicationID uint, tags []string) {
+	after, err := policies.ApplicationDependents(psql.DB, applicationID, tags)
+	if err != nil {
+		log.Printf("Error in fetching dependent policies: %v\n", err)
+		return
+	}
+	policies.ReenforceDependents(context.TODO(), psql.DB, before, after)
+}
@@ -0,0 +1,157 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanshal101/snapwall/database/psql"
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ActorHeader lets the caller of a mutating request claim a name. Nothing
+// verifies it, so it is recorded as the claimed actor only.
+const ActorHeader = "X-Snapwall-Actor"
+
+// ActorKey is the gin context key under which Authenticate puts the
+// verified name of the caller. Callers without one are recorded as
+// "anonymous".
+const ActorKey = "snapwall.actor"
+
+const (
+	ObjectPolicy       = "policy"
+	ObjectApplication  = "application"
+	ObjectAddressGroup = "address_group"
+	ObjectServiceGroup = "service_group"
+
+	ObjectClassificationRule = "classification_rule"
+	ObjectDetectionThreshold = "detection_threshold"
+	ObjectFeed               = "feed"
+	ObjectAlertDestination   = "alert_destination"
+	ObjectAlertRule          = "alert_rule"
+	ObjectAlertSuppression   = "alert_suppression"
+)
+
+// Actor is whoever made a change: an API caller or an internal component.
+// Name is verified; Claimed is what an API caller says it is, unverified.
+type Actor struct {
+	Name     string
+	Claimed  string
+	ClientIP string
+}
+
+// ActorFrom identifies the caller of an API request.
+func ActorFrom(c *gin.Context) Actor {
+	name := c.GetString(ActorKey)
+	if name == "" {
+		name = "anonymous"
+	}
+	claimed := c.GetHeader(ActorHeader)
+	if claimed == "" {
+		if user, _, ok := c.Request.BasicAuth(); ok {
+			claimed = user
+		}
+	}
+	return Actor{Name: name, Claimed: claimed, ClientIP: c.ClientIP()}
+}
+
+// System is the actor for changes made by snapwall itself.
+func System(component string) Actor {
+	return Actor{Name: "system:" + component}
+}
+
+// Record appends an audit event inside tx, so it is only kept if the change
+// it describes is committed, and bumps the configuration version. before or
+// after may be nil.
+func Record(tx *gorm.DB, actor Actor, action, objectType string, objectID uint, before, after interface{}) error {
+	beforeJSON, err := encode(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := encode(after)
+	if err != nil {
+		return err
+	}
+
+	event := models.AuditEvent{
+		Actor:        actor.Name,
+		ClaimedActor: actor.Claimed,
+		ClientIP:     actor.ClientIP,
+		Action:       action,
+		ObjectType:   objectType,
+		ObjectID:     objectID,
+		Before:       beforeJSON,
+		After:        afterJSON,
+	}
+	if err := tx.Create(&event).Error; err != nil {
+		return fmt.Errorf("error in recording audit event: %w", err)
+	}
+	// The row stays locked until tx ends, so versions commit in order.
+	bump := models.ConfigVersion{ID: 1, Version: 1}
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"version": gorm.Expr("config_versions.version + 1")}),
+	}).Create(&bump).Error; err != nil {
+		return fmt.Errorf("error in bumping config version: %w", err)
+	}
+	return nil
+}
+
+func encode(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding audit object: %w", err)
+	}
+	return data, nil
+}
+
+// GetAuditEvents lists audit events, newest first. It accepts the filters
+// actor, claimed_actor, action, object_type, object_id, and since/until as
+// RFC 3339 times.
+func GetAuditEvents(c *gin.Context) {
+	query := psql.DB.Model(&models.AuditEvent{})
+
+	if actor := c.Query("actor"); actor != "" {
+		query = query.Where("actor = ?", actor)
+	}
+	if claimed := c.Query("claimed_actor"); claimed != "" {
+		query = query.Where("claimed_actor = ?", claimed)
+	}
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if objectType := c.Query("object_type"); objectType != "" {
+		query = query.Where("object_type = ?", objectType)
+	}
+	if objectID := c.Query("object_id"); objectID != "" {
+		query = query.Where("object_id = ?", objectID)
+	}
+	for param, cond := range map[string]string{"since": "created_at >= ?", "until": "created_at <= ?"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s: expected RFC 3339 time", param)})
+			return
+		}
+		query = query.Where(cond, t)
+	}
+
+	var events []models.AuditEvent
+	if err := query.Order("created_at DESC").Find(&events).Error; err != nil {
+		log.Printf("Error in fetching audit events: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching audit events"})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
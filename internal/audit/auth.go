@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ParseTokens parses a comma separated list of name:token pairs, as given in
+// API_TOKENS, into the names by token.
+func ParseTokens(value string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	for i, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(entry, ":")
+		name, token = strings.TrimSpace(name), strings.TrimSpace(token)
+		if !ok || name == "" || token == "" {
+			// The entry is not quoted, as it may hold a secret.
+			return nil, fmt.Errorf("invalid API token entry %d: expected name:token", i+1)
+		}
+		if _, ok := tokens[token]; ok {
+			return nil, fmt.Errorf("API token of %q is already given to another name", name)
+		}
+		tokens[token] = name
+	}
+	return tokens, nil
+}
+
+// Authenticate requires a bearer token from tokens on every request and puts
+// the name it belongs to under ActorKey. Without tokens every request is let
+// through and recorded as anonymous.
+func Authenticate(tokens map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(tokens) == 0 {
+			c.Next()
+			return
+		}
+		given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if name := lookupToken(tokens, given); ok && name != "" {
+			c.Set(ActorKey, name)
+			c.Next()
+			return
+		}
+		c.Header("WWW-Authenticate", `Bearer realm="snapwall"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	}
+}
+
+// lookupToken compares given with every token in constant time, so the
+// response time does not tell how close a guess was.
+func lookupToken(tokens map[string]string, given string) string {
+	var found string
+	for token, name := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(given)) == 1 {
+			found = name
+		}
+	}
+	return found
+}
@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseTokens(t *testing.T) {
+	tokens, err := ParseTokens(" alice:s3cret , bob:hunter2,")
+	if err != nil {
+		t.Fatalf("ParseTokens: %v", err)
+	}
+	if tokens["s3cret"] != "alice" || tokens["hunter2"] != "bob" || len(tokens) != 2 {
+		t.Errorf("tokens = %v", tokens)
+	}
+	for _, value := range []string{"alice", "alice:", ":s3cret", "alice:s3cret,bob:s3cret"} {
+		if _, err := ParseTokens(value); err == nil {
+			t.Errorf("ParseTokens(%q) accepted it", value)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name          string
+		tokens        map[string]string
+		authorization string
+		status        int
+		actor         string
+	}{
+		{"open API", nil, "", http.StatusOK, "anonymous"},
+		{"valid token", map[string]string{"s3cret": "alice"}, "Bearer s3cret", http.StatusOK, "alice"},
+		{"wrong token", map[string]string{"s3cret": "alice"}, "Bearer s3cre", http.StatusUnauthorized, ""},
+		{"no token", map[string]string{"s3cret": "alice"}, "", http.StatusUnauthorized, ""},
+		{"basic auth", map[string]string{"s3cret": "alice"}, "Basic YWxpY2U6czNjcmV0", http.StatusUnauthorized, ""},
+	}
+	for _, test := range tests {
+		var actor string
+		r := gin.New()
+		r.Use(Authenticate(test.tokens))
+		r.GET("/", func(c *gin.Context) {
+			actor = ActorFrom(c).Name
+			c.Status(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != test.status || actor != test.actor {
+			t.Errorf("%s: status %d, actor %q; want %d, %q", test.name, w.Code, actor, test.status, test.actor)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package autoblock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hanshal101/snapwall/internal/audit"
+	"github.com/hanshal101/snapwall/internal/detect"
+	"github.com/hanshal101/snapwall/internal/policies"
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
+)
+
+// Component marks the policies created here, see models.Policy.Managed.
+const Component = "autoblock"
+
+// KindClassification is the trigger kind of flows classified at or above
+// the blocking severity.
+const KindClassification = "classification"
+
+// allPorts is blocked when a trigger does not point at a single port.
+const allPorts = "1:65535"
+
+// queueSize bounds the sources waiting to be blocked; more are dropped
+// rather than slowing down ingestion.
+const queueSize = 1000
+
+// Config sets when a source is blocked: on every detection if Detections is
+// set, and after Threshold flows at or above MinSeverity within Window.
+// Blocks last Duration. Sources in Allowlist, or in the address group named
+// AllowlistGroup, are never blocked.
+type Config struct {
+	Duration       time.Duration
+	MinSeverity    models.SEVERITY
+	Threshold      int
+	Window         time.Duration
+	Detections     bool
+	Allowlist      []netip.Prefix
+	AllowlistGroup string
+}
+
+// ParseAllowlist parses a comma separated list of IPs and CIDRs.
+func ParseAllowlist(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, err := policies.ParseAddress(entry)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// Responder turns intruders into temporary enforcer policies, created
+// through the policy service. The reconciler enforces them and removes them
+// once they expire.
+type Responder struct {
+	db     *gorm.DB
+	config Config
+	queue  chan blockRequest
+
+	mu     sync.Mutex
+	recent map[string][]recentTrigger
+	// now is when a flow arrives; the window follows it, since clients can
+	// report flows late and out of order.
+	now func() time.Time
+
+	// The allow-list group, as last loaded. Only the blocking goroutine
+	// uses them.
+	allowlist   []netip.Prefix
+	allowLoaded time.Time
+}
+
+// recentTrigger is a trigger counting towards the threshold and when its
+// flow was received.
+type recentTrigger struct {
+	received time.Time
+	trigger  models.AutoBlockTrigger
+}
+
+// blockRequest is a source to block and the triggers calling for it.
+type blockRequest struct {
+	source   string
+	triggers []models.AutoBlockTrigger
+}
+
+func New(db *gorm.DB, config Config) *Responder {
+	return &Responder{
+		db:     db,
+		config: config,
+		queue:  make(chan blockRequest, queueSize),
+		recent: make(map[string][]recentTrigger),
+		now:    time.Now,
+	}
+}
+
+// Start blocks the sources Observe hands over until ctx is done. Blocks are
+// made one at a time, so the blocks of one source never race.
+func (r *Responder) Start(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case next := <-r.queue:
+				if r.allowed(next.source) {
+					log.Printf("Not blocking allow-listed source %s", next.source)
+					continue
+				}
+				if err := r.block(next.source, next.triggers); err != nil {
+					log.Printf("Error in blocking %s: %v", next.source, err)
+				}
+			}
+		}
+	}()
+}
+
+// Observe is called for every classified flow with the detections it
+// started, and hands the flow's source over to be blocked if that is called
+// for. It does not block.
+func (r *Responder) Observe(flow detect.Flow, severity models.SEVERITY, detections []models.Detection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var triggers []models.AutoBlockTrigger
+	if r.config.Detections {
+		for _, detection := range detections {
+			trigger := models.AutoBlockTrigger{
+				Time:        flow.Time,
+				Kind:        detection.Kind,
+				DetectionID: detection.ID,
+				Destination: flow.Destination,
+				Severity:    detection.Severity,
+			}
+			if len(detection.Ports) == 1 {
+				trigger.Port = detection.Ports[0]
+			}
+			triggers = append(triggers, trigger)
+		}
+	}
+
+	if severity.Rank() >= r.config.MinSeverity.Rank() && r.config.Threshold > 0 {
+		now := r.now()
+		recent := append(r.recent[flow.Source], recentTrigger{received: now, trigger: models.AutoBlockTrigger{
+			Time:        flow.Time,
+			Kind:        KindClassification,
+			Destination: flow.Destination,
+			Port:        flow.Port,
+			Severity:    severity,
+		}})
+		cutoff := now.Add(-r.config.Window)
+		for len(recent) > 0 && recent[0].received.Before(cutoff) {
+			recent = recent[1:]
+		}
+		r.recent[flow.Source] = recent
+		if len(recent) >= r.config.Threshold {
+			for _, t := range recent {
+				triggers = append(triggers, t.trigger)
+			}
+		}
+		r.forgetQuiet(cutoff)
+	}
+
+	if len(triggers) == 0 {
+		return
+	}
+	delete(r.recent, flow.Source)
+
+	select {
+	case r.queue <- blockRequest{source: flow.Source, triggers: triggers}:
+	default:
+		log.Printf("Dropping block of %s: queue is full", flow.Source)
+	}
+}
+
+// forgetQuiet drops sources whose last trigger is older than cutoff.
+func (r *Responder) forgetQuiet(cutoff time.Time) {
+	for source, recent := range r.recent {
+		if len(recent) == 0 || recent[len(recent)-1].received.Before(cutoff) {
+			delete(r.recent, source)
+		}
+	}
+}
+
+func (r *Responder) allowed(source string) bool {
+	addr, err := netip.ParseAddr(source)
+	if err != nil {
+		return true
+	}
+	addr = addr.Unmap()
+
+	if r.config.AllowlistGroup != "" && time.Since(r.allowLoaded) > time.Minute {
+		var group models.AddressGroup
+		err := r.db.Preload("Addresses").Where("name = ?", r.config.AllowlistGroup).First(&group).Error
+		if err == nil || errors.Is(err, gorm.ErrRecordNotFound) {
+			r.allowlist = nil
+			for _, address := range group.Addresses {
+				if prefix, err := policies.ParseAddress(address.Address); err == nil {
+					r.allowlist = append(r.allowlist, prefix)
+				}
+			}
+			r.allowLoaded = time.Now()
+		} else {
+			log.Printf("Error in loading allow-list group %s: %v", r.config.AllowlistGroup, err)
+		}
+	}
+
+	for _, list := range [][]netip.Prefix{r.config.Allowlist, r.allowlist} {
+		for _, prefix := range list {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// block creates the policy for source, or widens and extends the active
+// block if there is one.
+func (r *Responder) block(source string, triggers []models.AutoBlockTrigger) error {
+	tx := r.db.Begin()
+	if err := r.upsert(tx, source, triggers); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (r *Responder) upsert(tx *gorm.DB, source string, triggers []models.AutoBlockTrigger) error {
+	actor := audit.System(Component)
+	expires := time.Now().Add(r.config.Duration)
+
+	var block models.AutoBlock
+	err := tx.Where("source = ? AND released_at IS NULL AND expires_at > ?", source, time.Now()).First(&block).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		block = models.AutoBlock{Source: source, Ports: blockPorts(nil, triggers)}
+		req := r.request(source, block.Ports)
+		if errs := policies.Validate(tx, req, policies.Lockout{Ports: policies.ManagementPorts()}); len(errs) > 0 {
+			return policies.InvalidError(req.Name, errs)
+		}
+		policy, err := policies.Create(tx, req)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&policy).Update("managed", Component).Error; err != nil {
+			return fmt.Errorf("error in marking policy: %w", err)
+		}
+		policy.Managed = Component
+		if err := audit.Record(tx, actor, policies.ActionCreate, audit.ObjectPolicy, policy.ID, nil, policy); err != nil {
+			return err
+		}
+		block.PolicyID = policy.ID
+		log.Printf("Blocking %s on ports %s until %s", source, strings.Join(block.Ports, ","), expires.Format(time.RFC3339))
+
+	case err != nil:
+		return fmt.Errorf("error in fetching active block: %w", err)
+
+	default:
+		ports := blockPorts(block.Ports, triggers)
+		if strings.Join(ports, ",") != strings.Join(block.Ports, ",") {
+			req := r.request(source, ports)
+			if errs := policies.Validate(tx, req, policies.Lockout{Ports: policies.ManagementPorts()}); len(errs) > 0 {
+				return policies.InvalidError(req.Name, errs)
+			}
+			before, after, err := policies.Update(tx, block.PolicyID, req)
+			if err != nil {
+				return err
+			}
+			if err := audit.Record(tx, actor, policies.ActionUpdate, audit.ObjectPolicy, after.ID, before, after); err != nil {
+				return err
+			}
+			block.Ports = ports
+		}
+		log.Printf("Extending block of %s until %s", source, expires.Format(time.RFC3339))
+	}
+
+	block.Triggers = append(block.Triggers, triggers...)
+	block.ExpiresAt = expires
+	if err := tx.Save(&block).Error; err != nil {
+		return fmt.Errorf("error in saving block: %w", err)
+	}
+	return nil
+}
+
+func (r *Responder) request(source string, ports []string) policies.PolicyRequest {
+	return policies.PolicyRequest{
+		Name:     "autoblock-" + source,
+		Type:     "enforcer",
+		Severity: string(models.SEVERITY_HIGH),
+		IPs:      []string{source},
+		Ports:    ports,
+	}
+}
+
+// blockPorts merges the ports of triggers into ports. A trigger without a
+// single port, like a vertical scan or a flood, blocks every port.
+func blockPorts(ports []string, triggers []models.AutoBlockTrigger) []string {
+	set := make(map[string]bool)
+	for _, port := range ports {
+		set[port] = true
+	}
+	for _, trigger := range triggers {
+		port := trigger.Port
+		if port == "" {
+			port = allPorts
+		}
+		set[port] = true
+	}
+	if set[allPorts] {
+		return []string{allPorts}
+	}
+
+	merged := make([]string, 0, len(set))
+	for port := range set {
+		merged = append(merged, port)
+	}
+	sort.Strings(merged)
+	return merged
+}
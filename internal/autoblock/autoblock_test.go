@@ -0,0 +1,44 @@
+package autoblock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hanshal101/snapwall/internal/detect"
+	"github.com/hanshal101/snapwall/models"
+)
+
+func TestObserveWindowsByReceiveTime(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name string
+		gap  time.Duration
+		want bool
+	}{
+		{"within the window", time.Minute, true},
+		{"past the window", time.Minute + time.Nanosecond, false},
+	}
+	for _, test := range tests {
+		now := start
+		r := New(nil, Config{MinSeverity: models.SEVERITY_HIGH, Threshold: 2, Window: time.Minute})
+		r.now = func() time.Time { return now }
+
+		// The client's flow times are far apart, which must not matter.
+		r.Observe(detect.Flow{Time: start.Add(-time.Hour), Source: "192.0.2.1", Port: "22"}, models.SEVERITY_HIGH, nil)
+		now = now.Add(test.gap)
+		r.Observe(detect.Flow{Time: start, Source: "192.0.2.1", Port: "22"}, models.SEVERITY_HIGH, nil)
+
+		select {
+		case next := <-r.queue:
+			if !test.want {
+				t.Errorf("%s: blocked %s", test.name, next.source)
+			} else if len(next.triggers) != 2 {
+				t.Errorf("%s: triggers = %+v, want 2", test.name, next.triggers)
+			}
+		default:
+			if test.want {
+				t.Errorf("%s: not blocked", test.name)
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package autoblock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanshal101/snapwall/database/psql"
+	"github.com/hanshal101/snapwall/internal/audit"
+	"github.com/hanshal101/snapwall/internal/enforcer"
+	"github.com/hanshal101/snapwall/internal/policies"
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
+)
+
+// Expire releases blocks once they expire, checking every interval until ctx
+// is done.
+func Expire(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		var blocks []models.AutoBlock
+		if err := db.Where("released_at IS NULL AND expires_at <= ?", time.Now()).Find(&blocks).Error; err != nil {
+			log.Printf("Error in fetching expired blocks: %v", err)
+		}
+		for _, block := range blocks {
+			if err := release(ctx, db, block, audit.System(Component)); err != nil {
+				log.Printf("Error in releasing block of %s: %v", block.Source, err)
+			} else {
+				log.Printf("Released block of %s", block.Source)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// release deletes the policy of a block and removes its rules. A policy
+// already deleted by an operator only has the block marked released.
+func release(ctx context.Context, db *gorm.DB, block models.AutoBlock, actor audit.Actor) error {
+	tx := db.Begin()
+
+	policy, err := policies.Delete(tx, block.PolicyID)
+	deleted := err == nil
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		tx.Rollback()
+		return err
+	}
+	if deleted {
+		if err := audit.Record(tx, actor, policies.ActionDelete, audit.ObjectPolicy, policy.ID, policy, nil); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Model(&block).Update("released_at", time.Now()).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error in releasing block: %w", err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	if deleted {
+		ips, ports := policies.Resolve(policy)
+		return enforcer.DeleteRule(ctx, policy, ips, ports)
+	}
+	return nil
+}
+
+// GetAutoBlocks lists blocks, newest first; ?active=true lists only the ones
+// in force.
+func GetAutoBlocks(c *gin.Context) {
+	query := psql.DB.Model(&models.AutoBlock{})
+	if c.Query("active") == "true" {
+		query = query.Where("released_at IS NULL AND expires_at > ?", time.Now())
+	}
+
+	var blocks []models.AutoBlock
+	if err := query.Order("created_at DESC").Find(&blocks).Error; err != nil {
+		log.Printf("Error in fetching blocks: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching blocks"})
+		return
+	}
+	c.JSON(http.StatusOK, blocks)
+}
+
+// ReleaseAutoBlock lifts a block before it expires.
+func ReleaseAutoBlock(c *gin.Context) {
+	var block models.AutoBlock
+	if err := psql.DB.First(&block, c.Param("blockID")).Error; err != nil {
+		log.Printf("Error fetching block: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Block not found"})
+		return
+	}
+	if block.ReleasedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Block already released"})
+		return
+	}
+
+	if err := release(context.TODO(), psql.DB, block, audit.ActorFrom(c)); err != nil {
+		log.Printf("Error in releasing block: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in releasing block"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": "Block Released Successfully"})
+}
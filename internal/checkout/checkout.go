@@ -7,16 +7,23 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/hanshal101/snapwall/database/clickhouse"
+	"github.com/hanshal101/snapwall/internal/logs"
 	"github.com/hanshal101/snapwall/models"
 )
 
 func GetCheckoutIPs(c *gin.Context) {
+	where, args, err := logs.Where(c, "")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	query := `
 		SELECT DISTINCT source
-		FROM service_logs;
-	`
+		FROM service_logs
+	` + where
 
-	rows, err := clickhouse.CHClient.Query(context.TODO(), query)
+	rows, err := clickhouse.CHClient.Query(context.TODO(), query, args...)
 	if err != nil {
 		log.Printf("Error executing query: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Error executing query"})
@@ -50,13 +57,18 @@ func GetCheckoutIPs(c *gin.Context) {
 func GetChkDetailsbyIPs(c *gin.Context) {
 	source := c.Param("source")
 
+	where, args, err := logs.Where(c, "(source = ? OR destination = ?)", source, source)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	query := `
-        SELECT time, type, source, destination, port, protocol, severity
+        SELECT ` + logs.Columns + `
         FROM service_logs
-        WHERE source = ? OR destination = ?
-    `
+    ` + where
 
-	rows, err := clickhouse.CHClient.Query(context.TODO(), query, source, source)
+	rows, err := clickhouse.CHClient.Query(context.TODO(), query, args...)
 	if err != nil {
 		log.Printf("Error executing query: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Error executing query"})
@@ -64,24 +76,15 @@ func GetChkDetailsbyIPs(c *gin.Context) {
 	}
 	defer rows.Close()
 
-	var logs []models.Log
+	var entries []models.Log
 	for rows.Next() {
-		var logEntry models.Log
-
-		if err := rows.Scan(
-			&logEntry.Time,
-			&logEntry.Type,
-			&logEntry.Source,
-			&logEntry.Destination,
-			&logEntry.Port,
-			&logEntry.Protocol,
-			&logEntry.Severity,
-		); err != nil {
+		logEntry, err := logs.Scan(rows)
+		if err != nil {
 			log.Printf("Error scanning row: %v", err)
 			continue
 		}
 
-		logs = append(logs, logEntry)
+		entries = append(entries, logEntry)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -90,19 +93,24 @@ func GetChkDetailsbyIPs(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, logs)
+	c.JSON(http.StatusOK, entries)
 }
 
 func GetChkIPsPorts(c *gin.Context) {
 	source := c.Param("source")
 
+	where, args, err := logs.Where(c, "source = ?", source)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	query := `
 		SELECT DISTINCT port
 		FROM service_logs
-		WHERE source = ?
-	`
+	` + where
 
-	rows, err := clickhouse.CHClient.Query(context.TODO(), query, source)
+	rows, err := clickhouse.CHClient.Query(context.TODO(), query, args...)
 	if err != nil {
 		log.Printf("Error executing query: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Error executing query"})
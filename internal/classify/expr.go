@@ -0,0 +1,420 @@
+package classify
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Fields are the attributes of a flow an expression can refer to.
+type Fields struct {
+	Source      string
+	Destination string
+	Port        string
+	Protocol    string
+	Direction   string
+}
+
+// Field names usable in expressions.
+const (
+	FieldSource      = "source"
+	FieldDestination = "destination"
+	FieldPort        = "port"
+	FieldProtocol    = "protocol"
+	FieldDirection   = "direction"
+)
+
+func (f Fields) get(name string) string {
+	switch name {
+	case FieldSource:
+		return f.Source
+	case FieldDestination:
+		return f.Destination
+	case FieldPort:
+		return f.Port
+	case FieldProtocol:
+		return f.Protocol
+	case FieldDirection:
+		return f.Direction
+	}
+	return ""
+}
+
+// Expr is a compiled classification expression. The language has the fields
+// above, string and number literals, lists, and the operators
+//
+//	== != < <= > >= in, not in, && || ! and parentheses
+//
+// Strings compare case-insensitively. port compares numerically and is the
+// only field that can be ordered. source and destination match a CIDR
+// literal by containment, e.g. source == "10.0.0.0/8".
+//
+//	protocol == "UDP" && port in [53, 123]
+type Expr struct {
+	root node
+}
+
+// Compile parses an expression.
+func Compile(expression string) (*Expr, error) {
+	tokens, err := lex(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s at offset %d", tok, tok.pos)
+	}
+	return &Expr{root: root}, nil
+}
+
+// Match evaluates the expression for a flow.
+func (e *Expr) Match(f Fields) bool {
+	return e.root.eval(f)
+}
+
+type node interface {
+	eval(Fields) bool
+}
+
+type orNode struct{ left, right node }
+type andNode struct{ left, right node }
+type notNode struct{ operand node }
+
+func (n orNode) eval(f Fields) bool  { return n.left.eval(f) || n.right.eval(f) }
+func (n andNode) eval(f Fields) bool { return n.left.eval(f) && n.right.eval(f) }
+func (n notNode) eval(f Fields) bool { return !n.operand.eval(f) }
+
+// literal is a value compared against a field, pre-parsed for the field.
+type literal struct {
+	text   string
+	number uint64
+	prefix netip.Prefix
+	isCIDR bool
+}
+
+type compareNode struct {
+	field string
+	op    string
+	value literal
+}
+
+type inNode struct {
+	field  string
+	values []literal
+	negate bool
+}
+
+func (n compareNode) eval(f Fields) bool {
+	actual := f.get(n.field)
+	if n.field == FieldPort {
+		port, err := strconv.ParseUint(actual, 10, 16)
+		if err != nil {
+			return n.op == "!="
+		}
+		switch n.op {
+		case "==":
+			return port == n.value.number
+		case "!=":
+			return port != n.value.number
+		case "<":
+			return port < n.value.number
+		case "<=":
+			return port <= n.value.number
+		case ">":
+			return port > n.value.number
+		case ">=":
+			return port >= n.value.number
+		}
+		return false
+	}
+
+	equal := matches(actual, n.value)
+	if n.op == "!=" {
+		return !equal
+	}
+	return equal
+}
+
+func (n inNode) eval(f Fields) bool {
+	actual := f.get(n.field)
+	found := false
+	for _, value := range n.values {
+		if n.field == FieldPort {
+			port, err := strconv.ParseUint(actual, 10, 16)
+			found = err == nil && port == value.number
+		} else {
+			found = matches(actual, value)
+		}
+		if found {
+			break
+		}
+	}
+	return found != n.negate
+}
+
+func matches(actual string, value literal) bool {
+	if value.isCIDR {
+		addr, err := netip.ParseAddr(actual)
+		return err == nil && value.prefix.Contains(addr.Unmap())
+	}
+	return strings.EqualFold(actual, value.text)
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	tok := p.next()
+	if tok.kind != kind || (text != "" && tok.text != text) {
+		want := text
+		if want == "" {
+			want = kind.String()
+		}
+		return fmt.Errorf("expected %s, got %s at offset %d", want, tok, tok.pos)
+	}
+	return nil
+}
+
+func (p *parser) or() (node, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("||") {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("&&") {
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (node, error) {
+	switch tok := p.peek(); {
+	case tok.is("!"):
+		p.next()
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	case tok.is("("):
+		p.next()
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(tokPunct, ")")
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (node, error) {
+	tok := p.next()
+	if tok.kind != tokIdent {
+		return nil, fmt.Errorf("expected a field, got %s at offset %d", tok, tok.pos)
+	}
+	field := tok.text
+	switch field {
+	case FieldSource, FieldDestination, FieldPort, FieldProtocol, FieldDirection:
+	default:
+		return nil, fmt.Errorf("unknown field %q at offset %d", field, tok.pos)
+	}
+
+	op := p.next()
+	switch {
+	case op.kind == tokIdent && (op.text == "in" || op.text == "not"):
+		negate := op.text == "not"
+		if negate {
+			if err := p.expect(tokIdent, "in"); err != nil {
+				return nil, err
+			}
+		}
+		values, err := p.list(field)
+		if err != nil {
+			return nil, err
+		}
+		return inNode{field: field, values: values, negate: negate}, nil
+	case op.kind == tokPunct && (op.text == "==" || op.text == "!="):
+	case op.kind == tokPunct && (op.text == "<" || op.text == "<=" || op.text == ">" || op.text == ">="):
+		if field != FieldPort {
+			return nil, fmt.Errorf("%s cannot be compared with %s at offset %d", field, op.text, op.pos)
+		}
+	default:
+		return nil, fmt.Errorf("expected an operator after %s, got %s at offset %d", field, op, op.pos)
+	}
+
+	value, err := p.literal(field)
+	if err != nil {
+		return nil, err
+	}
+	return compareNode{field: field, op: op.text, value: value}, nil
+}
+
+func (p *parser) list(field string) ([]literal, error) {
+	if err := p.expect(tokPunct, "["); err != nil {
+		return nil, err
+	}
+	var values []literal
+	if p.peek().is("]") {
+		p.next()
+		return values, nil
+	}
+	for {
+		value, err := p.literal(field)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		if p.peek().is(",") {
+			p.next()
+			continue
+		}
+		return values, p.expect(tokPunct, "]")
+	}
+}
+
+func (p *parser) literal(field string) (literal, error) {
+	tok := p.next()
+	switch {
+	case field == FieldPort:
+		if tok.kind != tokNumber {
+			return literal{}, fmt.Errorf("port needs a number, got %s at offset %d", tok, tok.pos)
+		}
+		n, err := strconv.ParseUint(tok.text, 10, 16)
+		if err != nil {
+			return literal{}, fmt.Errorf("invalid port %s at offset %d", tok.text, tok.pos)
+		}
+		return literal{text: tok.text, number: n}, nil
+	case tok.kind != tokString:
+		return literal{}, fmt.Errorf("%s needs a string, got %s at offset %d", field, tok, tok.pos)
+	case (field == FieldSource || field == FieldDestination) && strings.Contains(tok.text, "/"):
+		prefix, err := netip.ParsePrefix(tok.text)
+		if err != nil {
+			return literal{}, fmt.Errorf("invalid CIDR %q at offset %d", tok.text, tok.pos)
+		}
+		return literal{text: tok.text, prefix: prefix.Masked(), isCIDR: true}, nil
+	}
+	return literal{text: tok.text}, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokPunct
+)
+
+func (k tokenKind) String() string {
+	return [...]string{"end of expression", "identifier", "string", "number", "operator"}[k]
+}
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) is(punct string) bool { return t.kind == tokPunct && t.text == punct }
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return t.kind.String()
+	}
+	return strconv.Quote(t.text)
+}
+
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ","}
+
+func lex(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			text, err := strconv.Unquote(s[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at offset %d", i)
+			}
+			tokens = append(tokens, token{tokString, text, i})
+			i = end + 1
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(s) && unicode.IsDigit(rune(s[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, s[start:i], start})
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(s) && (unicode.IsLetter(rune(s[i])) || unicode.IsDigit(rune(s[i])) || s[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, s[start:i], start})
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(s[i:], op) {
+					tokens = append(tokens, token{tokPunct, op, i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q at offset %d", c, i)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(s)}), nil
+}
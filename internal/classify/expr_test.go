@@ -0,0 +1,90 @@
+package classify
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	dns := Fields{Source: "10.1.2.3", Destination: "192.0.2.53", Port: "53", Protocol: "UDP", Direction: "Incoming"}
+	ssh := Fields{Source: "::ffff:203.0.113.9", Destination: "192.0.2.22", Port: "22", Protocol: "TCP", Direction: "Outgoing"}
+	noPort := Fields{Source: "10.1.2.3", Protocol: "ICMP"}
+
+	tests := []struct {
+		expression string
+		fields     Fields
+		want       bool
+	}{
+		{`protocol == "udp"`, dns, true},
+		{`protocol == "udp"`, ssh, false},
+		{`protocol != "udp"`, ssh, true},
+		{`port == 53`, dns, true},
+		{`port < 53`, dns, false},
+		{`port <= 53`, dns, true},
+		{`port > 52`, dns, true},
+		{`port >= 54`, dns, false},
+		{`port == 53`, noPort, false},
+		{`port != 53`, noPort, true},
+		{`port in [22, 53]`, ssh, true},
+		{`port not in [22, 53]`, ssh, false},
+		{`port in []`, ssh, false},
+		{`source == "10.0.0.0/8"`, dns, true},
+		{`source == "203.0.113.0/24"`, ssh, true},
+		{`source != "10.0.0.0/8"`, ssh, true},
+		{`source == "10.1.2.3"`, dns, true},
+		{`destination in ["192.0.2.0/24", "198.51.100.1"]`, ssh, true},
+		{`direction == "incoming"`, dns, true},
+		{`protocol == "UDP" && port in [53, 123]`, dns, true},
+		{`protocol == "UDP" && port in [53, 123]`, ssh, false},
+		{`port == 22 || port == 53`, dns, true},
+		{`!(port == 53)`, dns, false},
+		{`!port == 53`, dns, false},
+		// && binds tighter than ||.
+		{`port == 22 || port == 53 && protocol == "tcp"`, dns, false},
+		{`(port == 22 || port == 53) && protocol == "udp"`, dns, true},
+		{`protocol == "a\"b"`, Fields{Protocol: `a"b`}, true},
+	}
+	for _, test := range tests {
+		expr, err := Compile(test.expression)
+		if err != nil {
+			t.Errorf("Compile(%s): %v", test.expression, err)
+			continue
+		}
+		if got := expr.Match(test.fields); got != test.want {
+			t.Errorf("%s on %+v = %v, want %v", test.expression, test.fields, got, test.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+	}{
+		{``, "expected a field"},
+		{`port`, "expected an operator"},
+		{`port ==`, "port needs a number"},
+		{`port == "22"`, "port needs a number"},
+		{`port == 70000`, "invalid port"},
+		{`host == "a"`, `unknown field "host"`},
+		{`protocol < "tcp"`, "protocol cannot be compared with <"},
+		{`protocol == tcp`, "protocol needs a string"},
+		{`source == "10.0.0.0/33"`, "invalid CIDR"},
+		{`protocol == "tcp`, "unterminated string"},
+		{`port == 22 port == 23`, "unexpected"},
+		{`(port == 22`, "expected ), got end of expression"},
+		{`port in [22`, "expected ], got end of expression"},
+		{`port not [22]`, "expected in"},
+		{`port == 22 # comment`, "unexpected '#'"},
+	}
+	for _, test := range tests {
+		_, err := Compile(test.expression)
+		if err == nil {
+			t.Errorf("Compile(%s) succeeded, want an error containing %q", test.expression, test.err)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Compile(%s) = %v, want an error containing %q", test.expression, err, test.err)
+		}
+	}
+}
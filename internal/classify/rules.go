@@ -0,0 +1,158 @@
+package classify
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanshal101/snapwall/database/psql"
+	"github.com/hanshal101/snapwall/internal/audit"
+	"github.com/hanshal101/snapwall/internal/policies"
+	"github.com/hanshal101/snapwall/models"
+)
+
+type RuleRequest struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+	Severity   string `json:"severity"`
+	// Enabled defaults to true.
+	Enabled *bool `json:"enabled"`
+}
+
+// validateRule checks a request to create a rule, or to update the rule
+// with the given ID.
+func validateRule(req RuleRequest, id uint) []policies.FieldError {
+	var errs []policies.FieldError
+	if strings.TrimSpace(req.Name) == "" {
+		errs = append(errs, policies.FieldError{Field: "name", Message: "is required"})
+	} else {
+		var count int64
+		if err := psql.DB.Model(&models.ClassificationRule{}).Where("name = ? AND id <> ?", req.Name, id).Count(&count).Error; err != nil || count > 0 {
+			errs = append(errs, policies.FieldError{Field: "name", Message: fmt.Sprintf("rule %q already exists", req.Name)})
+		}
+	}
+	if _, err := Compile(req.Expression); err != nil {
+		errs = append(errs, policies.FieldError{Field: "expression", Message: err.Error()})
+	}
+	if _, ok := models.ParseSeverity(req.Severity); !ok {
+		errs = append(errs, policies.FieldError{Field: "severity", Message: "must be one of LOW, MEDIUM, HIGH, CRITICAL"})
+	}
+	return errs
+}
+
+func (req RuleRequest) apply(rule *models.ClassificationRule) {
+	rule.Name = req.Name
+	rule.Expression = req.Expression
+	rule.Severity, _ = models.ParseSeverity(req.Severity)
+	rule.Enabled = req.Enabled == nil || *req.Enabled
+}
+
+func GetRules(c *gin.Context) {
+	var rules []models.ClassificationRule
+	if err := psql.DB.Order("name").Find(&rules).Error; err != nil {
+		log.Printf("Error in fetching classification rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching classification rules"})
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+func CreateRule(c *gin.Context) {
+	var req RuleRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Printf("Error in binding classification rule: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error in binding classification rule"})
+		return
+	}
+
+	if errs := validateRule(req, 0); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid classification rule", "errors": errs})
+		return
+	}
+
+	var rule models.ClassificationRule
+	req.apply(&rule)
+
+	tx := psql.DB.Begin()
+	if err := tx.Create(&rule).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error in creating classification rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in creating classification rule"})
+		return
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "create", audit.ObjectClassificationRule, rule.ID, nil, rule); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing classification rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing classification rule"})
+		return
+	}
+	tx.Commit()
+	c.JSON(http.StatusOK, rule)
+}
+
+func UpdateRule(c *gin.Context) {
+	var req RuleRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Printf("Error in binding classification rule: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error in binding classification rule"})
+		return
+	}
+
+	var rule models.ClassificationRule
+	if err := psql.DB.First(&rule, c.Param("ruleID")).Error; err != nil {
+		log.Printf("Error fetching classification rule: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Classification rule not found"})
+		return
+	}
+
+	if errs := validateRule(req, rule.ID); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid classification rule", "errors": errs})
+		return
+	}
+
+	previous := rule
+	req.apply(&rule)
+
+	tx := psql.DB.Begin()
+	if err := tx.Save(&rule).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error saving classification rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving classification rule"})
+		return
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "update", audit.ObjectClassificationRule, rule.ID, previous, rule); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing classification rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing classification rule"})
+		return
+	}
+	tx.Commit()
+	c.JSON(http.StatusOK, rule)
+}
+
+func DeleteRule(c *gin.Context) {
+	var rule models.ClassificationRule
+	if err := psql.DB.First(&rule, c.Param("ruleID")).Error; err != nil {
+		log.Printf("Error fetching classification rule: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Classification rule not found"})
+		return
+	}
+
+	tx := psql.DB.Begin()
+	if err := tx.Delete(&rule).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error in deleting classification rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in deleting classification rule"})
+		return
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "delete", audit.ObjectClassificationRule, rule.ID, rule, nil); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing classification rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing classification rule"})
+		return
+	}
+	tx.Commit()
+	c.JSON(http.StatusOK, gin.H{"success": "Classification Rule Deleted Successfully"})
+}
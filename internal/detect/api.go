@@ -0,0 +1,258 @@
+package detect
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanshal101/snapwall/database/psql"
+	"github.com/hanshal101/snapwall/internal/audit"
+	"github.com/hanshal101/snapwall/internal/policies"
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
+)
+
+// RateKinds are the detections whose thresholds can be configured.
+var RateKinds = []string{KindBruteForce, KindConnectionFlood}
+
+// LoadThresholds reads the configured thresholds, by kind and port.
+// Thresholds set for an application apply to its port.
+func LoadThresholds(db *gorm.DB) (map[string]map[string]Threshold, error) {
+	var thresholds []models.DetectionThreshold
+	if err := db.Find(&thresholds).Error; err != nil {
+		return nil, err
+	}
+
+	byKind := make(map[string]map[string]Threshold)
+	for _, t := range thresholds {
+		port := t.Port
+		if t.ApplicationID != nil {
+			var application models.Application
+			if err := db.First(&application, *t.ApplicationID).Error; err != nil {
+				log.Printf("Skipping threshold %d: application %d: %v", t.ID, *t.ApplicationID, err)
+				continue
+			}
+			port = application.Port
+		}
+		if byKind[t.Kind] == nil {
+			byKind[t.Kind] = make(map[string]Threshold)
+		}
+		byKind[t.Kind][port] = Threshold{Count: t.Count, Window: time.Duration(t.Window) * time.Second}
+	}
+	return byKind, nil
+}
+
+// GetDetections lists detections, newest first. It accepts the filters kind,
+// source, since/until as RFC 3339 times, and limit.
+func GetDetections(c *gin.Context) {
+	query := psql.DB.Model(&models.Detection{})
+
+	if kind := c.Query("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	if source := c.Query("source"); source != "" {
+		query = query.Where("source = ?", source)
+	}
+	for param, cond := range map[string]string{"since": "created_at >= ?", "until": "created_at <= ?"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s: expected RFC 3339 time", param)})
+			return
+		}
+		query = query.Where(cond, t)
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		query = query.Limit(limit)
+	}
+
+	var detections []models.Detection
+	if err := query.Order("created_at DESC").Find(&detections).Error; err != nil {
+		log.Printf("Error in fetching detections: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching detections"})
+		return
+	}
+	c.JSON(http.StatusOK, detections)
+}
+
+func GetDetection(c *gin.Context) {
+	var detection models.Detection
+	if err := psql.DB.First(&detection, c.Param("detectionID")).Error; err != nil {
+		log.Printf("Error fetching detection: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Detection not found"})
+		return
+	}
+	c.JSON(http.StatusOK, detection)
+}
+
+type ThresholdRequest struct {
+	Kind          string `json:"kind"`
+	Port          string `json:"port"`
+	ApplicationID *uint  `json:"application_id"`
+	Count         int    `json:"count"`
+	Window        int    `json:"window"`
+}
+
+func validateThreshold(req ThresholdRequest) []policies.FieldError {
+	var errs []policies.FieldError
+	validKind := false
+	for _, kind := range RateKinds {
+		if req.Kind == kind {
+			validKind = true
+		}
+	}
+	if !validKind {
+		errs = append(errs, policies.FieldError{Field: "kind", Message: "must be one of brute_force, connection_flood"})
+	}
+
+	switch {
+	case req.Port == "" && req.ApplicationID == nil:
+		errs = append(errs, policies.FieldError{Field: "port", Message: "a port or an application is required"})
+	case req.Port != "" && req.ApplicationID != nil:
+		errs = append(errs, policies.FieldError{Field: "port", Message: "cannot be combined with application_id"})
+	case req.Port != "":
+		if low, high, err := policies.ParsePort(req.Port); err != nil || low != high {
+			errs = append(errs, policies.FieldError{Field: "port", Message: "must be a single port"})
+		}
+	default:
+		var count int64
+		if err := psql.DB.Model(&models.Application{}).Where("id = ?", *req.ApplicationID).Count(&count).Error; err != nil || count == 0 {
+			errs = append(errs, policies.FieldError{Field: "application_id", Message: fmt.Sprintf("%d does not exist", *req.ApplicationID)})
+		}
+	}
+
+	if req.Count < 1 {
+		errs = append(errs, policies.FieldError{Field: "count", Message: "must be at least 1"})
+	}
+	if req.Window < 1 {
+		errs = append(errs, policies.FieldError{Field: "window", Message: "must be at least 1 second"})
+	}
+	return errs
+}
+
+func (req ThresholdRequest) apply(threshold *models.DetectionThreshold) {
+	threshold.Kind = req.Kind
+	threshold.Port = req.Port
+	threshold.ApplicationID = req.ApplicationID
+	threshold.Count = req.Count
+	threshold.Window = req.Window
+}
+
+func GetThresholds(c *gin.Context) {
+	var thresholds []models.DetectionThreshold
+	if err := psql.DB.Order("kind, port").Find(&thresholds).Error; err != nil {
+		log.Printf("Error in fetching thresholds: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching thresholds"})
+		return
+	}
+	c.JSON(http.StatusOK, thresholds)
+}
+
+func CreateThreshold(c *gin.Context) {
+	var req ThresholdRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Printf("Error in binding threshold: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error in binding threshold"})
+		return
+	}
+
+	if errs := validateThreshold(req); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid threshold", "errors": errs})
+		return
+	}
+
+	var threshold models.DetectionThreshold
+	req.apply(&threshold)
+
+	tx := psql.DB.Begin()
+	if err := tx.Create(&threshold).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error in creating threshold: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in creating threshold"})
+		return
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "create", audit.ObjectDetectionThreshold, threshold.ID, nil, threshold); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing threshold: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing threshold"})
+		return
+	}
+	tx.Commit()
+	c.JSON(http.StatusOK, threshold)
+}
+
+func UpdateThreshold(c *gin.Context) {
+	var req ThresholdRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Printf("Error in binding threshold: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error in binding threshold"})
+		return
+	}
+
+	if errs := validateThreshold(req); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid threshold", "errors": errs})
+		return
+	}
+
+	var threshold models.DetectionThreshold
+	if err := psql.DB.First(&threshold, c.Param("thresholdID")).Error; err != nil {
+		log.Printf("Error fetching threshold: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Threshold not found"})
+		return
+	}
+
+	previous := threshold
+	req.apply(&threshold)
+
+	tx := psql.DB.Begin()
+	if err := tx.Save(&threshold).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error saving threshold: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving threshold"})
+		return
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "update", audit.ObjectDetectionThreshold, threshold.ID, previous, threshold); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing threshold: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing threshold"})
+		return
+	}
+	tx.Commit()
+	c.JSON(http.StatusOK, threshold)
+}
+
+func DeleteThreshold(c *gin.Context) {
+	var threshold models.DetectionThreshold
+	if err := psql.DB.First(&threshold, c.Param("thresholdID")).Error; err != nil {
+		log.Printf("Error fetching threshold: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Threshold not found"})
+		return
+	}
+
+	tx := psql.DB.Begin()
+	if err := tx.Delete(&threshold).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error in deleting threshold: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in deleting threshold"})
+		return
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "delete", audit.ObjectDetectionThreshold, threshold.ID, threshold, nil); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing threshold: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing threshold"})
+		return
+	}
+	tx.Commit()
+	c.JSON(http.StatusOK, gin.H{"success": "Threshold Deleted Successfully"})
+}
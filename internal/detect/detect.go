@@ -0,0 +1,68 @@
+package detect
+
+import (
+	"strings"
+	"time"
+
+	"github.com/hanshal101/snapwall/models"
+)
+
+// Kinds lists the kinds of detection the detectors report.
+var Kinds = []string{KindVerticalScan, KindHorizontalScan, KindBruteForce, KindConnectionFlood}
+
+// Flow is a flow as seen by the detectors.
+type Flow struct {
+	Time        time.Time
+	Source      string
+	Destination string
+	Port        string
+	Protocol    string
+	Direction   string
+}
+
+// Verdict is what the detectors make of one flow. Severity is set when the
+// flow belongs to an ongoing detection; Detections lists those it started.
+type Verdict struct {
+	Severity   models.SEVERITY
+	Detections []models.Detection
+}
+
+func (v *Verdict) merge(other Verdict) {
+	if other.Severity.Rank() > v.Severity.Rank() {
+		v.Severity = other.Severity
+	}
+	v.Detections = append(v.Detections, other.Detections...)
+}
+
+// Detector keeps state across flows and reports patterns spanning several
+// of them. Implementations must be safe for concurrent use, since every
+// client stream feeds the same detectors.
+type Detector interface {
+	Observe(Flow) Verdict
+}
+
+// Engine feeds every flow to a set of detectors.
+type Engine struct {
+	detectors []Detector
+}
+
+func NewEngine(detectors ...Detector) *Engine {
+	return &Engine{detectors: detectors}
+}
+
+func (e *Engine) Observe(f Flow) Verdict {
+	if f.Time.IsZero() {
+		f.Time = time.Now()
+	}
+	var verdict Verdict
+	for _, d := range e.detectors {
+		verdict.merge(d.Observe(f))
+	}
+	return verdict
+}
+
+// incoming reports whether a flow was received by a monitored host. Flows
+// without a direction are treated as incoming.
+func incoming(f Flow) bool {
+	return f.Direction == "" || strings.EqualFold(f.Direction, "Incoming")
+}
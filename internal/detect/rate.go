@@ -0,0 +1,171 @@
+package detect
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hanshal101/snapwall/models"
+)
+
+const (
+	KindBruteForce      = "brute_force"
+	KindConnectionFlood = "connection_flood"
+)
+
+// Threshold fires a rate detector when a source makes more than Count
+// incoming connections within Window.
+type Threshold struct {
+	Count  int           `json:"count"`
+	Window time.Duration `json:"window"`
+}
+
+// AuthPorts are the authentication services watched for brute force by
+// default: SSH, RDP, and the MySQL, PostgreSQL, MSSQL, Oracle, MongoDB and
+// Redis databases.
+var AuthPorts = []string{"22", "3389", "3306", "5432", "1433", "1521", "27017", "6379"}
+
+// RateDetector counts the incoming connections of each source to each host
+// over a sliding window. Ports with a threshold of their own are counted
+// separately; the remaining ports share the default threshold, if any.
+type RateDetector struct {
+	kind string
+
+	mu        sync.Mutex
+	fallback  *Threshold
+	ports     map[string]Threshold
+	counters  map[rateKey][]attempt
+	active    map[rateKey]time.Time
+	lastSweep time.Time
+	// now is when a flow arrives; the windows follow it, since clients can
+	// report flows late and out of order.
+	now func() time.Time
+}
+
+type rateKey struct {
+	source, destination, port string
+}
+
+// attempt is a connection as received, and as the client timed it.
+type attempt struct {
+	received, at time.Time
+}
+
+// NewRateDetector reports detections of kind. fallback applies to ports
+// without a threshold of their own and may be nil to watch listed ports only.
+func NewRateDetector(kind string, fallback *Threshold, ports map[string]Threshold) *RateDetector {
+	return &RateDetector{
+		kind:     kind,
+		fallback: fallback,
+		ports:    ports,
+		counters: make(map[rateKey][]attempt),
+		active:   make(map[rateKey]time.Time),
+		now:      time.Now,
+	}
+}
+
+// SetThresholds replaces the per port thresholds. Counts already taken are
+// kept.
+func (d *RateDetector) SetThresholds(ports map[string]Threshold) {
+	d.mu.Lock()
+	d.ports = ports
+	d.mu.Unlock()
+}
+
+func (d *RateDetector) Observe(f Flow) Verdict {
+	var verdict Verdict
+	if !incoming(f) {
+		return verdict
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	at := f.Time
+	if at.IsZero() {
+		at = now
+	}
+	key := rateKey{f.Source, f.Destination, f.Port}
+	threshold, ok := d.ports[f.Port]
+	if !ok {
+		if d.fallback == nil {
+			return verdict
+		}
+		threshold = *d.fallback
+		key.port = ""
+	}
+	d.sweep(now)
+
+	times := append(d.counters[key], attempt{received: now, at: at})
+	cutoff := now.Add(-threshold.Window)
+	n := 0
+	for n < len(times) && times[n].received.Before(cutoff) {
+		n++
+	}
+	// Only whether the count exceeds the threshold matters, so older
+	// attempts beyond it need not be kept.
+	if keep := threshold.Count + 1; len(times)-n > keep {
+		n = len(times) - keep
+	}
+	times = times[n:]
+	d.counters[key] = times
+
+	if until, ok := d.active[key]; ok && now.Before(until) {
+		verdict.Severity = models.SEVERITY_HIGH
+		return verdict
+	}
+	if len(times) <= threshold.Count {
+		return verdict
+	}
+
+	d.active[key] = now.Add(threshold.Window)
+	first, last := times[0].at, times[0].at
+	for _, t := range times[1:] {
+		if t.at.Before(first) {
+			first = t.at
+		}
+		if t.at.After(last) {
+			last = t.at
+		}
+	}
+	detection := models.Detection{
+		Kind:         d.kind,
+		Source:       f.Source,
+		Destinations: []string{f.Destination},
+		Count:        len(times),
+		Severity:     models.SEVERITY_HIGH,
+		FirstSeen:    first,
+		LastSeen:     last,
+	}
+	if key.port != "" {
+		detection.Ports = []string{key.port}
+	}
+	verdict.Severity = models.SEVERITY_HIGH
+	verdict.Detections = append(verdict.Detections, detection)
+	return verdict
+}
+
+// sweep drops counters and detections that have gone quiet, at most once a
+// minute.
+func (d *RateDetector) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < time.Minute {
+		return
+	}
+	d.lastSweep = now
+	for key, until := range d.active {
+		if !now.Before(until) {
+			delete(d.active, key)
+		}
+	}
+	for key, times := range d.counters {
+		window := time.Minute
+		if threshold, ok := d.ports[key.port]; ok {
+			window = threshold.Window
+		} else if d.fallback != nil {
+			window = d.fallback.Window
+		}
+		if len(times) == 0 || now.Sub(times[len(times)-1].received) > window {
+			delete(d.counters, key)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package detect
+
+import (
+	"testing"
+	"time"
+)
+
+// clock is a receive time tests move by hand.
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time { return c.t }
+
+func TestRateDetectorWindow(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name string
+		// gaps between arrivals, after the first attempt
+		gaps []time.Duration
+		want bool
+	}{
+		{"below threshold", []time.Duration{time.Second}, false},
+		{"over threshold", []time.Duration{time.Second, time.Second}, true},
+		{"first attempt on the window edge", []time.Duration{30 * time.Second, 30 * time.Second}, true},
+		{"first attempt left the window", []time.Duration{30 * time.Second, 30*time.Second + time.Nanosecond}, false},
+	}
+	for _, test := range tests {
+		c := &clock{t: start}
+		d := NewRateDetector(KindBruteForce, nil, map[string]Threshold{"22": {Count: 2, Window: time.Minute}})
+		d.now = c.now
+
+		// The client's clock is an hour behind and its flows all carry the
+		// same time, which must not matter.
+		flow := Flow{Time: start.Add(-time.Hour), Source: "192.0.2.1", Destination: "10.0.0.1", Port: "22"}
+		verdict := d.Observe(flow)
+		for _, gap := range test.gaps {
+			c.t = c.t.Add(gap)
+			verdict = d.Observe(flow)
+		}
+		if got := len(verdict.Detections) > 0; got != test.want {
+			t.Errorf("%s: detected = %v, want %v", test.name, got, test.want)
+			continue
+		}
+		if test.want {
+			detection := verdict.Detections[0]
+			if !detection.FirstSeen.Equal(flow.Time) || !detection.LastSeen.Equal(flow.Time) {
+				t.Errorf("%s: detection spans %v to %v, want the flow time %v", test.name, detection.FirstSeen, detection.LastSeen, flow.Time)
+			}
+		}
+	}
+}
+
+func TestRateDetectorFallback(t *testing.T) {
+	d := NewRateDetector(KindConnectionFlood, nil, map[string]Threshold{"22": {Count: 1, Window: time.Minute}})
+	for i := 0; i < 5; i++ {
+		if verdict := d.Observe(Flow{Source: "192.0.2.1", Destination: "10.0.0.1", Port: "80"}); verdict.Severity != "" {
+			t.Fatalf("flow to an unwatched port got %+v", verdict)
+		}
+	}
+	d = NewRateDetector(KindConnectionFlood, &Threshold{Count: 1, Window: time.Minute}, nil)
+	d.Observe(Flow{Source: "192.0.2.1", Destination: "10.0.0.1", Port: "80"})
+	verdict := d.Observe(Flow{Source: "192.0.2.1", Destination: "10.0.0.1", Port: "443"})
+	if len(verdict.Detections) != 1 || verdict.Detections[0].Ports != nil {
+		t.Errorf("verdict = %+v, want one detection across ports", verdict)
+	}
+}
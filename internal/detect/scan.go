@@ -0,0 +1,231 @@
+package detect
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hanshal101/snapwall/models"
+)
+
+const (
+	KindVerticalScan   = "vertical_scan"
+	KindHorizontalScan = "horizontal_scan"
+)
+
+// ScanConfig sets when a source counts as scanning: touching more than
+// MaxPorts distinct ports of one host (vertical) or one port on more than
+// MaxHosts distinct hosts (horizontal) within Window.
+type ScanConfig struct {
+	Window   time.Duration
+	MaxPorts int
+	MaxHosts int
+}
+
+// ScanDetector finds port scans with a sliding window per source. Once a
+// scan is reported, further flows of it are marked for one window without
+// being reported again.
+type ScanDetector struct {
+	config ScanConfig
+
+	mu        sync.Mutex
+	sources   map[string]*scanSource
+	lastSweep time.Time
+	// now is when a flow arrives; the windows follow it, since clients can
+	// report flows late and out of order.
+	now func() time.Time
+}
+
+type scanSource struct {
+	// ports holds the ports touched per destination, hosts the destinations
+	// touched per port, over the window. Each keeps at most one target more
+	// than a scan takes, so a flood does not grow them.
+	ports map[string]map[string]*touch
+	hosts map[string]map[string]*touch
+	// active holds the ongoing scans, by kind and target, until they expire.
+	active map[string]time.Time
+}
+
+// touch is when a target was first and last touched in the window, as
+// received, and the range of the times the client gave its flows. The
+// former windows the detector, the latter is what the logs are stored with.
+type touch struct {
+	first, last time.Time
+	from, to    time.Time
+}
+
+func NewScanDetector(config ScanConfig) *ScanDetector {
+	return &ScanDetector{config: config, sources: make(map[string]*scanSource), now: time.Now}
+}
+
+func (d *ScanDetector) Observe(f Flow) Verdict {
+	var verdict Verdict
+	if !incoming(f) {
+		return verdict
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	d.sweep(now)
+
+	s, ok := d.sources[f.Source]
+	if !ok {
+		s = &scanSource{
+			ports:  make(map[string]map[string]*touch),
+			hosts:  make(map[string]map[string]*touch),
+			active: make(map[string]time.Time),
+		}
+		d.sources[f.Source] = s
+	}
+	cutoff := now.Add(-d.config.Window)
+	expireTargets(s.ports, f.Destination, cutoff)
+	expireTargets(s.hosts, f.Port, cutoff)
+	flowTime := f.Time
+	if flowTime.IsZero() {
+		flowTime = now
+	}
+	touchTarget(s.ports, f.Destination, f.Port, d.config.MaxPorts+1, now, flowTime)
+	touchTarget(s.hosts, f.Port, f.Destination, d.config.MaxHosts+1, now, flowTime)
+
+	check := func(kind, target string, touched map[string]*touch, max int, detection func() models.Detection) {
+		key := kind + "|" + target
+		if until, ok := s.active[key]; ok && now.Before(until) {
+			verdict.Severity = models.SEVERITY_HIGH
+			return
+		}
+		if len(touched) <= max {
+			return
+		}
+		s.active[key] = now.Add(d.config.Window)
+		verdict.Severity = models.SEVERITY_HIGH
+		verdict.Detections = append(verdict.Detections, detection())
+	}
+
+	check(KindVerticalScan, f.Destination, s.ports[f.Destination], d.config.MaxPorts, func() models.Detection {
+		ports := sortedPorts(s.ports[f.Destination])
+		from, to := flowRange(s.ports[f.Destination])
+		return models.Detection{
+			Kind:         KindVerticalScan,
+			Source:       f.Source,
+			Destinations: []string{f.Destination},
+			Ports:        ports,
+			Count:        len(ports),
+			Severity:     models.SEVERITY_HIGH,
+			FirstSeen:    from,
+			LastSeen:     to,
+		}
+	})
+	check(KindHorizontalScan, f.Port, s.hosts[f.Port], d.config.MaxHosts, func() models.Detection {
+		hosts := sortedKeys(s.hosts[f.Port])
+		from, to := flowRange(s.hosts[f.Port])
+		return models.Detection{
+			Kind:         KindHorizontalScan,
+			Source:       f.Source,
+			Destinations: hosts,
+			Ports:        []string{f.Port},
+			Count:        len(hosts),
+			Severity:     models.SEVERITY_HIGH,
+			FirstSeen:    from,
+			LastSeen:     to,
+		}
+	})
+
+	return verdict
+}
+
+// sweep drops the state of sources that have been quiet for a window, at
+// most once per window.
+func (d *ScanDetector) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < d.config.Window {
+		return
+	}
+	d.lastSweep = now
+	cutoff := now.Add(-d.config.Window)
+	for source, s := range d.sources {
+		for key := range s.ports {
+			expireTargets(s.ports, key, cutoff)
+		}
+		for key := range s.hosts {
+			expireTargets(s.hosts, key, cutoff)
+		}
+		for key, until := range s.active {
+			if !now.Before(until) {
+				delete(s.active, key)
+			}
+		}
+		if len(s.ports) == 0 && len(s.hosts) == 0 && len(s.active) == 0 {
+			delete(d.sources, source)
+		}
+	}
+}
+
+// touchTarget records that target was touched under key at now by a flow
+// the client timed at flowTime, unless key already holds limit other
+// targets.
+func touchTarget(targets map[string]map[string]*touch, key, target string, limit int, now, flowTime time.Time) {
+	if targets[key] == nil {
+		targets[key] = make(map[string]*touch)
+	}
+	if t, ok := targets[key][target]; ok {
+		t.last = now
+		if flowTime.Before(t.from) {
+			t.from = flowTime
+		}
+		if flowTime.After(t.to) {
+			t.to = flowTime
+		}
+		return
+	}
+	if len(targets[key]) < limit {
+		targets[key][target] = &touch{first: now, last: now, from: flowTime, to: flowTime}
+	}
+}
+
+// expireTargets forgets the targets under key last touched before cutoff.
+func expireTargets(targets map[string]map[string]*touch, key string, cutoff time.Time) {
+	for target, t := range targets[key] {
+		if t.last.Before(cutoff) {
+			delete(targets[key], target)
+		}
+	}
+	if len(targets[key]) == 0 {
+		delete(targets, key)
+	}
+}
+
+// flowRange returns the earliest and latest client times of the flows that
+// touched targets, which is what their logs are stored with.
+func flowRange(targets map[string]*touch) (from, to time.Time) {
+	for _, t := range targets {
+		if from.IsZero() || t.from.Before(from) {
+			from = t.from
+		}
+		if t.to.After(to) {
+			to = t.to
+		}
+	}
+	return from, to
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedPorts sorts port numbers numerically.
+func sortedPorts[T any](m map[string]T) []string {
+	ports := sortedKeys(m)
+	sort.SliceStable(ports, func(i, j int) bool {
+		a, _ := strconv.Atoi(ports[i])
+		b, _ := strconv.Atoi(ports[j])
+		return a < b
+	})
+	return ports
+}
@@ -0,0 +1,105 @@
+package detect
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestScanDetectorFlowTimes(t *testing.T) {
+	d := NewScanDetector(ScanConfig{Window: time.Minute, MaxPorts: 2, MaxHosts: 100})
+
+	// Clients report flows late and out of order.
+	base := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	offsets := []time.Duration{2 * time.Second, 0, 3 * time.Second}
+	var verdict Verdict
+	for i, offset := range offsets {
+		verdict = d.Observe(Flow{
+			Time:        base.Add(offset),
+			Source:      "192.0.2.1",
+			Destination: "10.0.0.1",
+			Port:        strconv.Itoa(22 + i),
+		})
+	}
+	if len(verdict.Detections) != 1 {
+		t.Fatalf("detections = %+v, want one", verdict.Detections)
+	}
+	detection := verdict.Detections[0]
+	if !detection.FirstSeen.Equal(base) || !detection.LastSeen.Equal(base.Add(3*time.Second)) {
+		t.Errorf("detection spans %v to %v, want the flow times %v to %v", detection.FirstSeen, detection.LastSeen, base, base.Add(3*time.Second))
+	}
+}
+
+func TestScanDetectorWindow(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name string
+		// gap between arrivals of the three ports scanned
+		gap  time.Duration
+		kind string
+		want bool
+	}{
+		{"vertical within the window", 10 * time.Second, KindVerticalScan, true},
+		{"vertical with the first port on the window edge", 30 * time.Second, KindVerticalScan, true},
+		{"vertical with the first port out of the window", 30*time.Second + time.Nanosecond, KindVerticalScan, false},
+		{"horizontal within the window", 10 * time.Second, KindHorizontalScan, true},
+		{"horizontal with the first host out of the window", 30*time.Second + time.Nanosecond, KindHorizontalScan, false},
+	}
+	for _, test := range tests {
+		c := &clock{t: start}
+		d := NewScanDetector(ScanConfig{Window: time.Minute, MaxPorts: 2, MaxHosts: 2})
+		d.now = c.now
+
+		var verdict Verdict
+		for i := 0; i < 3; i++ {
+			flow := Flow{Source: "192.0.2.1", Destination: "10.0.0.1", Port: "22"}
+			if test.kind == KindVerticalScan {
+				flow.Port = strconv.Itoa(22 + i)
+			} else {
+				flow.Destination = "10.0.0." + strconv.Itoa(1+i)
+			}
+			if i > 0 {
+				c.t = c.t.Add(test.gap)
+			}
+			verdict = d.Observe(flow)
+		}
+		got := len(verdict.Detections) == 1 && verdict.Detections[0].Kind == test.kind
+		if got != test.want || (!test.want && len(verdict.Detections) > 0) {
+			t.Errorf("%s: detections = %+v, want one %s: %v", test.name, verdict.Detections, test.kind, test.want)
+		}
+	}
+}
+
+func TestScanDetectorReportsOnce(t *testing.T) {
+	c := &clock{t: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	d := NewScanDetector(ScanConfig{Window: time.Minute, MaxPorts: 2, MaxHosts: 100})
+	d.now = c.now
+
+	observe := func(port int) Verdict {
+		return d.Observe(Flow{Source: "192.0.2.1", Destination: "10.0.0.1", Port: strconv.Itoa(port)})
+	}
+	observe(1)
+	observe(2)
+	if verdict := observe(3); len(verdict.Detections) != 1 {
+		t.Fatalf("detections = %+v, want one", verdict.Detections)
+	}
+	// The ongoing scan is marked without being reported again.
+	if verdict := observe(4); len(verdict.Detections) != 0 || verdict.Severity == "" {
+		t.Errorf("verdict during the scan = %+v, want marked only", verdict)
+	}
+	// Once the window has passed the scan is forgotten.
+	c.t = c.t.Add(time.Minute + time.Nanosecond)
+	if verdict := observe(5); verdict.Severity != "" {
+		t.Errorf("verdict after the window = %+v, want none", verdict)
+	}
+}
+
+func TestScanDetectorIgnoresOutgoing(t *testing.T) {
+	d := NewScanDetector(ScanConfig{Window: time.Minute, MaxPorts: 1, MaxHosts: 1})
+	for i := 0; i < 5; i++ {
+		verdict := d.Observe(Flow{Source: "192.0.2.1", Destination: "10.0.0.1", Port: strconv.Itoa(22 + i), Direction: "Outgoing"})
+		if verdict.Severity != "" {
+			t.Fatalf("outgoing flow got %+v", verdict)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// MinTTL and MaxTTL bound how long an answer is used, so records with a
+	// zero TTL do not turn into a query loop and long-lived ones are still
+	// checked now and then.
+	MinTTL = 30 * time.Second
+	MaxTTL = time.Hour
+
+	// timeout bounds each query to a server.
+	timeout = 5 * time.Second
+	// maxCNAMEs bounds the alias chain followed within an answer.
+	maxCNAMEs = 8
+	// udpSize is the largest UDP answer accepted, as advertised with EDNS.
+	udpSize = 1232
+)
+
+// ErrServer is returned when no server gave a usable answer. Callers keep
+// what they resolved before; a name that does not exist is not an error.
+var ErrServer = errors.New("dns: no server answered")
+
+// Answer is the resolved state of a name: its addresses, possibly none, and
+// how long they may be used.
+type Answer struct {
+	Addresses []netip.Addr
+	TTL       time.Duration
+}
+
+// Resolver queries recursive DNS servers for A and AAAA records. Unlike the
+// resolver of package net it reports the TTL of the answer.
+type Resolver struct {
+	Servers []string
+}
+
+// NewResolver uses servers, given as host or host:port, or the nameservers
+// of /etc/resolv.conf if there are none.
+func NewResolver(servers []string) *Resolver {
+	if len(servers) == 0 {
+		servers = systemServers("/etc/resolv.conf")
+	}
+	r := &Resolver{}
+	for _, server := range servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		r.Servers = append(r.Servers, server)
+	}
+	return r
+}
+
+func systemServers(path string) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		return []string{"127.0.0.1"}
+	}
+	defer file.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	if len(servers) == 0 {
+		return []string{"127.0.0.1"}
+	}
+	return servers
+}
+
+// Lookup resolves the A and AAAA records of a fully qualified name. The TTL
+// is the lowest of the records used, within MinTTL and MaxTTL.
+func (r *Resolver) Lookup(ctx context.Context, host string) (Answer, error) {
+	name, err := dnsmessage.NewName(strings.ToLower(strings.TrimSuffix(host, ".")) + ".")
+	if err != nil {
+		return Answer{}, fmt.Errorf("dns: invalid name %q: %w", host, err)
+	}
+
+	answer := Answer{TTL: MaxTTL}
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		addrs, ttl, err := r.exchange(ctx, name, qtype)
+		if err != nil {
+			return Answer{}, err
+		}
+		answer.Addresses = append(answer.Addresses, addrs...)
+		if ttl < answer.TTL {
+			answer.TTL = ttl
+		}
+	}
+	if answer.TTL < MinTTL {
+		answer.TTL = MinTTL
+	}
+	sort.Slice(answer.Addresses, func(i, j int) bool { return answer.Addresses[i].Less(answer.Addresses[j]) })
+	return answer, nil
+}
+
+// exchange asks the servers in turn until one answers.
+func (r *Resolver) exchange(ctx context.Context, name dnsmessage.Name, qtype dnsmessage.Type) ([]netip.Addr, time.Duration, error) {
+	var lastErr error
+	for _, server := range r.Servers {
+		msg, err := query(ctx, server, name, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		switch msg.RCode {
+		case dnsmessage.RCodeSuccess:
+			addrs, ttl := records(msg, name, qtype)
+			return addrs, ttl, nil
+		case dnsmessage.RCodeNameError:
+			return nil, negativeTTL(msg), nil
+		default:
+			lastErr = fmt.Errorf("%s answered %s", server, msg.RCode)
+		}
+	}
+	return nil, 0, fmt.Errorf("%w: %s: %v", ErrServer, name, lastErr)
+}
+
+// query sends one question over UDP, and again over TCP if the answer was
+// truncated.
+func query(ctx context.Context, server string, name dnsmessage.Name, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	// Unpredictable IDs make forged answers harder to slip in.
+	var random [2]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return nil, err
+	}
+	id := binary.BigEndian.Uint16(random[:])
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := builder.Question(dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	if err := builder.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(udpSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	if err := builder.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return nil, err
+	}
+	packed, err := builder.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := roundTrip(ctx, "udp", server, packed, id)
+	if err == nil && msg.Truncated {
+		msg, err = roundTrip(ctx, "tcp", server, packed, id)
+	}
+	return msg, err
+}
+
+func roundTrip(ctx context.Context, network, server string, packed []byte, id uint16) (*dnsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var buf []byte
+	if network == "tcp" {
+		framed := binary.BigEndian.AppendUint16(nil, uint16(len(packed)))
+		if _, err := conn.Write(append(framed, packed...)); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(packed); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, udpSize)
+		// Skip stray datagrams that are not the answer to this query.
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return nil, err
+			}
+			if n >= 2 && binary.BigEndian.Uint16(buf) == id {
+				buf = buf[:n]
+				break
+			}
+		}
+	}
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(buf); err != nil {
+		return nil, fmt.Errorf("dns: malformed answer from %s: %w", server, err)
+	}
+	if msg.ID != id || !msg.Response {
+		return nil, fmt.Errorf("dns: unexpected answer from %s", server)
+	}
+	return &msg, nil
+}
+
+// records collects the addresses of name from an answer, following the
+// CNAME chain the server included, and their lowest TTL.
+func records(msg *dnsmessage.Message, name dnsmessage.Name, qtype dnsmessage.Type) ([]netip.Addr, time.Duration) {
+	owners := map[string]bool{strings.ToLower(name.String()): true}
+	for i := 0; i < maxCNAMEs; i++ {
+		added := false
+		for _, rr := range msg.Answers {
+			cname, ok := rr.Body.(*dnsmessage.CNAMEResource)
+			if !ok || !owners[strings.ToLower(rr.Header.Name.String())] {
+				continue
+			}
+			if target := strings.ToLower(cname.CNAME.String()); !owners[target] {
+				owners[target] = true
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+
+	var addrs []netip.Addr
+	ttl := MaxTTL
+	for _, rr := range msg.Answers {
+		if !owners[strings.ToLower(rr.Header.Name.String())] {
+			continue
+		}
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			if qtype == dnsmessage.TypeA {
+				addrs = append(addrs, netip.AddrFrom4(body.A))
+			}
+		case *dnsmessage.AAAAResource:
+			if qtype == dnsmessage.TypeAAAA {
+				addrs = append(addrs, netip.AddrFrom16(body.AAAA))
+			}
+		case *dnsmessage.CNAMEResource:
+		default:
+			continue
+		}
+		if d := time.Duration(rr.Header.TTL) * time.Second; d < ttl {
+			ttl = d
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, negativeTTL(msg)
+	}
+	return addrs, ttl
+}
+
+// negativeTTL is how long the absence of records may be cached, from the SOA
+// record of the answer (RFC 2308).
+func negativeTTL(msg *dnsmessage.Message) time.Duration {
+	for _, rr := range msg.Authorities {
+		if soa, ok := rr.Body.(*dnsmessage.SOAResource); ok {
+			ttl := rr.Header.TTL
+			if soa.MinTTL < ttl {
+				ttl = soa.MinTTL
+			}
+			return time.Duration(ttl) * time.Second
+		}
+	}
+	return MinTTL
+}
@@ -0,0 +1,264 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// server answers queries over UDP and TCP on one local port with handle.
+// Over UDP, the answer is marked truncated if udpTruncate is set.
+type server struct {
+	handle      func(q dnsmessage.Question) dnsmessage.Message
+	udpTruncate bool
+
+	mu      sync.Mutex
+	queries []dnsmessage.Message
+}
+
+func (s *server) start(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc, err := net.ListenPacket("udp", ln.Addr().String())
+	if err != nil {
+		ln.Close()
+		t.Skipf("no UDP port next to the TCP one: %v", err)
+	}
+	t.Cleanup(func() {
+		ln.Close()
+		pc.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			answer, ok := s.answer(t, buf[:n], s.udpTruncate)
+			if ok {
+				pc.WriteTo(answer, addr)
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var length [2]byte
+			if _, err := io.ReadFull(conn, length[:]); err == nil {
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, query); err == nil {
+					if answer, ok := s.answer(t, query, false); ok {
+						conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(answer))), answer...))
+					}
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func (s *server) answer(t *testing.T, packed []byte, truncate bool) ([]byte, bool) {
+	var query dnsmessage.Message
+	if err := query.Unpack(packed); err != nil || len(query.Questions) != 1 {
+		t.Errorf("malformed query: %v", err)
+		return nil, false
+	}
+	s.mu.Lock()
+	s.queries = append(s.queries, query)
+	s.mu.Unlock()
+
+	msg := dnsmessage.Message{}
+	if !truncate {
+		msg = s.handle(query.Questions[0])
+	}
+	msg.Header.ID = query.Header.ID
+	msg.Header.Response = true
+	msg.Header.Truncated = truncate
+	msg.Questions = query.Questions
+	answer, err := msg.Pack()
+	if err != nil {
+		t.Errorf("packing answer: %v", err)
+		return nil, false
+	}
+	return answer, true
+}
+
+func name(s string) dnsmessage.Name {
+	return dnsmessage.MustNewName(s)
+}
+
+func header(owner string, qtype dnsmessage.Type, ttl uint32) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{Name: name(owner), Type: qtype, Class: dnsmessage.ClassINET, TTL: ttl}
+}
+
+func soa(ttl, minTTL uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: header("example.com.", dnsmessage.TypeSOA, ttl),
+		Body: &dnsmessage.SOAResource{
+			NS:     name("ns.example.com."),
+			MBox:   name("hostmaster.example.com."),
+			MinTTL: minTTL,
+		},
+	}
+}
+
+func TestLookupFollowsCNAMEChain(t *testing.T) {
+	s := &server{handle: func(q dnsmessage.Question) dnsmessage.Message {
+		if q.Type != dnsmessage.TypeA {
+			return dnsmessage.Message{Authorities: []dnsmessage.Resource{soa(600, 90)}}
+		}
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{
+			{Header: header("www.example.com.", dnsmessage.TypeCNAME, 120), Body: &dnsmessage.CNAMEResource{CNAME: name("a.example.net.")}},
+			{Header: header("a.example.net.", dnsmessage.TypeCNAME, 3600), Body: &dnsmessage.CNAMEResource{CNAME: name("b.example.net.")}},
+			{Header: header("b.example.net.", dnsmessage.TypeA, 300), Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 2}}},
+			{Header: header("b.example.net.", dnsmessage.TypeA, 300), Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}},
+			// Not on the chain, so not used.
+			{Header: header("other.example.org.", dnsmessage.TypeA, 5), Body: &dnsmessage.AResource{A: [4]byte{198, 51, 100, 1}}},
+		}}
+	}}
+	r := &Resolver{Servers: []string{s.start(t)}}
+
+	answer, err := r.Lookup(context.Background(), "WWW.example.com.")
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	want := []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")}
+	if !reflect.DeepEqual(answer.Addresses, want) {
+		t.Errorf("addresses = %v, want %v", answer.Addresses, want)
+	}
+	// The lowest of the chain's TTLs and the negative TTL of the empty AAAA
+	// answer.
+	if answer.TTL != 90*time.Second {
+		t.Errorf("TTL = %v, want 1m30s", answer.TTL)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, query := range s.queries {
+		if !query.Header.RecursionDesired {
+			t.Error("query does not ask for recursion")
+		}
+		if q := query.Questions[0]; q.Name.String() != "www.example.com." || q.Class != dnsmessage.ClassINET {
+			t.Errorf("question = %v, want www.example.com. IN", q)
+		}
+		if len(query.Additionals) != 1 || query.Additionals[0].Header.Type != dnsmessage.TypeOPT || query.Additionals[0].Header.Class != udpSize {
+			t.Errorf("query does not advertise EDNS with %d bytes: %v", udpSize, query.Additionals)
+		}
+	}
+}
+
+func TestLookupCNAMELoop(t *testing.T) {
+	s := &server{handle: func(q dnsmessage.Question) dnsmessage.Message {
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{
+			{Header: header("loop.example.com.", dnsmessage.TypeCNAME, 300), Body: &dnsmessage.CNAMEResource{CNAME: name("back.example.com.")}},
+			{Header: header("back.example.com.", dnsmessage.TypeCNAME, 300), Body: &dnsmessage.CNAMEResource{CNAME: name("loop.example.com.")}},
+		}}
+	}}
+	r := &Resolver{Servers: []string{s.start(t)}}
+
+	answer, err := r.Lookup(context.Background(), "loop.example.com")
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if len(answer.Addresses) != 0 || answer.TTL != MinTTL {
+		t.Errorf("answer = %+v, want no addresses for %v", answer, MinTTL)
+	}
+}
+
+func TestLookupRetriesTruncatedOverTCP(t *testing.T) {
+	s := &server{udpTruncate: true, handle: func(q dnsmessage.Question) dnsmessage.Message {
+		if q.Type != dnsmessage.TypeAAAA {
+			return dnsmessage.Message{}
+		}
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{
+			{Header: header("big.example.com.", dnsmessage.TypeAAAA, 7200), Body: &dnsmessage.AAAAResource{AAAA: netip.MustParseAddr("2001:db8::1").As16()}},
+		}}
+	}}
+	r := &Resolver{Servers: []string{s.start(t)}}
+
+	answer, err := r.Lookup(context.Background(), "big.example.com")
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if want := []netip.Addr{netip.MustParseAddr("2001:db8::1")}; !reflect.DeepEqual(answer.Addresses, want) {
+		t.Errorf("addresses = %v, want %v", answer.Addresses, want)
+	}
+	// The empty A answer has no SOA, so it is kept for MinTTL.
+	if answer.TTL != MinTTL {
+		t.Errorf("TTL = %v, want %v", answer.TTL, MinTTL)
+	}
+}
+
+func TestLookupNameError(t *testing.T) {
+	s := &server{handle: func(q dnsmessage.Question) dnsmessage.Message {
+		msg := dnsmessage.Message{Authorities: []dnsmessage.Resource{soa(45, 300)}}
+		msg.Header.RCode = dnsmessage.RCodeNameError
+		return msg
+	}}
+	r := &Resolver{Servers: []string{s.start(t)}}
+
+	answer, err := r.Lookup(context.Background(), "gone.example.com")
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if len(answer.Addresses) != 0 || answer.TTL != 45*time.Second {
+		t.Errorf("answer = %+v, want no addresses for 45s", answer)
+	}
+}
+
+func TestLookupServerFailure(t *testing.T) {
+	failing := &server{handle: func(q dnsmessage.Question) dnsmessage.Message {
+		msg := dnsmessage.Message{}
+		msg.Header.RCode = dnsmessage.RCodeServerFailure
+		return msg
+	}}
+	r := &Resolver{Servers: []string{failing.start(t)}}
+
+	if _, err := r.Lookup(context.Background(), "www.example.com"); !errors.Is(err, ErrServer) {
+		t.Errorf("Lookup error = %v, want ErrServer", err)
+	}
+
+	// The next server is asked when one fails.
+	working := &server{handle: func(q dnsmessage.Question) dnsmessage.Message {
+		if q.Type != dnsmessage.TypeA {
+			return dnsmessage.Message{}
+		}
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{
+			{Header: header("www.example.com.", dnsmessage.TypeA, 300), Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}},
+		}}
+	}}
+	r.Servers = append(r.Servers, working.start(t))
+	answer, err := r.Lookup(context.Background(), "www.example.com")
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if want := []netip.Addr{netip.MustParseAddr("192.0.2.1")}; !reflect.DeepEqual(answer.Addresses, want) {
+		t.Errorf("addresses = %v, want %v", answer.Addresses, want)
+	}
+}
+
+func TestNewResolverAddsPort(t *testing.T) {
+	r := NewResolver([]string{"192.0.2.53", "[2001:db8::53]:5353", "dns.example.com"})
+	want := []string{"192.0.2.53:53", "[2001:db8::53]:5353", "dns.example.com:53"}
+	if !reflect.DeepEqual(r.Servers, want) {
+		t.Errorf("servers = %v, want %v", r.Servers, want)
+	}
+}
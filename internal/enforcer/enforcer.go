@@ -4,121 +4,155 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"sync"
+	"strings"
 
 	"github.com/coreos/go-iptables/iptables"
 	"github.com/hanshal101/snapwall/models"
 )
 
+// ReconcileEnforcer applies a policy with its resolved entries: an enforcer
+// policy's rules are added unless the chain already has them, and the rules
+// a deforcer policy names are removed.
 func ReconcileEnforcer(
 	ctx context.Context,
 	policy models.Policy,
 	ips []models.IP,
 	ports []models.Port,
 ) error {
-
-	log.Println("Reconciler Enforcer Started !!!")
-
-	ipt, err := iptables.New()
+	chain, err := newChain()
 	if err != nil {
-		log.Fatalf("Error creating iptables instance: %v\n", err)
-		return err
+		return fmt.Errorf("error creating iptables instance: %w", err)
 	}
 
-	var wg sync.WaitGroup
-
-	log.Println("in loop !!!")
-	for _, ip := range ips {
-		for _, port := range ports {
-			wg.Add(1)
-
-			go func(policy models.Policy, ip models.IP, port models.Port) {
-				defer wg.Done()
-
-				log.Printf("Working for %v of %v from %v \n", policy.Type, ip.Address, port.Number)
-
-				if policy.Type == "enforcer" {
-					err = enforceRule(ipt, ip.Address, port.Number)
-				} else if policy.Type == "deforcer" {
-					err = deforceRule(ipt, ip.Address, port.Number)
-				}
-
-				if err != nil {
-					log.Printf("Error processing policy %s: %v\n", policy.Name, err)
-				} else {
-					log.Printf("Processed policy %s (Type: %s) for IP: %s, Port: %s\n", policy.Name, policy.Type, ip.Address, port.Number)
-				}
-			}(policy, ip, port)
+	rules := Rules(policy, ips, ports)
+	switch policy.Type {
+	case "enforcer":
+		if UsesSet(policy) {
+			if err := syncSet(SetName(policy), ips); err != nil {
+				return fmt.Errorf("error processing policy %s: %w", policy.Name, err)
+			}
 		}
+		err = add(chain, rules)
+	case "deforcer":
+		err = remove(chain, rules)
 	}
-
-	wg.Wait()
-	log.Println("Reconciler Enforcer Stopped !!!")
-	return nil
-}
-
-func enforceRule(ipt *iptables.IPTables, ip, port string) error {
-	log.Printf("Executing: iptables -A INPUT -s %s -p tcp --dport %s -j DROP\n", ip, port)
-
-	err := ipt.AppendUnique("filter", "INPUT", "-s", ip, "-p", "tcp", "--dport", port, "-j", "DROP")
 	if err != nil {
-		return fmt.Errorf("failed to enforce rule for IP: %s, Port: %s, error: %v", ip, port, err)
+		return fmt.Errorf("error processing policy %s: %w", policy.Name, err)
 	}
-
-	log.Printf("Enforced rule for IP: %s, Port: %s\n", ip, port)
+	log.Printf("Processed policy %s (Type: %s): %d rules", policy.Name, policy.Type, len(rules))
 	return nil
 }
 
-func deforceRule(ipt *iptables.IPTables, ip, port string) error {
-	log.Printf("Executing: iptables -D INPUT -s %s -p tcp --dport %s -j DROP\n", ip, port)
-
-	err := ipt.Delete("filter", "INPUT", "-s", ip, "-p", "tcp", "--dport", port, "-j", "DROP")
-	if err != nil {
-		return fmt.Errorf("failed to deforce rule for IP: %s, Port: %s, error: %v", ip, port, err)
+// Protocol returns the iptables protocol for a port, defaulting to tcp.
+func Protocol(port models.Port) string {
+	if port.Protocol == "" {
+		return "tcp"
 	}
-	log.Printf("Deforced rule for IP: %s, Port: %s\n", ip, port)
-	return nil
+	return strings.ToLower(port.Protocol)
 }
 
+// DeleteRule removes the rules of a policy for the given entries, whatever
+// they are tagged with, and the policy's set if it has one.
 func DeleteRule(
 	ctx context.Context,
 	policy models.Policy,
 	ips []models.IP,
 	ports []models.Port,
 ) error {
-	log.Println("Delettion request made !!!")
-
-	fmt.Println("del req made for:", policy)
-
-	ipt, err := iptables.New()
-	if err != nil {
-		log.Fatalf("Error creating iptables instance: %v\n", err)
+	if err := Remove(ctx, Rules(policy, ips, ports)); err != nil {
 		return err
 	}
+	if UsesSet(policy) {
+		return DestroySet(policy)
+	}
+	return nil
+}
 
-	var wg sync.WaitGroup
+// Remove deletes every copy of the given rules from the chain.
+func Remove(ctx context.Context, rules []Rule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	chain, err := newChain()
+	if err != nil {
+		return fmt.Errorf("error creating iptables instance: %w", err)
+	}
+	return remove(chain, rules)
+}
 
-	log.Println("in loop !!!")
-	for _, ip := range ips {
-		for _, port := range ports {
-			wg.Add(1)
+// RuleCounter holds the kernel counters of the DROP rules in the INPUT chain
+// that drop the traffic of Rule.
+type RuleCounter struct {
+	Rule    Rule
+	Packets uint64
+	Bytes   uint64
+}
 
-			go func(policy models.Policy, ip models.IP, port models.Port) {
-				defer wg.Done()
+// Counters reads the packet and byte counters of the DROP rules in the INPUT
+// chain. Rules that differ only in their comment are summed.
+func Counters() ([]RuleCounter, error) {
+	ipt, err := iptables.New()
+	if err != nil {
+		return nil, err
+	}
 
-				err = deforceRule(ipt, ip.Address, port.Number)
-				if err != nil {
-					log.Printf("Error processing policy %s: %v\n", policy.Name, err)
-				} else {
-					log.Printf("Processed policy %s (Type: %s) for IP: %s, Port: %s\n", policy.Name, policy.Type, ip.Address, port.Number)
-				}
+	stats, err := ipt.StructuredStats("filter", "INPUT")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read iptables counters: %v", err)
+	}
 
-			}(policy, ip, port)
+	var counters []RuleCounter
+	index := make(map[string]int)
+	for _, stat := range stats {
+		if stat.Target != "DROP" || stat.Source == nil {
+			continue
 		}
+		rule := Rule{Source: stat.Source.String(), Protocol: stat.Protocol, Port: dport(stat.Options)}
+		if set := matchSet(stat.Options); set != "" {
+			rule.Source = ""
+			rule.Set = set
+		}
+		switch {
+		case rule.Port != "":
+		case rule.Protocol == ProtocolAll || rule.Protocol == "0":
+			rule.Protocol = ProtocolAll
+		default:
+			continue
+		}
+		i, ok := index[rule.Key()]
+		if !ok {
+			i = len(counters)
+			index[rule.Key()] = i
+			counters = append(counters, RuleCounter{Rule: rule})
+		}
+		counters[i].Packets += stat.Packets
+		counters[i].Bytes += stat.Bytes
 	}
+	return counters, nil
+}
 
-	wg.Wait()
+// dport extracts the destination port from iptables' listing of a rule's
+// options, e.g. "tcp dpt:22" or "udp dpts:1000:2000 /* tag */".
+func dport(options string) string {
+	for _, field := range strings.Fields(options) {
+		if port, ok := strings.CutPrefix(field, "dpt:"); ok {
+			return port
+		}
+		if port, ok := strings.CutPrefix(field, "dpts:"); ok {
+			return port
+		}
+	}
+	return ""
+}
 
-	log.Println("Escaping Deletion !!!")
-	return nil
+// matchSet extracts the set a rule matches sources against from iptables'
+// listing of its options, e.g. "match-set snapwall-4 src".
+func matchSet(options string) string {
+	fields := strings.Fields(options)
+	for i := 0; i+2 < len(fields); i++ {
+		if fields[i] == "match-set" && fields[i+2] == "src" {
+			return fields[i+1]
+		}
+	}
+	return ""
 }
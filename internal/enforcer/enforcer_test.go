@@ -0,0 +1,314 @@
+package enforcer
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
+)
+
+// fakeChain keeps the INPUT chain the way iptables -S lists it and, like
+// iptables, only deletes a rule given exactly, comment included.
+type fakeChain struct {
+	rules []string
+}
+
+func (f *fakeChain) List(table, chain string) ([]string, error) {
+	return append([]string{"-P INPUT ACCEPT"}, f.rules...), nil
+}
+
+func (f *fakeChain) Append(table, chain string, rulespec ...string) error {
+	f.rules = append(f.rules, canonical(rulespec))
+	return nil
+}
+
+func (f *fakeChain) Delete(table, chain string, rulespec ...string) error {
+	line := canonical(rulespec)
+	for i, rule := range f.rules {
+		if rule == line {
+			f.rules = append(f.rules[:i], f.rules[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("iptables: Bad rule (does a matching rule exist in that chain?)")
+}
+
+// canonical renders a rule spec as iptables -S prints it.
+func canonical(spec []string) string {
+	opts := make(map[string]string)
+	for i := 0; i+1 < len(spec); i += 2 {
+		switch spec[i] {
+		case "-m":
+			// Modules are implied by the options that follow them.
+		case "--match-set":
+			opts[spec[i]] = spec[i+1] + " " + spec[i+2]
+			i++
+		default:
+			opts[spec[i]] = spec[i+1]
+		}
+	}
+	parts := []string{"-A", "INPUT"}
+	if source, ok := opts["-s"]; ok {
+		parts = append(parts, "-s", normalize(source))
+	}
+	if protocol, ok := opts["-p"]; ok {
+		parts = append(parts, "-p", protocol, "-m", protocol, "--dport", opts["--dport"])
+	}
+	if set, ok := opts["--match-set"]; ok {
+		parts = append(parts, "-m", "set", "--match-set", set)
+	}
+	if comment, ok := opts["--comment"]; ok {
+		parts = append(parts, "-m", "comment", "--comment", comment)
+	}
+	parts = append(parts, "-j", opts["-j"])
+	return strings.Join(parts, " ")
+}
+
+func useChain(t *testing.T, chain *fakeChain) {
+	previous := newChain
+	newChain = func() (Chain, error) { return chain, nil }
+	t.Cleanup(func() { newChain = previous })
+}
+
+// fakeSets keeps ipsets by name, as far as ipset restore, list -n and
+// destroy go.
+type fakeSets map[string][]string
+
+func (f fakeSets) run(stdin string, args ...string) (string, error) {
+	switch args[0] {
+	case "list":
+		var names []string
+		for name := range f {
+			names = append(names, name)
+		}
+		return strings.Join(names, "\n"), nil
+	case "destroy":
+		if _, ok := f[args[1]]; !ok {
+			return "", errors.New("ipset destroy: The set with the given name does not exist")
+		}
+		delete(f, args[1])
+		return "", nil
+	}
+	for _, line := range strings.Split(strings.TrimSpace(stdin), "\n") {
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "create":
+			if _, ok := f[fields[1]]; !ok {
+				f[fields[1]] = []string{}
+			}
+		case "flush":
+			f[fields[1]] = []string{}
+		case "add":
+			f[fields[1]] = append(f[fields[1]], fields[2])
+		case "swap":
+			f[fields[1]], f[fields[2]] = f[fields[2]], f[fields[1]]
+		case "destroy":
+			delete(f, fields[1])
+		}
+	}
+	return "", nil
+}
+
+func useSets(t *testing.T) fakeSets {
+	sets := make(fakeSets)
+	previous := runIpset
+	runIpset = sets.run
+	setsMu.Lock()
+	synced = make(map[string]string)
+	setsMu.Unlock()
+	t.Cleanup(func() { runIpset = previous })
+	return sets
+}
+
+func enforcerPolicy(name string) models.Policy {
+	return models.Policy{Name: name, Type: "enforcer"}
+}
+
+func TestDeleteRuleRemovesTaggedRules(t *testing.T) {
+	chain := &fakeChain{rules: []string{
+		"-A INPUT -s 10.0.0.1/32 -p tcp -m tcp --dport 22 -m comment --comment reconcile-1700000000 -j DROP",
+		"-A INPUT -s 10.0.0.1/32 -p tcp -m tcp --dport 22 -m comment --comment snapwall -j DROP",
+		"-A INPUT -s 10.0.0.2/32 -p tcp -m tcp --dport 22 -m comment --comment snapwall -j DROP",
+	}}
+	useChain(t, chain)
+	useSets(t)
+
+	ips := []models.IP{{Address: "10.0.0.1"}}
+	ports := []models.Port{{Number: "22"}}
+	if err := DeleteRule(context.Background(), enforcerPolicy("block"), ips, ports); err != nil {
+		t.Fatalf("DeleteRule: %v", err)
+	}
+	want := []string{"-A INPUT -s 10.0.0.2/32 -p tcp -m tcp --dport 22 -m comment --comment snapwall -j DROP"}
+	if !reflect.DeepEqual(chain.rules, want) {
+		t.Errorf("rules = %q, want %q", chain.rules, want)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	operator := "-A INPUT -s 192.0.2.1/32 -p tcp -m tcp --dport 80 -j DROP"
+	chain := &fakeChain{rules: []string{operator}}
+	useChain(t, chain)
+	useSets(t)
+
+	block := Target{
+		Policy: enforcerPolicy("block"),
+		IPs:    []models.IP{{Address: "10.0.0.1"}, {Address: "10.1.0.0/16"}},
+		Ports:  []models.Port{{Number: "22"}, {Number: "53", Protocol: "UDP"}},
+	}
+	for i := 0; i < 2; i++ {
+		if err := Reconcile([]Target{block}); err != nil {
+			t.Fatalf("Reconcile: %v", err)
+		}
+		if len(chain.rules) != 5 {
+			t.Fatalf("pass %d: rules = %q, want the operator's and 4 of snapwall's", i, chain.rules)
+		}
+	}
+
+	// A deforcer removes the operator's rule, and rules of policies that are
+	// gone are removed.
+	unblock := Target{
+		Policy: models.Policy{Name: "unblock", Type: "deforcer"},
+		IPs:    []models.IP{{Address: "192.0.2.1"}},
+		Ports:  []models.Port{{Number: "80"}},
+	}
+	if err := Reconcile([]Target{unblock}); err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if len(chain.rules) != 0 {
+		t.Errorf("rules = %q, want none", chain.rules)
+	}
+}
+
+func TestReconcileKeepsOperatorRules(t *testing.T) {
+	operator := "-A INPUT -s 192.0.2.1/32 -p tcp -m tcp --dport 80 -j DROP"
+	chain := &fakeChain{rules: []string{
+		operator,
+		"-A INPUT -s 10.0.0.9/32 -p tcp -m tcp --dport 22 -m comment --comment reconcile-1700000000 -j DROP",
+	}}
+	useChain(t, chain)
+	useSets(t)
+
+	if err := Reconcile(nil); err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if want := []string{operator}; !reflect.DeepEqual(chain.rules, want) {
+		t.Errorf("rules = %q, want %q", chain.rules, want)
+	}
+}
+
+func TestReconcileSets(t *testing.T) {
+	chain := &fakeChain{}
+	useChain(t, chain)
+	sets := useSets(t)
+
+	feed := Target{
+		Policy: models.Policy{
+			Model:         gorm.Model{ID: 4},
+			Name:          "feed",
+			Type:          "enforcer",
+			AddressGroups: []models.AddressGroup{{Managed: "feed"}},
+		},
+		IPs:   []models.IP{{Address: "10.0.0.1"}, {Address: "10.1.0.0/16"}, {Address: "2001:db8::1"}},
+		Ports: []models.Port{{Number: "1:65535", Protocol: ProtocolAll}},
+	}
+	for i := 0; i < 2; i++ {
+		if err := Reconcile([]Target{feed}); err != nil {
+			t.Fatalf("Reconcile: %v", err)
+		}
+	}
+	rule := "-A INPUT -m set --match-set snapwall-4 src -m comment --comment snapwall -j DROP"
+	if want := []string{rule}; !reflect.DeepEqual(chain.rules, want) {
+		t.Errorf("rules = %q, want %q", chain.rules, want)
+	}
+	if want := (fakeSets{"snapwall-4": {"10.0.0.1/32", "10.1.0.0/16"}}); !reflect.DeepEqual(sets, want) {
+		t.Errorf("sets = %v, want %v", sets, want)
+	}
+
+	// A set that went missing is created again.
+	delete(sets, "snapwall-4")
+	if err := Reconcile([]Target{feed}); err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if len(sets["snapwall-4"]) != 2 {
+		t.Errorf("sets = %v, want snapwall-4 filled again", sets)
+	}
+
+	// The rule and set of a policy that is gone are removed.
+	if err := Reconcile(nil); err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if len(chain.rules) != 0 || len(sets) != 0 {
+		t.Errorf("rules = %q, sets = %v; want none", chain.rules, sets)
+	}
+}
+
+func TestParseListed(t *testing.T) {
+	tests := []struct {
+		line    string
+		rule    Rule
+		comment string
+		drop    bool
+	}{
+		{
+			line:    "-A INPUT -s 10.0.0.1/32 -p tcp -m tcp --dport 22 -m comment --comment reconcile-1 -j DROP",
+			rule:    Rule{Source: "10.0.0.1/32", Protocol: "tcp", Port: "22"},
+			comment: "reconcile-1",
+			drop:    true,
+		},
+		{
+			line:    `-A INPUT -s 10.0.0.0/8 -p udp -m udp --dport 1000:2000 -m comment --comment "two words" -j DROP`,
+			rule:    Rule{Source: "10.0.0.0/8", Protocol: "udp", Port: "1000:2000"},
+			comment: "two words",
+			drop:    true,
+		},
+		{
+			line: "-A INPUT -i eth0 -s 10.0.0.1/32 -p tcp -m tcp --dport 22 -j DROP",
+			rule: Rule{Source: "10.0.0.1/32", Protocol: "tcp", Port: "22"},
+		},
+		{
+			line: "-A INPUT -s 10.0.0.1/32 -p tcp -m tcp --dport 22 -j ACCEPT",
+			rule: Rule{Source: "10.0.0.1/32", Protocol: "tcp", Port: "22"},
+		},
+	}
+	for _, test := range tests {
+		l, ok := parseListed(test.line)
+		if !ok {
+			t.Errorf("parseListed(%q) skipped the rule", test.line)
+			continue
+		}
+		if l.rule != test.rule || l.comment != test.comment || l.drop != test.drop {
+			t.Errorf("parseListed(%q) = %+v, %q, %v; want %+v, %q, %v", test.line, l.rule, l.comment, l.drop, test.rule, test.comment, test.drop)
+		}
+	}
+	set, ok := parseListed("-A INPUT -m set --match-set snapwall-4 src -m comment --comment snapwall -j DROP")
+	if want := (Rule{Set: "snapwall-4", Protocol: ProtocolAll}); !ok || set.rule != want || !set.drop {
+		t.Errorf("parseListed of a set rule = %+v, %v; want %+v dropped", set.rule, set.drop, want)
+	}
+	if _, ok := parseListed("-P INPUT ACCEPT"); ok {
+		t.Error("parseListed kept the chain policy")
+	}
+}
+
+func TestUsesSet(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy models.Policy
+		want   bool
+	}{
+		{"addresses", enforcerPolicy("block"), false},
+		{"feed", models.Policy{Type: "enforcer", AddressGroups: []models.AddressGroup{{Managed: "feed"}}}, true},
+		{"countries", models.Policy{Type: "enforcer", Countries: []string{"NL"}}, true},
+		{"asns", models.Policy{Type: "enforcer", ASNs: []uint32{64496}}, true},
+		{"networks left after the selectors", models.Policy{Type: "enforcer", GeoNetworks: []models.PolicyGeoNetwork{{Address: "192.0.2.0/24"}}}, true},
+		{"deforcer", models.Policy{Type: "deforcer", Countries: []string{"NL"}}, false},
+	}
+	for _, test := range tests {
+		if got := UsesSet(test.policy); got != test.want {
+			t.Errorf("%s: UsesSet = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package enforcer
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hanshal101/snapwall/models"
+)
+
+// Target is a policy with its resolved entries, as the reconciler enforces
+// it.
+type Target struct {
+	Policy models.Policy
+	IPs    []models.IP
+	Ports  []models.Port
+}
+
+// Reconcile brings the INPUT chain in line with all policies at once, with
+// one listing of the chain: rules of enforcer policies that are missing are
+// added, the rules deforcer policies name are removed, and so are the rules
+// snapwall added that no policy wants anymore. Rules that are already right
+// are left alone. The sets of policies enforced through an ipset are
+// brought up to date before the rules, and sets no policy uses anymore are
+// destroyed after them.
+func Reconcile(targets []Target) error {
+	chain, err := newChain()
+	if err != nil {
+		return fmt.Errorf("error creating iptables instance: %w", err)
+	}
+
+	existing, err := listSets()
+	if err != nil {
+		return err
+	}
+	forgetMissing(existing)
+
+	var wanted []Rule
+	wantedKeys := make(map[string]bool)
+	unwanted := make(map[string]bool)
+	sets := make(map[string]bool)
+	for _, target := range targets {
+		rules := Rules(target.Policy, target.IPs, target.Ports)
+		switch target.Policy.Type {
+		case "enforcer":
+			if UsesSet(target.Policy) {
+				name := SetName(target.Policy)
+				sets[name] = true
+				// On failure the set keeps its old members.
+				if err := syncSet(name, target.IPs); err != nil {
+					log.Printf("Error syncing set %s: %v", name, err)
+				}
+			}
+			for _, rule := range rules {
+				if !wantedKeys[rule.Key()] {
+					wantedKeys[rule.Key()] = true
+					wanted = append(wanted, rule)
+				}
+			}
+		case "deforcer":
+			for _, rule := range rules {
+				unwanted[rule.Key()] = true
+			}
+		}
+	}
+
+	listed, err := list(chain)
+	if err != nil {
+		return err
+	}
+	present := make(map[string]bool)
+	for _, l := range listed {
+		if !l.drop && !l.owned() {
+			continue
+		}
+		key := l.rule.Key()
+		if l.drop && !unwanted[key] && wantedKeys[key] && !present[key] {
+			present[key] = true
+			continue
+		}
+		if l.drop && !unwanted[key] && !l.owned() {
+			// The operator's own rule.
+			continue
+		}
+		if err := chain.Delete("filter", "INPUT", l.spec...); err != nil {
+			log.Printf("Error deleting rule %v: %v", l.spec, err)
+			continue
+		}
+		log.Printf("Deleted stale rule %v", l.spec)
+	}
+
+	for _, name := range existing {
+		if sets[name] {
+			continue
+		}
+		if err := destroySet(name); err != nil {
+			log.Printf("Error destroying set %s: %v", name, err)
+			continue
+		}
+		log.Printf("Destroyed stale set %s", name)
+	}
+
+	for _, rule := range wanted {
+		if present[rule.Key()] || unwanted[rule.Key()] {
+			continue
+		}
+		if err := chain.Append("filter", "INPUT", rule.spec()...); err != nil {
+			log.Printf("Error enforcing rule for %s: %v", rule, err)
+			continue
+		}
+		log.Printf("Enforced rule for %s", rule)
+	}
+	return nil
+}
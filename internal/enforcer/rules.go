@@ -0,0 +1,288 @@
+package enforcer
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"strings"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/hanshal101/snapwall/models"
+)
+
+// Comment tags the rules snapwall adds to the INPUT chain, so the ones it no
+// longer wants can be told apart from rules it does not own. Rules tagged
+// reconcile-<time> by earlier versions are its own as well.
+const Comment = "snapwall"
+
+const legacyComment = "reconcile-"
+
+// ProtocolAll marks ports that stand for all traffic, whatever the protocol.
+// Their rules have no protocol or port.
+const ProtocolAll = "all"
+
+// Chain is the part of go-iptables the enforcer works through.
+type Chain interface {
+	List(table, chain string) ([]string, error)
+	Append(table, chain string, rulespec ...string) error
+	Delete(table, chain string, rulespec ...string) error
+}
+
+// newChain opens the chains of iptables.
+var newChain = func() (Chain, error) {
+	return iptables.New()
+}
+
+// Rule is a DROP rule on the INPUT chain for traffic from Source, an address
+// or CIDR, or from the members of the ipset Set, to Port over Protocol.
+type Rule struct {
+	Source   string
+	Set      string
+	Protocol string
+	Port     string
+}
+
+// Rules returns the rules that enforce a policy with its resolved entries:
+// a rule per source and port, or per port for the policy's set if it uses
+// one.
+func Rules(policy models.Policy, ips []models.IP, ports []models.Port) []Rule {
+	var sources []Rule
+	if UsesSet(policy) {
+		sources = []Rule{{Set: SetName(policy)}}
+	} else {
+		for _, ip := range ips {
+			sources = append(sources, Rule{Source: ip.Address})
+		}
+	}
+
+	rules := make([]Rule, 0, len(sources)*len(ports))
+	seen := make(map[string]bool)
+	for _, source := range sources {
+		for _, port := range ports {
+			rule := source
+			rule.Protocol = Protocol(port)
+			if rule.Protocol != ProtocolAll {
+				rule.Port = port.Number
+			}
+			if !seen[rule.Key()] {
+				seen[rule.Key()] = true
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules
+}
+
+// Key identifies the traffic a rule drops, ignoring how it is written and
+// what it is tagged with.
+func (r Rule) Key() string {
+	source := normalize(r.Source)
+	if r.Set != "" {
+		source = "set:" + r.Set
+	}
+	if r.Protocol == ProtocolAll {
+		return source + " " + ProtocolAll
+	}
+	return source + " " + r.Protocol + "/" + r.Port
+}
+
+func (r Rule) spec() []string {
+	var spec []string
+	if r.Set != "" {
+		spec = append(spec, "-m", "set", "--match-set", r.Set, "src")
+	} else {
+		spec = append(spec, "-s", r.Source)
+	}
+	if r.Protocol != ProtocolAll {
+		spec = append(spec, "-p", r.Protocol, "--dport", r.Port)
+	}
+	return append(spec, "-m", "comment", "--comment", Comment, "-j", "DROP")
+}
+
+// String describes a rule in log messages.
+func (r Rule) String() string {
+	port := r.Port
+	if r.Protocol == ProtocolAll {
+		port = ProtocolAll
+	}
+	if r.Set != "" {
+		return fmt.Sprintf("set: %s, Port: %s", r.Set, port)
+	}
+	return fmt.Sprintf("IP: %s, Port: %s", r.Source, port)
+}
+
+// normalize writes an address the way iptables lists it, as a masked CIDR.
+func normalize(address string) string {
+	if prefix, err := netip.ParsePrefix(address); err == nil {
+		return prefix.Masked().String()
+	}
+	if addr, err := netip.ParseAddr(address); err == nil {
+		return netip.PrefixFrom(addr, addr.BitLen()).String()
+	}
+	return address
+}
+
+// listedRule is a rule of the INPUT chain as iptables -S prints it.
+type listedRule struct {
+	// spec deletes the rule exactly as it is, comment included.
+	spec    []string
+	rule    Rule
+	comment string
+	// drop is set for DROP rules made only of a source, a protocol and a
+	// port, which rule then describes.
+	drop bool
+}
+
+// owned reports whether snapwall added the rule.
+func (l listedRule) owned() bool {
+	return l.comment == Comment || strings.HasPrefix(l.comment, legacyComment)
+}
+
+func list(chain Chain) ([]listedRule, error) {
+	lines, err := chain.List("filter", "INPUT")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list iptables rules: %w", err)
+	}
+	var rules []listedRule
+	for _, line := range lines {
+		if l, ok := parseListed(line); ok {
+			rules = append(rules, l)
+		}
+	}
+	return rules, nil
+}
+
+func parseListed(line string) (listedRule, bool) {
+	fields := splitFields(line)
+	if len(fields) < 2 || fields[0] != "-A" {
+		return listedRule{}, false
+	}
+	l := listedRule{spec: fields[2:]}
+
+	known, target := true, ""
+	args := l.spec
+	for i := 0; i < len(args); i++ {
+		value := ""
+		if i+1 < len(args) {
+			value = args[i+1]
+		}
+		switch args[i] {
+		case "-s":
+			l.rule.Source = value
+		case "-p":
+			l.rule.Protocol = value
+		case "--dport":
+			l.rule.Port = value
+		case "--match-set":
+			// Only sets of sources are snapwall's.
+			l.rule.Set = value
+			if i+2 >= len(args) || args[i+2] != "src" {
+				known = false
+			}
+			i++
+		case "--comment":
+			l.comment = value
+		case "-j":
+			target = value
+		case "-m":
+			// Modules are implied by the options that follow them.
+			if value != "tcp" && value != "udp" && value != "comment" && value != "set" {
+				known = false
+			}
+		default:
+			known = false
+			continue
+		}
+		i++
+	}
+	if l.rule.Protocol == "" && l.rule.Port == "" {
+		l.rule.Protocol = ProtocolAll
+	}
+	hasSource := (l.rule.Source != "") != (l.rule.Set != "")
+	hasPort := l.rule.Protocol == ProtocolAll || l.rule.Port != ""
+	l.drop = known && target == "DROP" && hasSource && hasPort
+	return l, true
+}
+
+// splitFields splits a listed rule into its arguments, unquoting the ones
+// iptables quotes, such as comments with spaces.
+func splitFields(line string) []string {
+	var (
+		fields  []string
+		current strings.Builder
+		quoted  bool
+		started bool
+	)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && quoted && i+1 < len(line):
+			i++
+			current.WriteByte(line[i])
+		case c == '"':
+			quoted = !quoted
+			started = true
+		case c == ' ' && !quoted:
+			if started {
+				fields = append(fields, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteByte(c)
+			started = true
+		}
+	}
+	if started {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
+
+// add appends the rules the chain does not have yet.
+func add(chain Chain, rules []Rule) error {
+	listed, err := list(chain)
+	if err != nil {
+		return err
+	}
+	present := make(map[string]bool)
+	for _, l := range listed {
+		if l.drop {
+			present[l.rule.Key()] = true
+		}
+	}
+	for _, rule := range rules {
+		if present[rule.Key()] {
+			continue
+		}
+		if err := chain.Append("filter", "INPUT", rule.spec()...); err != nil {
+			return fmt.Errorf("failed to enforce rule for %s, error: %v", rule, err)
+		}
+		present[rule.Key()] = true
+		log.Printf("Enforced rule for %s", rule)
+	}
+	return nil
+}
+
+// remove deletes every copy of the rules from the chain, each by the spec it
+// is listed with so its comment matches.
+func remove(chain Chain, rules []Rule) error {
+	listed, err := list(chain)
+	if err != nil {
+		return err
+	}
+	unwanted := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		unwanted[rule.Key()] = true
+	}
+	for _, l := range listed {
+		if !l.drop || !unwanted[l.rule.Key()] {
+			continue
+		}
+		if err := chain.Delete("filter", "INPUT", l.spec...); err != nil {
+			return fmt.Errorf("failed to deforce rule for %s, error: %v", l.rule, err)
+		}
+		log.Printf("Deforced rule for %s", l.rule)
+	}
+	return nil
+}
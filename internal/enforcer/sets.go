@@ -0,0 +1,148 @@
+package enforcer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/hanshal101/snapwall/models"
+)
+
+// setPrefix starts the names of the ipsets snapwall owns.
+const setPrefix = "snapwall-"
+
+// maxSetSize bounds the members of a set.
+const maxSetSize = 1 << 20
+
+// UsesSet reports whether the sources of an enforcer policy go into an
+// ipset matched by one rule per port, rather than a rule each. Feeds and
+// countries or ASNs run to thousands of networks.
+func UsesSet(policy models.Policy) bool {
+	if policy.Type != "enforcer" {
+		return false
+	}
+	if len(policy.Countries) > 0 || len(policy.ASNs) > 0 || len(policy.GeoNetworks) > 0 {
+		return true
+	}
+	for _, group := range policy.AddressGroups {
+		if group.Managed != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// SetName names the ipset of a policy.
+func SetName(policy models.Policy) string {
+	return fmt.Sprintf("%s%d", setPrefix, policy.ID)
+}
+
+// runIpset runs ipset with args, feeding it stdin, and returns its output.
+var runIpset = func(stdin string, args ...string) (string, error) {
+	cmd := exec.Command("ipset", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("ipset %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+var (
+	setsMu sync.Mutex
+	// synced holds a checksum of the members last written to each set.
+	synced = make(map[string]string)
+)
+
+// syncSet makes the IPv4 sources among ips the members of a set, creating
+// it if needed. The members are loaded into a scratch set that is swapped
+// in, so the set is never seen half filled. Rules are only made on
+// iptables, so IPv6 sources are left out, as they are from rules of their
+// own.
+func syncSet(name string, ips []models.IP) error {
+	var members []string
+	for _, ip := range ips {
+		prefix, err := netip.ParsePrefix(normalize(ip.Address))
+		// hash:net sets hold networks of at least one bit.
+		if err != nil || !prefix.Addr().Is4() || prefix.Bits() == 0 {
+			continue
+		}
+		members = append(members, prefix.String())
+	}
+	sum := sha256.Sum256([]byte(strings.Join(members, "\n")))
+	checksum := hex.EncodeToString(sum[:])
+
+	setsMu.Lock()
+	defer setsMu.Unlock()
+	if synced[name] == checksum {
+		return nil
+	}
+
+	scratch := name + "-new"
+	var script strings.Builder
+	for _, set := range []string{name, scratch} {
+		fmt.Fprintf(&script, "create %s hash:net family inet maxelem %d -exist\n", set, maxSetSize)
+	}
+	fmt.Fprintf(&script, "flush %s\n", scratch)
+	for _, member := range members {
+		fmt.Fprintf(&script, "add %s %s -exist\n", scratch, member)
+	}
+	fmt.Fprintf(&script, "swap %s %s\n", scratch, name)
+	fmt.Fprintf(&script, "destroy %s\n", scratch)
+	if _, err := runIpset(script.String(), "restore"); err != nil {
+		delete(synced, name)
+		return err
+	}
+	synced[name] = checksum
+	return nil
+}
+
+// forgetMissing drops the checksums of sets that are gone from the kernel,
+// e.g. after a reboot, so that they are created again.
+func forgetMissing(existing []string) {
+	present := make(map[string]bool, len(existing))
+	for _, name := range existing {
+		present[name] = true
+	}
+	setsMu.Lock()
+	defer setsMu.Unlock()
+	for name := range synced {
+		if !present[name] {
+			delete(synced, name)
+		}
+	}
+}
+
+// DestroySet removes the set of a policy once no rule uses it anymore.
+func DestroySet(policy models.Policy) error {
+	return destroySet(SetName(policy))
+}
+
+func destroySet(name string) error {
+	setsMu.Lock()
+	defer setsMu.Unlock()
+	delete(synced, name)
+	if _, err := runIpset("", "destroy", name); err != nil && !strings.Contains(err.Error(), "does not exist") {
+		return err
+	}
+	return nil
+}
+
+// listSets returns the names of snapwall's sets.
+func listSets() ([]string, error) {
+	out, err := runIpset("", "list", "-n")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range strings.Fields(out) {
+		if strings.HasPrefix(name, setPrefix) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,215 @@
+package feeds
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanshal101/snapwall/database/psql"
+	"github.com/hanshal101/snapwall/internal/audit"
+	"github.com/hanshal101/snapwall/internal/policies"
+	"github.com/hanshal101/snapwall/models"
+)
+
+type FeedRequest struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Format   string `json:"format"`
+	Column   int    `json:"column"`
+	Interval int    `json:"interval"`
+	Enabled  bool   `json:"enabled"`
+	Block    bool   `json:"block"`
+	Severity string `json:"severity"`
+}
+
+// validateFeed checks a request to create a feed, or to update the feed
+// with the given ID.
+func validateFeed(req FeedRequest, id uint) []policies.FieldError {
+	var errs []policies.FieldError
+	if strings.TrimSpace(req.Name) == "" || strings.ContainsAny(req.Name, " \t\n") {
+		errs = append(errs, policies.FieldError{Field: "name", Message: "is required and must not contain spaces"})
+	} else if len(req.Name) > 200 {
+		errs = append(errs, policies.FieldError{Field: "name", Message: "must be at most 200 characters"})
+	} else {
+		var count int64
+		if err := psql.DB.Model(&models.Feed{}).Where("name = ? AND id <> ?", req.Name, id).Count(&count).Error; err != nil || count > 0 {
+			errs = append(errs, policies.FieldError{Field: "name", Message: fmt.Sprintf("feed %q already exists", req.Name)})
+		}
+	}
+	if strings.TrimSpace(req.Path) == "" {
+		errs = append(errs, policies.FieldError{Field: "path", Message: "is required"})
+	}
+
+	validFormat := false
+	for _, format := range Formats {
+		if req.Format == format {
+			validFormat = true
+		}
+	}
+	if !validFormat {
+		errs = append(errs, policies.FieldError{Field: "format", Message: "must be one of " + strings.Join(Formats, ", ")})
+	}
+	if req.Column < 0 {
+		errs = append(errs, policies.FieldError{Field: "column", Message: "must not be negative"})
+	}
+	if req.Interval < 0 {
+		errs = append(errs, policies.FieldError{Field: "interval", Message: "must not be negative"})
+	}
+	if _, ok := models.ParseSeverity(req.Severity); req.Severity != "" && !ok {
+		errs = append(errs, policies.FieldError{Field: "severity", Message: fmt.Sprintf("%q is not a severity", req.Severity)})
+	}
+	return errs
+}
+
+func (req FeedRequest) apply(feed *models.Feed) {
+	feed.Name = req.Name
+	feed.Path = req.Path
+	feed.Format = req.Format
+	feed.Column = req.Column
+	feed.Interval = req.Interval
+	feed.Enabled = req.Enabled
+	feed.Block = req.Block
+	feed.Severity, _ = models.ParseSeverity(req.Severity)
+}
+
+func GetFeeds(c *gin.Context) {
+	var feeds []models.Feed
+	if err := psql.DB.Order("name").Find(&feeds).Error; err != nil {
+		log.Printf("Error in fetching feeds: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching feeds"})
+		return
+	}
+	c.JSON(http.StatusOK, feeds)
+}
+
+func GetFeed(c *gin.Context) {
+	var feed models.Feed
+	if err := psql.DB.First(&feed, c.Param("feedID")).Error; err != nil {
+		log.Printf("Error fetching feed: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Feed not found"})
+		return
+	}
+	c.JSON(http.StatusOK, feed)
+}
+
+// CreateFeed stores a feed and refreshes it right away. A feed that cannot
+// be read is still created, with the error recorded on it.
+func CreateFeed(c *gin.Context) {
+	var req FeedRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Printf("Error in binding feed: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error in binding feed"})
+		return
+	}
+
+	if errs := validateFeed(req, 0); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid feed", "errors": errs})
+		return
+	}
+
+	var feed models.Feed
+	req.apply(&feed)
+
+	tx := psql.DB.Begin()
+	if err := tx.Create(&feed).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error in creating feed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in creating feed"})
+		return
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "create", audit.ObjectFeed, feed.ID, nil, feed); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing feed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing feed"})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusOK, refresh(c, feed))
+}
+
+func UpdateFeed(c *gin.Context) {
+	var req FeedRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Printf("Error in binding feed: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error in binding feed"})
+		return
+	}
+
+	var feed models.Feed
+	if err := psql.DB.First(&feed, c.Param("feedID")).Error; err != nil {
+		log.Printf("Error fetching feed: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Feed not found"})
+		return
+	}
+
+	if errs := validateFeed(req, feed.ID); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid feed", "errors": errs})
+		return
+	}
+
+	previous := feed
+	req.apply(&feed)
+	// Reading the file again picks up a changed path, format or column.
+	feed.Checksum = ""
+
+	tx := psql.DB.Begin()
+	if err := tx.Save(&feed).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error saving feed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving feed"})
+		return
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "update", audit.ObjectFeed, feed.ID, previous, feed); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing feed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing feed"})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusOK, refresh(c, feed))
+}
+
+func DeleteFeed(c *gin.Context) {
+	var feed models.Feed
+	if err := psql.DB.First(&feed, c.Param("feedID")).Error; err != nil {
+		log.Printf("Error fetching feed: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Feed not found"})
+		return
+	}
+
+	if err := Remove(context.TODO(), psql.DB, feed, audit.ActorFrom(c)); err != nil {
+		log.Printf("Error in deleting feed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in deleting feed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": "Feed Deleted Successfully"})
+}
+
+// RefreshFeed reads a feed again without waiting for its interval.
+func RefreshFeed(c *gin.Context) {
+	var feed models.Feed
+	if err := psql.DB.First(&feed, c.Param("feedID")).Error; err != nil {
+		log.Printf("Error fetching feed: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Feed not found"})
+		return
+	}
+	c.JSON(http.StatusOK, refresh(c, feed))
+}
+
+// refresh syncs a feed on behalf of the caller and returns its state
+// afterwards, including the error if the sync failed.
+func refresh(c *gin.Context, feed models.Feed) models.Feed {
+	synced, err := Sync(context.TODO(), psql.DB, feed, audit.ActorFrom(c))
+	if err != nil {
+		log.Printf("Error in refreshing feed %s: %v", feed.Name, err)
+		if err := psql.DB.First(&synced, feed.ID).Error; err != nil {
+			log.Printf("Error fetching feed: %v", err)
+		}
+	}
+	return synced
+}
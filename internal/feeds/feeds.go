@@ -0,0 +1,355 @@
+package feeds
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hanshal101/snapwall/internal/audit"
+	"github.com/hanshal101/snapwall/internal/enforcer"
+	"github.com/hanshal101/snapwall/internal/policies"
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
+)
+
+// Component marks the groups and policies created for feeds, see
+// models.Policy.Managed.
+const Component = "feed"
+
+// DefaultInterval applies to feeds without an interval of their own.
+const DefaultInterval = time.Hour
+
+// ActionRefresh is the audit action of a refresh that changed a feed's
+// entries.
+const ActionRefresh = "refresh"
+
+// allPorts is what a blocking feed drops.
+const allPorts = "1:65535"
+
+// batchSize bounds the addresses inserted per statement.
+const batchSize = 1000
+
+// Refresh syncs every enabled feed that is due, checking every interval until
+// ctx is done. A feed is due once its interval has passed or its file has
+// changed since the last refresh.
+func Refresh(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		var feeds []models.Feed
+		if err := db.Where("enabled").Find(&feeds).Error; err != nil {
+			log.Printf("Error in fetching feeds: %v", err)
+		}
+		now := time.Now()
+		for _, feed := range feeds {
+			if !due(feed, now) {
+				continue
+			}
+			if feed, err := Sync(ctx, db, feed, audit.System(Component)); err != nil {
+				log.Printf("Error in refreshing feed %s: %v", feed.Name, err)
+			} else {
+				log.Printf("Refreshed feed %s: %d entries, %d skipped", feed.Name, feed.Entries, feed.Skipped)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func due(feed models.Feed, now time.Time) bool {
+	if feed.RefreshedAt == nil {
+		return true
+	}
+	interval := time.Duration(feed.Interval) * time.Second
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	if now.Sub(*feed.RefreshedAt) >= interval {
+		return true
+	}
+	info, err := os.Stat(feed.Path)
+	return err == nil && info.ModTime().After(*feed.RefreshedAt)
+}
+
+// Sync reads a feed and brings its address group and policy up to date. A
+// disabled feed keeps its group but loses its policy. Errors reading the feed
+// are stored on it, and its previous entries stay in force.
+func Sync(ctx context.Context, db *gorm.DB, feed models.Feed, actor audit.Actor) (models.Feed, error) {
+	var (
+		entries []string
+		skipped int
+	)
+	if feed.Enabled {
+		file, err := os.Open(feed.Path)
+		if err == nil {
+			entries, skipped, err = Parse(file, feed.Format, feed.Column)
+			file.Close()
+		}
+		if err != nil {
+			return feed, fail(db, feed, err)
+		}
+	}
+
+	tx := db.Begin()
+	after, removed, err := apply(tx, feed, entries, skipped, actor)
+	if err != nil {
+		tx.Rollback()
+		return feed, fail(db, feed, err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return feed, err
+	}
+
+	if removed != nil {
+		ips, ports := policies.Resolve(*removed)
+		if err := enforcer.DeleteRule(ctx, *removed, ips, ports); err != nil {
+			return after, err
+		}
+	}
+	return after, nil
+}
+
+// fail records err as the outcome of a refresh and returns it.
+func fail(db *gorm.DB, feed models.Feed, err error) error {
+	if err := db.Model(&feed).Updates(map[string]interface{}{"error": err.Error(), "refreshed_at": time.Now()}).Error; err != nil {
+		log.Printf("Error in recording feed error: %v", err)
+	}
+	return err
+}
+
+// apply writes the entries of an enabled feed to its group and creates,
+// updates or deletes its policy. It returns the feed as saved and, if the
+// policy was deleted, its last state so its rules can be removed.
+func apply(tx *gorm.DB, feed models.Feed, entries []string, skipped int, actor audit.Actor) (models.Feed, *models.Policy, error) {
+	previous := feed
+
+	var group models.AddressGroup
+	if feed.AddressGroupID != nil {
+		err := tx.First(&group, *feed.AddressGroupID).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return feed, nil, fmt.Errorf("error fetching address group: %w", err)
+		}
+	}
+	name := Component + "-" + feed.Name
+	if group.ID == 0 || group.Name != name {
+		group.Name = name
+		group.Description = "Entries of threat-intel feed " + feed.Name
+		group.Managed = Component
+		if err := tx.Save(&group).Error; err != nil {
+			return feed, nil, fmt.Errorf("error in saving address group: %w", err)
+		}
+		feed.AddressGroupID = &group.ID
+		feed.Checksum = ""
+	}
+
+	if feed.Enabled {
+		sum := checksum(entries)
+		if sum != feed.Checksum {
+			if err := tx.Where("address_group_id = ?", group.ID).Delete(&models.GroupAddress{}).Error; err != nil {
+				return feed, nil, fmt.Errorf("error deleting addresses: %w", err)
+			}
+			addresses := make([]models.GroupAddress, len(entries))
+			for i, entry := range entries {
+				addresses[i] = models.GroupAddress{AddressGroupID: group.ID, Address: entry}
+			}
+			if len(addresses) > 0 {
+				if err := tx.CreateInBatches(addresses, batchSize).Error; err != nil {
+					return feed, nil, fmt.Errorf("error in creating addresses: %w", err)
+				}
+			}
+		}
+		feed.Entries = len(entries)
+		feed.Skipped = skipped
+		feed.Checksum = sum
+		now := time.Now()
+		feed.RefreshedAt = &now
+		feed.Error = ""
+	}
+
+	removed, err := applyPolicy(tx, &feed, actor)
+	if err != nil {
+		return feed, nil, err
+	}
+
+	if err := tx.Save(&feed).Error; err != nil {
+		return feed, nil, fmt.Errorf("error in saving feed: %w", err)
+	}
+	// Unchanged refreshes are not audited, so they do not invalidate the
+	// compiled policies either.
+	if feed.Checksum != previous.Checksum || !samePolicy(feed.PolicyID, previous.PolicyID) {
+		if err := audit.Record(tx, actor, ActionRefresh, audit.ObjectFeed, feed.ID, previous, feed); err != nil {
+			return feed, nil, err
+		}
+	}
+	return feed, removed, nil
+}
+
+// applyPolicy makes the feed's policy match it: present for enabled feeds
+// that block, absent otherwise.
+func applyPolicy(tx *gorm.DB, feed *models.Feed, actor audit.Actor) (*models.Policy, error) {
+	var current *models.Policy
+	if feed.PolicyID != nil {
+		policy, err := policies.LoadOne(tx, *feed.PolicyID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("error fetching policy: %w", err)
+		}
+		if err == nil {
+			current = &policy
+		}
+	}
+	req := request(*feed)
+
+	switch {
+	case feed.Enabled && feed.Block && current == nil:
+		if errs := policies.Validate(tx, req, policies.Lockout{Ports: policies.ManagementPorts()}); len(errs) > 0 {
+			return nil, policies.InvalidError(req.Name, errs)
+		}
+		policy, err := policies.Create(tx, req)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Model(&policy).Update("managed", Component).Error; err != nil {
+			return nil, fmt.Errorf("error in marking policy: %w", err)
+		}
+		policy.Managed = Component
+		if err := allProtocols(tx, &policy); err != nil {
+			return nil, err
+		}
+		if err := audit.Record(tx, actor, policies.ActionCreate, audit.ObjectPolicy, policy.ID, nil, policy); err != nil {
+			return nil, err
+		}
+		feed.PolicyID = &policy.ID
+
+	case feed.Enabled && feed.Block:
+		if current.Name == req.Name && string(current.Severity) == req.Severity && blocksAll(*current) {
+			return nil, nil
+		}
+		before, after, err := policies.Update(tx, current.ID, req)
+		if err != nil {
+			return nil, err
+		}
+		if err := allProtocols(tx, &after); err != nil {
+			return nil, err
+		}
+		if err := audit.Record(tx, actor, policies.ActionUpdate, audit.ObjectPolicy, after.ID, before, after); err != nil {
+			return nil, err
+		}
+
+	case current != nil:
+		policy, err := policies.Delete(tx, current.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := audit.Record(tx, actor, policies.ActionDelete, audit.ObjectPolicy, policy.ID, policy, nil); err != nil {
+			return nil, err
+		}
+		feed.PolicyID = nil
+		return &policy, nil
+
+	default:
+		feed.PolicyID = nil
+	}
+	return nil, nil
+}
+
+// Remove deletes a feed together with its group and policy.
+func Remove(ctx context.Context, db *gorm.DB, feed models.Feed, actor audit.Actor) error {
+	feed.Enabled = false
+
+	tx := db.Begin()
+	removed, err := applyPolicy(tx, &feed, actor)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if feed.AddressGroupID != nil {
+		group := models.AddressGroup{Model: gorm.Model{ID: *feed.AddressGroupID}}
+		if err := tx.Where("address_group_id = ?", group.ID).Delete(&models.GroupAddress{}).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error deleting addresses: %w", err)
+		}
+		if err := tx.Delete(&group).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error in deleting address group: %w", err)
+		}
+	}
+	if err := tx.Delete(&feed).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error in deleting feed: %w", err)
+	}
+	if err := audit.Record(tx, actor, policies.ActionDelete, audit.ObjectFeed, feed.ID, feed, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	if removed != nil {
+		ips, ports := policies.Resolve(*removed)
+		return enforcer.DeleteRule(ctx, *removed, ips, ports)
+	}
+	return nil
+}
+
+// allProtocols makes the ports of a feed's policy stand for all traffic, so
+// that its sources are dropped whatever the protocol.
+func allProtocols(tx *gorm.DB, policy *models.Policy) error {
+	if err := tx.Model(&models.Port{}).Where("policy_id = ?", policy.ID).Update("protocol", enforcer.ProtocolAll).Error; err != nil {
+		return fmt.Errorf("error in marking ports: %w", err)
+	}
+	for i := range policy.Ports {
+		policy.Ports[i].Protocol = enforcer.ProtocolAll
+	}
+	return nil
+}
+
+// blocksAll reports whether every port of a policy stands for all traffic.
+func blocksAll(policy models.Policy) bool {
+	for _, port := range policy.Ports {
+		if enforcer.Protocol(port) != enforcer.ProtocolAll {
+			return false
+		}
+	}
+	return true
+}
+
+func request(feed models.Feed) policies.PolicyRequest {
+	severity := feed.Severity
+	if severity == "" {
+		severity = models.SEVERITY_HIGH
+	}
+	req := policies.PolicyRequest{
+		Name:     Component + "-" + feed.Name,
+		Type:     "enforcer",
+		Severity: string(severity),
+		Ports:    []string{allPorts},
+	}
+	if feed.AddressGroupID != nil {
+		req.AddressGroups = []uint{*feed.AddressGroupID}
+	}
+	return req
+}
+
+func checksum(entries []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(entries, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+func samePolicy(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,100 @@
+package feeds
+
+import (
+	"net/netip"
+	"sort"
+
+	"github.com/hanshal101/snapwall/internal/policies"
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
+)
+
+// Index finds the feeds listing an address. Prefixes are kept in a map keyed
+// by the masked prefix, so a lookup costs one map access per prefix length
+// in use rather than one comparison per entry.
+type Index struct {
+	feeds  map[netip.Prefix][]string
+	v4, v6 []int
+}
+
+// NewIndex indexes the entries of feeds, by feed name. Invalid entries are
+// left out.
+func NewIndex(entries map[string][]string) *Index {
+	ix := &Index{feeds: make(map[netip.Prefix][]string)}
+	lengths := make(map[bool]map[int]bool)
+	for name, addresses := range entries {
+		for _, address := range addresses {
+			prefix, err := policies.ParseAddress(address)
+			if err != nil {
+				continue
+			}
+			ix.feeds[prefix] = append(ix.feeds[prefix], name)
+			is4 := prefix.Addr().Is4()
+			if lengths[is4] == nil {
+				lengths[is4] = make(map[int]bool)
+			}
+			lengths[is4][prefix.Bits()] = true
+		}
+	}
+	for bits := range lengths[true] {
+		ix.v4 = append(ix.v4, bits)
+	}
+	for bits := range lengths[false] {
+		ix.v6 = append(ix.v6, bits)
+	}
+	sort.Ints(ix.v4)
+	sort.Ints(ix.v6)
+	return ix
+}
+
+// LoadIndex indexes the entries of the enabled feeds.
+func LoadIndex(db *gorm.DB) (*Index, error) {
+	var feeds []models.Feed
+	if err := db.Where("enabled AND address_group_id IS NOT NULL").Find(&feeds).Error; err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string][]string)
+	for _, feed := range feeds {
+		var addresses []string
+		if err := db.Model(&models.GroupAddress{}).Where("address_group_id = ?", *feed.AddressGroupID).Pluck("address", &addresses).Error; err != nil {
+			return nil, err
+		}
+		entries[feed.Name] = addresses
+	}
+	return NewIndex(entries), nil
+}
+
+// Match returns the names of the feeds listing ip, sorted. A nil Index
+// matches nothing.
+func (ix *Index) Match(ip string) []string {
+	if ix == nil {
+		return nil
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil
+	}
+	addr = addr.Unmap()
+
+	lengths := ix.v6
+	if addr.Is4() {
+		lengths = ix.v4
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, bits := range lengths {
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		for _, name := range ix.feeds[prefix] {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,118 @@
+package feeds
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/hanshal101/snapwall/internal/policies"
+)
+
+const (
+	// FormatPlain is one IP or CIDR per line with "#" comments, as in the
+	// FireHOL lists.
+	FormatPlain = "plain"
+	// FormatSpamhaus is FormatPlain with ";" comments, as in the Spamhaus
+	// DROP lists: "1.10.16.0/20 ; SBL256894".
+	FormatSpamhaus = "spamhaus"
+	// FormatCSV reads the address from one column of each record. Records
+	// without an address in it, such as a header, are skipped.
+	FormatCSV = "csv"
+)
+
+// Formats lists the feed formats Parse reads.
+var Formats = []string{FormatPlain, FormatSpamhaus, FormatCSV}
+
+// maxLine bounds the length of a line in plain and spamhaus feeds.
+const maxLine = 64 * 1024
+
+// Parse reads the addresses of a feed. It returns them deduplicated and
+// sorted, single addresses without a prefix length, along with the number of
+// lines that held no valid address.
+func Parse(r io.Reader, format string, column int) ([]string, int, error) {
+	var (
+		prefixes = make(map[netip.Prefix]bool)
+		skipped  int
+	)
+	add := func(entry string) {
+		prefix, err := policies.ParseAddress(entry)
+		if err != nil {
+			skipped++
+			return
+		}
+		prefixes[prefix] = true
+	}
+
+	switch format {
+	case FormatPlain, FormatSpamhaus:
+		comments := "#"
+		if format == FormatSpamhaus {
+			comments = "#;"
+		}
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 4096), maxLine)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if i := strings.IndexAny(line, comments); i >= 0 {
+				line = line[:i]
+			}
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			add(fields[0])
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, skipped, fmt.Errorf("error reading feed: %w", err)
+		}
+
+	case FormatCSV:
+		reader := csv.NewReader(r)
+		reader.Comment = '#'
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		for {
+			record, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, skipped, fmt.Errorf("error reading feed: %w", err)
+			}
+			if column >= len(record) {
+				skipped++
+				continue
+			}
+			add(strings.TrimSpace(record[column]))
+		}
+
+	default:
+		return nil, 0, fmt.Errorf("unknown feed format %q", format)
+	}
+
+	entries := make([]netip.Prefix, 0, len(prefixes))
+	for prefix := range prefixes {
+		entries = append(entries, prefix)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if c := entries[i].Addr().Compare(entries[j].Addr()); c != 0 {
+			return c < 0
+		}
+		return entries[i].Bits() < entries[j].Bits()
+	})
+
+	addresses := make([]string, len(entries))
+	for i, prefix := range entries {
+		if prefix.IsSingleIP() {
+			addresses[i] = prefix.Addr().String()
+		} else {
+			addresses[i] = prefix.String()
+		}
+	}
+	return addresses, skipped, nil
+}
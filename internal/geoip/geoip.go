@@ -0,0 +1,165 @@
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// Location is what is known about where an address is: the ISO code of its
+// country, its city, and the autonomous system announcing it.
+type Location struct {
+	Country string `json:"country,omitempty"`
+	City    string `json:"city,omitempty"`
+	ASN     uint32 `json:"asn,omitempty"`
+	Org     string `json:"org,omitempty"`
+}
+
+// Resolver enriches addresses from a GeoIP2/GeoLite2 City or Country
+// database and an ASN database. Either may be missing.
+type Resolver struct {
+	city *Reader
+	asn  *Reader
+}
+
+// NewResolver opens the databases at the given paths; an empty path leaves
+// that database out.
+func NewResolver(cityPath, asnPath string) (*Resolver, error) {
+	r := &Resolver{}
+	var err error
+	if cityPath != "" {
+		if r.city, err = Open(cityPath); err != nil {
+			return nil, err
+		}
+	}
+	if asnPath != "" {
+		if r.asn, err = Open(asnPath); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Lookup returns the location of ip. Unknown and private addresses have an
+// empty location, as does every address for a nil Resolver.
+func (r *Resolver) Lookup(ip string) Location {
+	var location Location
+	if r == nil {
+		return location
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return location
+	}
+
+	if record := r.lookup(r.city, addr); record != nil {
+		location.Country = country(record)
+		location.City, _ = path(record, "city", "names", "en").(string)
+	}
+	if record := r.lookup(r.asn, addr); record != nil {
+		if asn, ok := record["autonomous_system_number"].(uint64); ok {
+			location.ASN = uint32(asn)
+		}
+		location.Org, _ = record["autonomous_system_organization"].(string)
+	}
+	return location
+}
+
+func (r *Resolver) lookup(db *Reader, addr netip.Addr) map[string]interface{} {
+	if db == nil {
+		return nil
+	}
+	value, ok, err := db.Lookup(addr)
+	if err != nil {
+		log.Printf("Error in looking up %s in %s: %v", addr, db.Metadata.DatabaseType, err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	record, _ := value.(map[string]interface{})
+	return record
+}
+
+// Networks returns the networks located in any of countries, given as ISO
+// codes, or announced by any of asns.
+func (r *Resolver) Networks(countries []string, asns []uint32) ([]netip.Prefix, error) {
+	var networks []netip.Prefix
+	if len(countries) > 0 {
+		if r == nil || r.city == nil {
+			return nil, errors.New("no GeoIP country database configured")
+		}
+		wanted := make(map[string]bool)
+		for _, code := range countries {
+			wanted[strings.ToUpper(code)] = true
+		}
+		found, err := r.city.Networks(func(value interface{}) bool {
+			return wanted[country(value)]
+		})
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, found...)
+	}
+	if len(asns) > 0 {
+		if r == nil || r.asn == nil {
+			return nil, errors.New("no GeoIP ASN database configured")
+		}
+		wanted := make(map[uint64]bool)
+		for _, asn := range asns {
+			wanted[uint64(asn)] = true
+		}
+		found, err := r.asn.Networks(func(value interface{}) bool {
+			asn, _ := path(value, "autonomous_system_number").(uint64)
+			return wanted[asn]
+		})
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, found...)
+	}
+	return networks, nil
+}
+
+// Version identifies the state of database files by their size and
+// modification time, so a replaced database can be noticed. Empty paths are
+// skipped.
+func Version(paths ...string) (string, error) {
+	var parts []string
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, fmt.Sprintf("%s:%d:%d", p, info.Size(), info.ModTime().UnixNano()))
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// country is the ISO code of the country of a City or Country record,
+// falling back to the country the network is registered in.
+func country(record interface{}) string {
+	code, _ := path(record, "country", "iso_code").(string)
+	if code == "" {
+		code, _ = path(record, "registered_country", "iso_code").(string)
+	}
+	return code
+}
+
+// path walks nested maps along keys.
+func path(value interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
@@ -0,0 +1,443 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+)
+
+// metadataMarker precedes the metadata map at the end of a MaxMind DB file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSeparator is the gap between the search tree and the data section.
+const dataSeparator = 16
+
+// maxDepth bounds the nesting of decoded values, against corrupt files.
+const maxDepth = 32
+
+// Metadata describes a MaxMind DB file.
+type Metadata struct {
+	DatabaseType string
+	Description  string
+	IPVersion    uint64
+	NodeCount    uint64
+	RecordSize   uint64
+	BuildEpoch   uint64
+}
+
+// Reader looks up addresses in a MaxMind DB file, the format of the GeoIP2
+// and GeoLite2 databases. The file is read into memory once; values are
+// decoded on every lookup into maps, slices, strings, numbers and bools.
+type Reader struct {
+	Metadata Metadata
+
+	buf      []byte
+	tree     []byte
+	data     []byte
+	nodeSize int
+	// ipv4Start is the node IPv4 lookups start from in an IPv6 tree, where
+	// IPv4 addresses live under ::/96.
+	ipv4Start uint64
+}
+
+// Open reads a MaxMind DB file.
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(buf)
+}
+
+// New parses a MaxMind DB held in buf.
+func New(buf []byte) (*Reader, error) {
+	start := bytes.LastIndex(buf, metadataMarker)
+	if start < 0 {
+		return nil, errors.New("invalid MaxMind DB: metadata not found")
+	}
+	d := decoder{buf: buf[start+len(metadataMarker):]}
+	value, _, err := d.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MaxMind DB metadata: %w", err)
+	}
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid MaxMind DB metadata: not a map")
+	}
+
+	r := &Reader{buf: buf}
+	r.Metadata.DatabaseType, _ = fields["database_type"].(string)
+	r.Metadata.IPVersion, _ = fields["ip_version"].(uint64)
+	r.Metadata.NodeCount, _ = fields["node_count"].(uint64)
+	r.Metadata.RecordSize, _ = fields["record_size"].(uint64)
+	r.Metadata.BuildEpoch, _ = fields["build_epoch"].(uint64)
+	if descriptions, ok := fields["description"].(map[string]interface{}); ok {
+		r.Metadata.Description, _ = descriptions["en"].(string)
+	}
+
+	switch r.Metadata.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("invalid MaxMind DB: unsupported record size %d", r.Metadata.RecordSize)
+	}
+	r.nodeSize = int(r.Metadata.RecordSize) / 4
+	// Checked before multiplying, so a corrupt count cannot overflow.
+	if r.Metadata.NodeCount > uint64(start/r.nodeSize) {
+		return nil, errors.New("invalid MaxMind DB: search tree exceeds file")
+	}
+	treeSize := int(r.Metadata.NodeCount) * r.nodeSize
+	if treeSize+dataSeparator > start {
+		return nil, errors.New("invalid MaxMind DB: search tree exceeds file")
+	}
+	r.tree = buf[:treeSize]
+	r.data = buf[treeSize+dataSeparator : start]
+
+	if r.Metadata.IPVersion == 6 {
+		node := uint64(0)
+		for i := 0; i < 96 && node < r.Metadata.NodeCount; i++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// Lookup returns the value stored for addr, and whether there is one.
+func (r *Reader) Lookup(addr netip.Addr) (interface{}, bool, error) {
+	addr = addr.Unmap()
+	node := uint64(0)
+	if addr.Is4() {
+		if r.Metadata.IPVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else if r.Metadata.IPVersion != 6 {
+		return nil, false, nil
+	}
+
+	bytes := addr.AsSlice()
+	count := r.Metadata.NodeCount
+	for bit := 0; bit < len(bytes)*8 && node < count; bit++ {
+		node = r.record(node, int(bytes[bit/8]>>(7-bit%8))&1)
+	}
+	if node == count {
+		return nil, false, nil
+	}
+	if node < count {
+		return nil, false, errors.New("invalid MaxMind DB: search tree deeper than address")
+	}
+
+	offset := node - count - dataSeparator
+	if offset >= uint64(len(r.data)) {
+		return nil, false, errors.New("invalid MaxMind DB: record points outside data section")
+	}
+	d := decoder{buf: r.data}
+	value, _, err := d.decode(int(offset), 0)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// record reads the left (0) or right (1) record of a node.
+func (r *Reader) record(node uint64, side int) uint64 {
+	b := r.tree[int(node)*r.nodeSize:]
+	switch r.Metadata.RecordSize {
+	case 24:
+		b = b[side*3:]
+		return uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2])
+	case 28:
+		if side == 0 {
+			return uint64(b[3]&0xf0)<<20 | uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2])
+		}
+		return uint64(b[3]&0x0f)<<24 | uint64(b[4])<<16 | uint64(b[5])<<8 | uint64(b[6])
+	default:
+		return uint64(binary.BigEndian.Uint32(b[side*4:]))
+	}
+}
+
+// Data section types.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+type decoder struct {
+	buf []byte
+}
+
+var errTruncated = errors.New("invalid MaxMind DB: truncated data")
+
+// decode decodes the value at offset and returns it with the offset after it.
+func (d *decoder) decode(offset, depth int) (interface{}, int, error) {
+	if depth > maxDepth {
+		return nil, 0, errors.New("invalid MaxMind DB: data nested too deeply")
+	}
+	if offset >= len(d.buf) {
+		return nil, 0, errTruncated
+	}
+	ctrl := d.buf[offset]
+	offset++
+	kind := int(ctrl >> 5)
+
+	if kind == typePointer {
+		pointer, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(pointer, depth+1)
+		return value, next, err
+	}
+
+	if kind == typeExtended {
+		if offset >= len(d.buf) {
+			return nil, 0, errTruncated
+		}
+		kind = 7 + int(d.buf[offset])
+		offset++
+	}
+
+	size := int(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > len(d.buf) {
+			return nil, 0, errTruncated
+		}
+		extra := 0
+		for _, b := range d.buf[offset : offset+n] {
+			extra = extra<<8 | int(b)
+		}
+		offset += n
+		switch size {
+		case 29:
+			size = 29 + extra
+		case 30:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	// Every entry takes at least a byte, so a corrupt size is caught before
+	// it is allocated for.
+	if (kind == typeMap || kind == typeArray) && size > len(d.buf)-offset {
+		return nil, 0, errTruncated
+	}
+
+	switch kind {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("invalid MaxMind DB: map key is not a string")
+			}
+			value, next, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[name] = value
+			offset = next
+		}
+		return m, offset, nil
+
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			value, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+
+	case typeBool:
+		return size != 0, offset, nil
+
+	case typeEndMarker, typeContainer:
+		return nil, offset, nil
+	}
+
+	if offset+size > len(d.buf) {
+		return nil, 0, errTruncated
+	}
+	raw := d.buf[offset : offset+size]
+	offset += size
+
+	switch kind {
+	case typeString:
+		return string(raw), offset, nil
+	case typeBytes, typeUint128:
+		return append([]byte(nil), raw...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid MaxMind DB: double of wrong size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid MaxMind DB: float of wrong size")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, errors.New("invalid MaxMind DB: integer too large")
+		}
+		var n uint64
+		for _, b := range raw {
+			n = n<<8 | uint64(b)
+		}
+		return n, offset, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, errors.New("invalid MaxMind DB: integer too large")
+		}
+		var n uint32
+		for _, b := range raw {
+			n = n<<8 | uint32(b)
+		}
+		return int64(int32(n)), offset, nil
+	}
+	return nil, 0, fmt.Errorf("invalid MaxMind DB: unknown data type %d", kind)
+}
+
+// pointer decodes a pointer whose control byte is ctrl and returns the
+// offset it points to with the offset after it.
+func (d *decoder) pointer(ctrl byte, offset int) (int, int, error) {
+	n := int(ctrl>>3)&0x3 + 1
+	if offset+n > len(d.buf) {
+		return 0, 0, errTruncated
+	}
+	value := 0
+	if n < 4 {
+		value = int(ctrl & 0x7)
+	}
+	for _, b := range d.buf[offset : offset+n] {
+		value = value<<8 | int(b)
+	}
+	switch n {
+	case 2:
+		value += 2048
+	case 3:
+		value += 526336
+	}
+	return value, offset + n, nil
+}
+
+// Networks returns the networks whose value satisfies match. Adjacent
+// networks that both match are merged, so a country comes out as few
+// prefixes as the database allows. IPv4 networks of an IPv6 database are
+// returned as IPv4 prefixes, once: the aliases of the IPv4 subtree, such as
+// ::ffff:0:0/96, are skipped.
+func (r *Reader) Networks(match func(value interface{}) bool) ([]netip.Prefix, error) {
+	var (
+		count    = r.Metadata.NodeCount
+		maxBits  = 32
+		path     [16]byte
+		networks []netip.Prefix
+		memo     = make(map[uint64]bool)
+		err      error
+	)
+	if r.Metadata.IPVersion == 6 {
+		maxBits = 128
+	}
+
+	setBit := func(bit int, on bool) {
+		if on {
+			path[bit/8] |= 0x80 >> (bit % 8)
+		} else {
+			path[bit/8] &^= 0x80 >> (bit % 8)
+		}
+	}
+	emit := func(bits int) {
+		if maxBits == 32 {
+			networks = append(networks, netip.PrefixFrom(netip.AddrFrom4([4]byte(path[:4])), bits))
+			return
+		}
+		addr := netip.AddrFrom16(path)
+		if bits >= 96 && [12]byte(path[:12]) == [12]byte{} {
+			networks = append(networks, netip.PrefixFrom(netip.AddrFrom4([4]byte(path[12:])), bits-96))
+			return
+		}
+		networks = append(networks, netip.PrefixFrom(addr, bits))
+	}
+
+	// visit reports whether every address under record matches. zero tells
+	// whether the path to record is all zero bits.
+	var visit func(record uint64, depth int, zero bool) bool
+	visit = func(record uint64, depth int, zero bool) bool {
+		if err != nil || record == count {
+			return false
+		}
+		if record > count {
+			if matched, ok := memo[record]; ok {
+				return matched
+			}
+			offset := record - count - dataSeparator
+			if offset >= uint64(len(r.data)) {
+				err = errors.New("invalid MaxMind DB: record points outside data section")
+				return false
+			}
+			d := decoder{buf: r.data}
+			value, _, decodeErr := d.decode(int(offset), 0)
+			if decodeErr != nil {
+				err = decodeErr
+				return false
+			}
+			memo[record] = match(value)
+			return memo[record]
+		}
+		if depth >= maxBits {
+			err = errors.New("invalid MaxMind DB: search tree deeper than address")
+			return false
+		}
+		if maxBits == 128 && record == r.ipv4Start && !(zero && depth == 96) {
+			return false
+		}
+
+		left := visit(r.record(record, 0), depth+1, zero)
+		setBit(depth, true)
+		right := visit(r.record(record, 1), depth+1, false)
+		setBit(depth, false)
+		if left && right {
+			return true
+		}
+		if left {
+			emit(depth + 1)
+		}
+		if right {
+			setBit(depth, true)
+			emit(depth + 1)
+			setBit(depth, false)
+		}
+		return false
+	}
+
+	if visit(0, 0, true) {
+		emit(0)
+	}
+	return networks, err
+}
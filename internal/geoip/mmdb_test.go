@@ -0,0 +1,344 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net/netip"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// pointer is encoded as a pointer into the data section, below 2048.
+type pointer int
+
+// encode writes v in the MaxMind DB data format.
+func encode(v interface{}) []byte {
+	control := func(kind, size int) []byte {
+		var out []byte
+		var extra []byte
+		switch {
+		case size < 29:
+		case size < 285:
+			extra = []byte{byte(size - 29)}
+			size = 29
+		case size < 65821:
+			n := size - 285
+			extra = []byte{byte(n >> 8), byte(n)}
+			size = 30
+		default:
+			n := size - 65821
+			extra = []byte{byte(n >> 16), byte(n >> 8), byte(n)}
+			size = 31
+		}
+		if kind > 7 {
+			out = append(out, byte(size), byte(kind-7))
+		} else {
+			out = append(out, byte(kind<<5|size))
+		}
+		return append(out, extra...)
+	}
+	trim := func(b []byte) []byte {
+		for len(b) > 0 && b[0] == 0 {
+			b = b[1:]
+		}
+		return b
+	}
+
+	switch v := v.(type) {
+	case pointer:
+		return []byte{byte(typePointer<<5 | int(v)>>8&0x7), byte(v)}
+	case string:
+		return append(control(typeString, len(v)), v...)
+	case uint16:
+		b := trim([]byte{byte(v >> 8), byte(v)})
+		return append(control(typeUint16, len(b)), b...)
+	case uint32:
+		b := trim(binary.BigEndian.AppendUint32(nil, v))
+		return append(control(typeUint32, len(b)), b...)
+	case uint64:
+		b := trim(binary.BigEndian.AppendUint64(nil, v))
+		return append(control(typeUint64, len(b)), b...)
+	case int32:
+		b := binary.BigEndian.AppendUint32(nil, uint32(v))
+		return append(control(typeInt32, 4), b...)
+	case float64:
+		return append(control(typeDouble, 8), binary.BigEndian.AppendUint64(nil, math.Float64bits(v))...)
+	case float32:
+		return append(control(typeFloat, 4), binary.BigEndian.AppendUint32(nil, math.Float32bits(v))...)
+	case bool:
+		if v {
+			return control(typeBool, 1)
+		}
+		return control(typeBool, 0)
+	case []byte:
+		return append(control(typeBytes, len(v)), v...)
+	case []interface{}:
+		out := control(typeArray, len(v))
+		for _, item := range v {
+			out = append(out, encode(item)...)
+		}
+		return out
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		out := control(typeMap, len(v))
+		for _, key := range keys {
+			out = append(out, encode(key)...)
+			out = append(out, encode(v[key])...)
+		}
+		return out
+	}
+	panic("cannot encode " + reflect.TypeOf(v).String())
+}
+
+// treeNode is a node of the search tree being built; each side holds a
+// child, a data offset, or neither.
+type treeNode struct {
+	child [2]*treeNode
+	data  [2]int
+	index int
+}
+
+// buildDB writes a MaxMind DB with 24 bit records that maps each network to
+// its value. IPv4 networks go under ::/96 in an IPv6 database.
+func buildDB(ipVersion int, networks map[string]interface{}) []byte {
+	var data []byte
+	root := &treeNode{data: [2]int{-1, -1}}
+	keys := make([]string, 0, len(networks))
+	for key := range networks {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		offset := len(data)
+		data = append(data, encode(networks[key])...)
+
+		prefix := netip.MustParsePrefix(key)
+		bits, addr := prefix.Bits(), prefix.Addr().AsSlice()
+		if ipVersion == 6 && prefix.Addr().Is4() {
+			bits += 96
+			addr = append(make([]byte, 12), addr...)
+		}
+		node := root
+		for bit := 0; bit < bits; bit++ {
+			side := int(addr[bit/8]>>(7-bit%8)) & 1
+			if bit == bits-1 {
+				node.data[side] = offset
+				break
+			}
+			if node.child[side] == nil {
+				node.child[side] = &treeNode{data: [2]int{-1, -1}}
+			}
+			node = node.child[side]
+		}
+	}
+
+	var nodes []*treeNode
+	var number func(*treeNode)
+	number = func(n *treeNode) {
+		n.index = len(nodes)
+		nodes = append(nodes, n)
+		for _, child := range n.child {
+			if child != nil {
+				number(child)
+			}
+		}
+	}
+	number(root)
+
+	count := len(nodes)
+	var out []byte
+	for _, n := range nodes {
+		for side := 0; side < 2; side++ {
+			record := count
+			if n.child[side] != nil {
+				record = n.child[side].index
+			} else if n.data[side] >= 0 {
+				record = count + dataSeparator + n.data[side]
+			}
+			out = append(out, byte(record>>16), byte(record>>8), byte(record))
+		}
+	}
+	out = append(out, make([]byte, dataSeparator)...)
+	out = append(out, data...)
+	out = append(out, metadataMarker...)
+	return append(out, encode(map[string]interface{}{
+		"database_type": "Test-City",
+		"description":   map[string]interface{}{"en": "test database"},
+		"ip_version":    uint16(ipVersion),
+		"node_count":    uint32(count),
+		"record_size":   uint16(24),
+		"build_epoch":   uint64(1700000000),
+	})...)
+}
+
+func city(code string) map[string]interface{} {
+	return map[string]interface{}{"country": map[string]interface{}{"iso_code": code}}
+}
+
+func TestReaderLookup(t *testing.T) {
+	for _, ipVersion := range []int{4, 6} {
+		networks := map[string]interface{}{
+			"10.0.0.0/8":     city("NL"),
+			"192.0.2.128/25": city("DE"),
+		}
+		if ipVersion == 6 {
+			networks["2001:db8::/32"] = city("FR")
+		}
+		r, err := New(buildDB(ipVersion, networks))
+		if err != nil {
+			t.Fatalf("IPv%d: New: %v", ipVersion, err)
+		}
+		if r.Metadata.DatabaseType != "Test-City" || r.Metadata.Description != "test database" || r.Metadata.BuildEpoch != 1700000000 {
+			t.Errorf("IPv%d: metadata = %+v", ipVersion, r.Metadata)
+		}
+
+		tests := []struct {
+			addr    string
+			country string
+		}{
+			{"10.1.2.3", "NL"},
+			{"::ffff:10.1.2.3", "NL"},
+			{"192.0.2.200", "DE"},
+			{"192.0.2.100", ""},
+			{"203.0.113.1", ""},
+		}
+		if ipVersion == 6 {
+			tests = append(tests, struct {
+				addr    string
+				country string
+			}{"2001:db8::1", "FR"})
+		}
+		for _, test := range tests {
+			value, ok, err := r.Lookup(netip.MustParseAddr(test.addr))
+			if err != nil {
+				t.Errorf("IPv%d: Lookup(%s): %v", ipVersion, test.addr, err)
+				continue
+			}
+			if got := country(value); ok != (test.country != "") || got != test.country {
+				t.Errorf("IPv%d: Lookup(%s) = %q, %v; want %q", ipVersion, test.addr, got, ok, test.country)
+			}
+		}
+	}
+}
+
+func TestReaderNetworks(t *testing.T) {
+	r, err := New(buildDB(6, map[string]interface{}{
+		"10.0.0.0/9":      city("NL"),
+		"10.128.0.0/9":    city("NL"),
+		"192.0.2.0/24":    city("DE"),
+		"2001:db8::/32":   city("NL"),
+		"198.51.100.0/24": city("NL"),
+	}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	networks, err := r.Networks(func(value interface{}) bool { return country(value) == "NL" })
+	if err != nil {
+		t.Fatalf("Networks: %v", err)
+	}
+	var got []string
+	for _, network := range networks {
+		got = append(got, network.String())
+	}
+	sort.Strings(got)
+	want := []string{"10.0.0.0/8", "198.51.100.0/24", "2001:db8::/32"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Networks = %v, want %v", got, want)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{"string", "Amsterdam", "Amsterdam"},
+		{"long string", long, long},
+		{"uint16", uint16(443), uint64(443)},
+		{"uint32", uint32(64496), uint64(64496)},
+		{"uint64", uint64(1) << 40, uint64(1) << 40},
+		{"zero", uint32(0), uint64(0)},
+		{"int32", int32(-5), int64(-5)},
+		{"double", 52.37, 52.37},
+		{"float", float32(0.5), 0.5},
+		{"true", true, true},
+		{"false", false, false},
+		{"bytes", []byte{1, 2}, []byte{1, 2}},
+		{"array", []interface{}{"a", uint16(1)}, []interface{}{"a", uint64(1)}},
+		{"map", map[string]interface{}{"en": "Netherlands"}, map[string]interface{}{"en": "Netherlands"}},
+	}
+	for _, test := range tests {
+		d := decoder{buf: encode(test.value)}
+		got, next, err := d.decode(0, 0)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) || next != len(d.buf) {
+			t.Errorf("%s: decoded %#v ending at %d, want %#v ending at %d", test.name, got, next, test.want, len(d.buf))
+		}
+	}
+
+	// A pointer is followed, and decoding goes on after the pointer.
+	buf := encode("NL")
+	start := len(buf)
+	buf = append(buf, encode(map[string]interface{}{"iso_code": pointer(0)})...)
+	d := decoder{buf: buf}
+	got, next, err := d.decode(start, 0)
+	if want := map[string]interface{}{"iso_code": "NL"}; err != nil || !reflect.DeepEqual(got, want) || next != len(buf) {
+		t.Errorf("pointer: decoded %#v ending at %d, %v; want %#v ending at %d", got, next, err, want, len(buf))
+	}
+}
+
+func TestNewRejectsCorruptFiles(t *testing.T) {
+	valid := buildDB(4, map[string]interface{}{"10.0.0.0/8": city("NL")})
+	metadata := func(fields map[string]interface{}) []byte {
+		return append(append(make([]byte, 64), metadataMarker...), encode(fields)...)
+	}
+
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{"empty", nil},
+		{"no metadata", valid[:bytes.LastIndex(valid, metadataMarker)]},
+		{"truncated metadata", valid[:len(valid)-3]},
+		{"record size", metadata(map[string]interface{}{"node_count": uint32(1), "record_size": uint16(16), "ip_version": uint16(4)})},
+		{"tree beyond file", metadata(map[string]interface{}{"node_count": uint32(100), "record_size": uint16(24), "ip_version": uint16(4)})},
+		{"node count overflowing", metadata(map[string]interface{}{"node_count": uint64(math.MaxUint64 / 2), "record_size": uint16(32), "ip_version": uint16(4)})},
+	}
+	for _, test := range tests {
+		if _, err := New(test.buf); err == nil {
+			t.Errorf("%s: New accepted it", test.name)
+		}
+	}
+}
+
+func TestDecodeRejectsCorruptData(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{"truncated string", encode("Amsterdam")[:4]},
+		{"huge map", []byte{typeMap<<5 | 31, 0xff, 0xff, 0xff}},
+		{"huge array", []byte{31, typeArray - 7, 0xff, 0xff, 0xff}},
+		{"pointer loop", []byte{typePointer<<5 | 0, 0}},
+		{"pointer outside", []byte{typePointer<<5 | 0, 0xff}},
+		{"unknown type", []byte{0, 0xf0}},
+	}
+	for _, test := range tests {
+		d := decoder{buf: test.buf}
+		if _, _, err := d.decode(0, 0); err == nil {
+			t.Errorf("%s: decoded it", test.name)
+		}
+	}
+}
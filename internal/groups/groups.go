@@ -0,0 +1,403 @@
+package groups
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanshal101/snapwall/database/psql"
+	"github.com/hanshal101/snapwall/internal/audit"
+	"github.com/hanshal101/snapwall/internal/policies"
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm/clause"
+)
+
+type AddressGroupRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Addresses   []string `json:"addresses"`
+}
+
+type ServiceRequest struct {
+	Port     string `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+type ServiceGroupRequest struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Services    []ServiceRequest `json:"services"`
+}
+
+const (
+	addressJoinTable = "policy_address_groups"
+	addressJoinKey   = "address_group_id"
+	serviceJoinTable = "policy_service_groups"
+	serviceJoinKey   = "service_group_id"
+)
+
+// ADDRESS Groups
+
+func GetAddressGroups(c *gin.Context) {
+	var groups []models.AddressGroup
+	if err := psql.DB.Preload("Addresses").Find(&groups).Error; err != nil {
+		log.Printf("Error in fetching address groups: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching address groups"})
+		return
+	}
+	c.JSON(http.StatusOK, groups)
+}
+
+func CreateAddressGroup(c *gin.Context) {
+	var req AddressGroupRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Printf("Error in binding address group: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error in binding address group"})
+		return
+	}
+
+	if errs := ValidateAddressGroup(req); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address group", "errors": errs})
+		return
+	}
+
+	group := models.AddressGroup{
+		Name:        req.Name,
+		Description: req.Description,
+	}
+	for _, address := range req.Addresses {
+		group.Addresses = append(group.Addresses, models.GroupAddress{Address: address})
+	}
+
+	tx := psql.DB.Begin()
+	if err := tx.Create(&group).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error in creating address group: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in creating address group"})
+		return
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "create", audit.ObjectAddressGroup, group.ID, nil, group); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing address group: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing address group"})
+		return
+	}
+	tx.Commit()
+	c.JSON(http.StatusOK, group)
+}
+
+func UpdateAddressGroup(c *gin.Context) {
+	var req AddressGroupRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Printf("Error in binding address group: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error in binding address group"})
+		return
+	}
+
+	if errs := ValidateAddressGroup(req); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address group", "errors": errs})
+		return
+	}
+
+	var group models.AddressGroup
+	if err := psql.DB.Preload("Addresses").First(&group, c.Param("groupID")).Error; err != nil {
+		log.Printf("Error fetching address group: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Address group not found"})
+		return
+	}
+	if group.Managed != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Address group is managed by " + group.Managed})
+		return
+	}
+
+	before, err := policies.DependentPolicies(psql.DB, addressJoinTable, addressJoinKey, group.ID)
+	if err != nil {
+		log.Printf("Error fetching dependent policies: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching dependent policies"})
+		return
+	}
+
+	tx := psql.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			log.Printf("Transaction rolled back due to panic: %v", r)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+	}()
+
+	previous := group
+	group.Name = req.Name
+	group.Description = req.Description
+	group.Addresses = nil
+	if err := tx.Omit(clause.Associations).Save(&group).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error saving address group: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving address group"})
+		return
+	}
+
+	if err := tx.Where("address_group_id = ?", group.ID).Delete(&models.GroupAddress{}).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error deleting addresses: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting addresses"})
+		return
+	}
+
+	for _, address := range req.Addresses {
+		entry := models.GroupAddress{AddressGroupID: group.ID, Address: address}
+		if err := tx.Create(&entry).Error; err != nil {
+			tx.Rollback()
+			log.Printf("Error in creating addresses: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in creating addresses"})
+			return
+		}
+		group.Addresses = append(group.Addresses, entry)
+	}
+
+	if err := audit.Record(tx, audit.ActorFrom(c), "update", audit.ObjectAddressGroup, group.ID, previous, group); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing address group: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing address group"})
+		return
+	}
+
+	tx.Commit()
+
+	reenforceDependents(before, addressJoinTable, addressJoinKey, group.ID)
+	c.JSON(http.StatusOK, gin.H{"success": "Address Group Updated Successfully"})
+}
+
+func DeleteAddressGroup(c *gin.Context) {
+	deleteGroup(c, &models.AddressGroup{}, audit.ObjectAddressGroup, addressJoinTable, addressJoinKey)
+}
+
+// SERVICE Groups
+
+func GetServiceGroups(c *gin.Context) {
+	var groups []models.ServiceGroup
+	if err := psql.DB.Preload("Services").Find(&groups).Error; err != nil {
+		log.Printf("Error in fetching service groups: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching service groups"})
+		return
+	}
+	c.JSON(http.StatusOK, groups)
+}
+
+func CreateServiceGroup(c *gin.Context) {
+	var req ServiceGroupRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Printf("Error in binding service group: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error in binding service group"})
+		return
+	}
+
+	if errs := ValidateServiceGroup(req); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service group", "errors": errs})
+		return
+	}
+
+	group := models.ServiceGroup{
+		Name:        req.Name,
+		Description: req.Description,
+	}
+	for _, service := range req.Services {
+		group.Services = append(group.Services, models.GroupService{Port: service.Port, Protocol: service.Protocol})
+	}
+
+	tx := psql.DB.Begin()
+	if err := tx.Create(&group).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error in creating service group: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in creating service group"})
+		return
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "create", audit.ObjectServiceGroup, group.ID, nil, group); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing service group: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing service group"})
+		return
+	}
+	tx.Commit()
+	c.JSON(http.StatusOK, group)
+}
+
+func UpdateServiceGroup(c *gin.Context) {
+	var req ServiceGroupRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Printf("Error in binding service group: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error in binding service group"})
+		return
+	}
+
+	if errs := ValidateServiceGroup(req); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service group", "errors": errs})
+		return
+	}
+
+	var group models.ServiceGroup
+	if err := psql.DB.Preload("Services").First(&group, c.Param("groupID")).Error; err != nil {
+		log.Printf("Error fetching service group: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service group not found"})
+		return
+	}
+
+	before, err := policies.DependentPolicies(psql.DB, serviceJoinTable, serviceJoinKey, group.ID)
+	if err != nil {
+		log.Printf("Error fetching dependent policies: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching dependent policies"})
+		return
+	}
+
+	tx := psql.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			log.Printf("Transaction rolled back due to panic: %v", r)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+	}()
+
+	previous := group
+	group.Name = req.Name
+	group.Description = req.Description
+	group.Services = nil
+	if err := tx.Omit(clause.Associations).Save(&group).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error saving service group: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving service group"})
+		return
+	}
+
+	if err := tx.Where("service_group_id = ?", group.ID).Delete(&models.GroupService{}).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error deleting services: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting services"})
+		return
+	}
+
+	for _, service := range req.Services {
+		entry := models.GroupService{ServiceGroupID: group.ID, Port: service.Port, Protocol: service.Protocol}
+		if err := tx.Create(&entry).Error; err != nil {
+			tx.Rollback()
+			log.Printf("Error in creating services: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in creating services"})
+			return
+		}
+		group.Services = append(group.Services, entry)
+	}
+
+	if err := audit.Record(tx, audit.ActorFrom(c), "update", audit.ObjectServiceGroup, group.ID, previous, group); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing service group: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing service group"})
+		return
+	}
+
+	tx.Commit()
+
+	reenforceDependents(before, serviceJoinTable, serviceJoinKey, group.ID)
+	c.JSON(http.StatusOK, gin.H{"success": "Service Group Updated Successfully"})
+}
+
+func DeleteServiceGroup(c *gin.Context) {
+	deleteGroup(c, &models.ServiceGroup{}, audit.ObjectServiceGroup, serviceJoinTable, serviceJoinKey)
+}
+
+// ValidateAddressGroup checks an address group request.
+func ValidateAddressGroup(req AddressGroupRequest) []policies.FieldError {
+	var errs []policies.FieldError
+	if req.Name == "" {
+		errs = append(errs, policies.FieldError{Field: "name", Message: "is required"})
+	}
+	for i, address := range req.Addresses {
+		if _, err := policies.ParseAddress(address); err != nil {
+			errs = append(errs, policies.FieldError{Field: fmt.Sprintf("addresses[%d]", i), Message: err.Error()})
+		}
+	}
+	return errs
+}
+
+// ValidateServiceGroup checks a service group request.
+func ValidateServiceGroup(req ServiceGroupRequest) []policies.FieldError {
+	var errs []policies.FieldError
+	if req.Name == "" {
+		errs = append(errs, policies.FieldError{Field: "name", Message: "is required"})
+	}
+	for i, service := range req.Services {
+		if _, _, err := policies.ParsePort(service.Port); err != nil {
+			errs = append(errs, policies.FieldError{Field: fmt.Sprintf("services[%d].port", i), Message: err.Error()})
+		}
+		if !policies.ValidProtocol(service.Protocol) {
+			errs = append(errs, policies.FieldError{Field: fmt.Sprintf("services[%d].protocol", i), Message: "must be tcp or udp"})
+		}
+	}
+	return errs
+}
+
+// deleteGroup refuses to delete a group that is still referenced by a policy,
+// so that removing a group never silently drops enforcement.
+func deleteGroup(c *gin.Context, group interface{}, objectType, joinTable, joinKey string) {
+	id := c.Param("groupID")
+
+	if err := psql.DB.Preload(clause.Associations).First(group, id).Error; err != nil {
+		log.Printf("Error fetching group: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		return
+	}
+	if g, ok := group.(*models.AddressGroup); ok && g.Managed != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Group is managed by " + g.Managed})
+		return
+	}
+
+	var dependents []uint
+	if err := psql.DB.Model(&models.Policy{}).
+		Joins("JOIN "+joinTable+" ON "+joinTable+".policy_id = policies.id").
+		Where(joinTable+"."+joinKey+" = ?", id).
+		Pluck("policies.id", &dependents).Error; err != nil {
+		log.Printf("Error fetching dependent policies: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching dependent policies"})
+		return
+	}
+	if len(dependents) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Group is referenced by policies", "policies": dependents})
+		return
+	}
+
+	tx := psql.DB.Begin()
+	if err := tx.Select(clause.Associations).Delete(group).Error; err != nil {
+		tx.Rollback()
+		log.Printf("Error in deleting group: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in deleting group"})
+		return
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), "delete", objectType, groupID(group), group, nil); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing group: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing group"})
+		return
+	}
+	tx.Commit()
+	c.JSON(http.StatusOK, gin.H{"success": "Group Deleted Successfully"})
+}
+
+func groupID(group interface{}) uint {
+	switch g := group.(type) {
+	case *models.AddressGroup:
+		return g.ID
+	case *models.ServiceGroup:
+		return g.ID
+	}
+	return 0
+}
+
+func reenforceDependents(before []models.Policy, joinTable, joinKey string, groupID uint) {
+	after, err := policies.DependentPolicies(psql.DB, joinTable, joinKey, groupID)
+	if err != nil {
+		log.Printf("Error fetching dependent policies: %v", err)
+		return
+	}
+	policies.ReenforceDependents(context.TODO(), psql.DB, before, after)
+}
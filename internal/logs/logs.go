@@ -5,42 +5,84 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"github.com/gin-gonic/gin"
 	"github.com/hanshal101/snapwall/database/clickhouse"
 	"github.com/hanshal101/snapwall/models"
 )
 
-func StoreLogs(ctx context.Context, data *models.Log) error {
-	createTableQuery := `
-		CREATE TABLE IF NOT EXISTS service_logs (
-			time DateTime,
-			type String,
-			source String,
-			destination String,
-			port String,
-			protocol String,
-			severity String
-		) ENGINE = MergeTree()
-		ORDER BY (time, source, destination)
-		PRIMARY KEY (time, source, destination)
-		PARTITION BY toYYYYMMDD(time)
-	`
+// Columns are the service_logs columns Scan reads, in order.
+const Columns = "time, type, source, destination, port, protocol, severity, feeds, country, city, asn, org"
+
+// addedColumns are the columns added to service_logs after it was first
+// created, in the order they were added.
+var addedColumns = []string{
+	"feeds Array(String)",
+	"country LowCardinality(String)",
+	"city String",
+	"asn UInt32",
+	"org String",
+}
 
-	if err := clickhouse.CHClient.Exec(ctx, createTableQuery); err != nil {
-		log.Fatalf("Error creating table: %v", err)
-		return err
-	}
+var schema sync.Once
+
+// ensureSchema creates service_logs and adds the columns introduced since
+// the table was first created.
+func ensureSchema(ctx context.Context) {
+	schema.Do(func() {
+		createTableQuery := `
+			CREATE TABLE IF NOT EXISTS service_logs (
+				time DateTime,
+				type String,
+				source String,
+				destination String,
+				port String,
+				protocol String,
+				severity String,
+				feeds Array(String),
+				country LowCardinality(String),
+				city String,
+				asn UInt32,
+				org String
+			) ENGINE = MergeTree()
+			ORDER BY (time, source, destination)
+			PRIMARY KEY (time, source, destination)
+			PARTITION BY toYYYYMMDD(time)
+		`
+		if err := clickhouse.CHClient.Exec(ctx, createTableQuery); err != nil {
+			log.Fatalf("Error creating table: %v", err)
+		}
+
+		for _, column := range addedColumns {
+			if err := clickhouse.CHClient.Exec(ctx, "ALTER TABLE service_logs ADD COLUMN IF NOT EXISTS "+column); err != nil {
+				log.Fatalf("Error adding column %s: %v", column, err)
+			}
+		}
+	})
+}
+
+func StoreLogs(ctx context.Context, data *models.Log) error {
+	ensureSchema(ctx)
 
 	batch, err := clickhouse.CHClient.PrepareBatch(ctx, `
-		INSERT INTO service_logs (time, type, source, destination, port, protocol, severity) VALUES (?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO service_logs (`+Columns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
 		log.Fatalf("Error preparing batch insert statement: %v", err)
 		return err
 	}
 
-	if err := batch.Append(data.Time, data.Type, data.Source, data.Destination, data.Port, data.Protocol, data.Severity); err != nil {
+	feeds := data.Feeds
+	if feeds == nil {
+		feeds = []string{}
+	}
+	if err := batch.Append(data.Time, data.Type, data.Source, data.Destination, data.Port, data.Protocol, data.Severity, feeds,
+		data.Country, data.City, data.ASN, data.Org); err != nil {
 		log.Fatalf("Error appending data to batch: %v", err)
 		return err
 	}
@@ -54,12 +96,133 @@ func StoreLogs(ctx context.Context, data *models.Log) error {
 	return nil
 }
 
+// Scan reads a row selected with Columns.
+func Scan(rows driver.Rows) (models.Log, error) {
+	var logEntry models.Log
+	err := rows.Scan(
+		&logEntry.Time,
+		&logEntry.Type,
+		&logEntry.Source,
+		&logEntry.Destination,
+		&logEntry.Port,
+		&logEntry.Protocol,
+		&logEntry.Severity,
+		&logEntry.Feeds,
+		&logEntry.Country,
+		&logEntry.City,
+		&logEntry.ASN,
+		&logEntry.Org,
+	)
+	return logEntry, err
+}
+
+// Where builds the WHERE clause of a service_logs query from cond, which
+// may be empty, and the enrichment filters of the request: country (ISO
+// code), city, asn and org (a case-insensitive substring).
+func Where(c *gin.Context, cond string, args ...interface{}) (string, []interface{}, error) {
+	var conds []string
+	if cond != "" {
+		conds = append(conds, cond)
+	}
+	if country := c.Query("country"); country != "" {
+		conds = append(conds, "country = ?")
+		args = append(args, strings.ToUpper(country))
+	}
+	if city := c.Query("city"); city != "" {
+		conds = append(conds, "lower(city) = lower(?)")
+		args = append(args, city)
+	}
+	if value := c.Query("asn"); value != "" {
+		asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(value), "AS"), 10, 32)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid asn %q", value)
+		}
+		conds = append(conds, "asn = ?")
+		args = append(args, uint32(asn))
+	}
+	if org := c.Query("org"); org != "" {
+		conds = append(conds, "positionCaseInsensitiveUTF8(org, ?) > 0")
+		args = append(args, org)
+	}
+
+	if len(conds) == 0 {
+		return "", args, nil
+	}
+	return "WHERE " + strings.Join(conds, " AND "), args, nil
+}
+
+// MarkDetection raises the severity of the logs a detection is made of. The
+// update is a ClickHouse mutation and is applied asynchronously.
+func MarkDetection(ctx context.Context, detection models.Detection) error {
+	var lower []string
+	for _, severity := range models.Severities {
+		if severity.Rank() < detection.Severity.Rank() {
+			lower = append(lower, string(severity))
+		}
+	}
+
+	query := `
+		ALTER TABLE service_logs UPDATE severity = ?
+		WHERE source = ? AND time BETWEEN ? AND ?
+		AND has(?, destination) AND has(?, severity)
+	`
+	args := []interface{}{
+		string(detection.Severity),
+		detection.Source,
+		// service_logs keeps whole seconds.
+		detection.FirstSeen.Truncate(time.Second),
+		detection.LastSeen,
+		detection.Destinations,
+		lower,
+	}
+	// Detections without ports cover every port.
+	if len(detection.Ports) > 0 {
+		query += " AND has(?, port)"
+		args = append(args, detection.Ports)
+	}
+	if err := clickhouse.CHClient.Exec(ctx, query, args...); err != nil {
+		return fmt.Errorf("error marking logs of %s detection: %v", detection.Kind, err)
+	}
+	return nil
+}
+
+// Recent returns the latest logs of a source, newest first.
+func Recent(ctx context.Context, source string, limit int) ([]models.Log, error) {
+	rows, err := clickhouse.CHClient.Query(ctx, `
+		SELECT `+Columns+`
+		FROM service_logs
+		WHERE source = ?
+		ORDER BY time DESC
+		LIMIT ?
+	`, source, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logs []models.Log
+	for rows.Next() {
+		logEntry, err := Scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, logEntry)
+	}
+	return logs, rows.Err()
+}
+
 func GetLogs(c *gin.Context) {
+	where, args, err := Where(c, "")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	query := `
-		SELECT time, type, source, destination, port, protocol, severity
+		SELECT ` + Columns + `
 		FROM service_logs
-	`
-	rows, err := clickhouse.CHClient.Query(context.TODO(), query)
+	` + where
+	rows, err := clickhouse.CHClient.Query(context.TODO(), query, args...)
 	if err != nil {
 		log.Fatalf("Error executing query: %v", err)
 		c.JSON(http.StatusBadGateway, gin.H{"error": "Error executing query"})
@@ -69,17 +232,8 @@ func GetLogs(c *gin.Context) {
 
 	var logs []models.Log
 	for rows.Next() {
-		var logEntry models.Log
-
-		if err := rows.Scan(
-			&logEntry.Time,
-			&logEntry.Type,
-			&logEntry.Source,
-			&logEntry.Destination,
-			&logEntry.Port,
-			&logEntry.Protocol,
-			&logEntry.Severity,
-		); err != nil {
+		logEntry, err := Scan(rows)
+		if err != nil {
 			log.Fatalf("Error scanning row: %v", err)
 			c.JSON(http.StatusBadGateway, gin.H{"error": "Error scanning row"})
 			return
@@ -100,13 +254,18 @@ func GetLogs(c *gin.Context) {
 func GetLogsByPort(c *gin.Context) {
 	port := c.Param("portNumber")
 
+	where, args, err := Where(c, "port = ?", port)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	query := `
-        SELECT time, type, source, destination, port, protocol, severity
+        SELECT ` + Columns + `
         FROM service_logs
-        WHERE port = ?
-    `
+    ` + where
 
-	rows, err := clickhouse.CHClient.Query(context.TODO(), query, port)
+	rows, err := clickhouse.CHClient.Query(context.TODO(), query, args...)
 	if err != nil {
 		log.Printf("Error executing query: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Error executing query"})
@@ -116,17 +275,8 @@ func GetLogsByPort(c *gin.Context) {
 
 	var logs []models.Log
 	for rows.Next() {
-		var logEntry models.Log
-
-		if err := rows.Scan(
-			&logEntry.Time,
-			&logEntry.Type,
-			&logEntry.Source,
-			&logEntry.Destination,
-			&logEntry.Port,
-			&logEntry.Protocol,
-			&logEntry.Severity,
-		); err != nil {
+		logEntry, err := Scan(rows)
+		if err != nil {
 			log.Printf("Error scanning row: %v", err)
 			continue
 		}
@@ -147,13 +297,18 @@ func GetLogsByIP(c *gin.Context) {
 	ioType := c.Param("ioType")
 	ipAddress := c.Param("ipAddress")
 
-	query := fmt.Sprintf(`
-        SELECT time, type, source, destination, port, protocol, severity
+	where, args, err := Where(c, ioType+" = ?", ipAddress)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := `
+        SELECT ` + Columns + `
         FROM service_logs
-        WHERE %s = ?
-    `, ioType)
+    ` + where
 
-	rows, err := clickhouse.CHClient.Query(context.TODO(), query, ipAddress)
+	rows, err := clickhouse.CHClient.Query(context.TODO(), query, args...)
 	if err != nil {
 		log.Printf("Error executing query: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Error executing query"})
@@ -163,17 +318,8 @@ func GetLogsByIP(c *gin.Context) {
 
 	var logs []models.Log
 	for rows.Next() {
-		var logEntry models.Log
-
-		if err := rows.Scan(
-			&logEntry.Time,
-			&logEntry.Type,
-			&logEntry.Source,
-			&logEntry.Destination,
-			&logEntry.Port,
-			&logEntry.Protocol,
-			&logEntry.Severity,
-		); err != nil {
+		logEntry, err := Scan(rows)
+		if err != nil {
 			log.Printf("Error scanning row: %v", err)
 			continue
 		}
@@ -190,14 +336,67 @@ func GetLogsByIP(c *gin.Context) {
 	c.JSON(http.StatusOK, logs)
 }
 
+// GetIntruderLogs lists the logs of severity HIGH and above.
 func GetIntruderLogs(c *gin.Context) {
+	var intruder []string
+	for _, severity := range models.Severities {
+		if severity.Rank() >= models.SEVERITY_HIGH.Rank() {
+			intruder = append(intruder, string(severity))
+		}
+	}
+	where, args, err := Where(c, "has(?, severity)", intruder)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := `
+        SELECT ` + Columns + `
+        FROM service_logs
+    ` + where
+
+	rows, err := clickhouse.CHClient.Query(context.TODO(), query, args...)
+	if err != nil {
+		log.Printf("Error executing query: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error executing query"})
+		return
+	}
+	defer rows.Close()
+
+	var logs []models.Log
+	for rows.Next() {
+		logEntry, err := Scan(rows)
+		if err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+
+		logs = append(logs, logEntry)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over rows: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving logs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, logs)
+}
+
+// GetLogsByFeed lists the logs whose source is listed by a threat-intel feed.
+func GetLogsByFeed(c *gin.Context) {
+	where, args, err := Where(c, "has(feeds, ?)", c.Param("feedName"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	query := `
-        SELECT time, type, source, destination, port, protocol, severity
+        SELECT ` + Columns + `
         FROM service_logs
-        WHERE severity = ?
-    `
+    ` + where
 
-	rows, err := clickhouse.CHClient.Query(context.TODO(), query, "HIGH")
+	rows, err := clickhouse.CHClient.Query(context.TODO(), query, args...)
 	if err != nil {
 		log.Printf("Error executing query: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Error executing query"})
@@ -207,17 +406,8 @@ func GetIntruderLogs(c *gin.Context) {
 
 	var logs []models.Log
 	for rows.Next() {
-		var logEntry models.Log
-
-		if err := rows.Scan(
-			&logEntry.Time,
-			&logEntry.Type,
-			&logEntry.Source,
-			&logEntry.Destination,
-			&logEntry.Port,
-			&logEntry.Protocol,
-			&logEntry.Severity,
-		); err != nil {
+		logEntry, err := Scan(rows)
+		if err != nil {
 			log.Printf("Error scanning row: %v", err)
 			continue
 		}
@@ -0,0 +1,381 @@
+package logs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanshal101/snapwall/database/psql"
+	"github.com/hanshal101/snapwall/models"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/net/websocket"
+)
+
+// Channel is the Postgres notification channel the server publishes stored
+// logs on, for the API to stream them. Each notification carries a JSON
+// array of logs.
+const Channel = "service_logs"
+
+const (
+	// streamBuffer is how many logs a stream can fall behind by before it
+	// misses some.
+	streamBuffer = 256
+	// keepAlive is how often streams report missed logs and, if idle, send
+	// something to keep proxies from closing them.
+	keepAlive = 15 * time.Second
+	// maxBackoff bounds the wait before listening again after Postgres
+	// went away.
+	maxBackoff = 30 * time.Second
+	// maxPayload keeps notifications under the 8000 bytes Postgres allows.
+	maxPayload = 7900
+)
+
+// Publisher announces stored logs on Channel in batches, in the background,
+// so ingestion never waits on Postgres. Logs are dropped if it falls more
+// than its buffer behind.
+type Publisher struct {
+	queue   chan models.Log
+	dropped atomic.Int64
+}
+
+func NewPublisher(buffer int) *Publisher {
+	return &Publisher{queue: make(chan models.Log, buffer)}
+}
+
+// Publish queues a log without blocking.
+func (p *Publisher) Publish(entry models.Log) {
+	select {
+	case p.queue <- entry:
+	default:
+		p.dropped.Add(1)
+	}
+}
+
+// Start publishes the queued logs every interval until ctx is done.
+func (p *Publisher) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if dropped := p.dropped.Swap(0); dropped > 0 {
+				log.Printf("Dropped %d logs from the live stream: publishing is not keeping up", dropped)
+			}
+			p.flush(ctx)
+		}
+	}()
+}
+
+// flush publishes the logs queued so far, as many per notification as fit.
+func (p *Publisher) flush(ctx context.Context) {
+	var batch []json.RawMessage
+	size := 2
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		payload, _ := json.Marshal(batch)
+		if err := psql.DB.WithContext(ctx).Exec("SELECT pg_notify(?, ?)", Channel, string(payload)).Error; err != nil {
+			log.Printf("Error in publishing %d logs: %v", len(batch), err)
+		}
+		batch, size = batch[:0], 2
+	}
+
+	for {
+		select {
+		case entry := <-p.queue:
+			encoded, err := json.Marshal(entry)
+			if err != nil || len(encoded)+2 > maxPayload {
+				log.Printf("Skipping log from %s: too large to publish", entry.Source)
+				continue
+			}
+			if size+len(encoded)+1 > maxPayload {
+				send()
+			}
+			batch = append(batch, encoded)
+			size += len(encoded) + 1
+		default:
+			send()
+			return
+		}
+	}
+}
+
+// Filter selects the logs a stream carries: those at or above MinSeverity
+// whose source or destination is in Prefix, to Port over Protocol. Zero
+// fields select every log.
+type Filter struct {
+	MinSeverity models.SEVERITY
+	Prefix      netip.Prefix
+	Port        string
+	Protocol    string
+}
+
+// NewFilter parses the filter of a stream. ip is an address or a CIDR.
+func NewFilter(severity, ip, port, protocol string) (Filter, error) {
+	filter := Filter{Port: port, Protocol: protocol}
+	if severity != "" {
+		var ok bool
+		if filter.MinSeverity, ok = models.ParseSeverity(severity); !ok {
+			return Filter{}, fmt.Errorf("invalid severity %q", severity)
+		}
+	}
+	if ip != "" {
+		if strings.Contains(ip, "/") {
+			prefix, err := netip.ParsePrefix(ip)
+			if err != nil {
+				return Filter{}, fmt.Errorf("invalid ip %q", ip)
+			}
+			filter.Prefix = prefix.Masked()
+		} else {
+			addr, err := netip.ParseAddr(ip)
+			if err != nil {
+				return Filter{}, fmt.Errorf("invalid ip %q", ip)
+			}
+			addr = addr.Unmap()
+			filter.Prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+	}
+	return filter, nil
+}
+
+// Matches reports whether the filter selects a log.
+func (f Filter) Matches(entry models.Log) bool {
+	if f.MinSeverity != "" && models.SEVERITY(entry.Severity).Rank() < f.MinSeverity.Rank() {
+		return false
+	}
+	if f.Port != "" && entry.Port != f.Port {
+		return false
+	}
+	if f.Protocol != "" && !strings.EqualFold(entry.Protocol, f.Protocol) {
+		return false
+	}
+	if f.Prefix.IsValid() && !f.contains(entry.Source) && !f.contains(entry.Destination) {
+		return false
+	}
+	return true
+}
+
+func (f Filter) contains(address string) bool {
+	addr, err := netip.ParseAddr(address)
+	return err == nil && f.Prefix.Contains(addr.Unmap())
+}
+
+// Hub fans logs out to subscriptions. A subscription that falls behind by
+// more than its buffer misses logs rather than holding up the others.
+type Hub struct {
+	mu            sync.RWMutex
+	subscriptions map[*Subscription]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subscriptions: make(map[*Subscription]struct{})}
+}
+
+// Subscription receives the logs its filter selects on Logs until it is
+// closed.
+type Subscription struct {
+	Logs    <-chan models.Log
+	logs    chan models.Log
+	filter  Filter
+	hub     *Hub
+	dropped atomic.Int64
+}
+
+// Subscribe starts a subscription buffering up to buffer logs.
+func (h *Hub) Subscribe(filter Filter, buffer int) *Subscription {
+	logs := make(chan models.Log, buffer)
+	s := &Subscription{Logs: logs, logs: logs, filter: filter, hub: h}
+	h.mu.Lock()
+	h.subscriptions[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+// Close ends the subscription. Logs is not closed.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	delete(s.hub.subscriptions, s)
+	s.hub.mu.Unlock()
+}
+
+// Dropped returns how many logs the subscription missed since the last
+// call.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Swap(0)
+}
+
+// Broadcast hands a log to the subscriptions selecting it without blocking.
+func (h *Hub) Broadcast(entry models.Log) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range h.subscriptions {
+		if !s.filter.Matches(entry) {
+			continue
+		}
+		select {
+		case s.logs <- entry:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// streams carries the logs published on Channel to the streams of the API.
+var streams = NewHub()
+
+// Listen broadcasts the logs published on Channel to the streams until ctx
+// is done, listening again with backoff when the connection to Postgres at
+// dsn fails.
+func Listen(ctx context.Context, dsn string) {
+	go func() {
+		backoff := time.Second
+		for {
+			err := listen(ctx, dsn, func() { backoff = time.Second })
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Error in listening for new logs: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+	}()
+}
+
+// listen broadcasts notifications until the connection fails, calling
+// listening once it listens.
+func listen(ctx context.Context, dsn string, listening func()) error {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+Channel); err != nil {
+		return err
+	}
+	listening()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var entries []models.Log
+		if err := json.Unmarshal([]byte(notification.Payload), &entries); err != nil {
+			log.Printf("Error in decoding published logs: %v", err)
+			continue
+		}
+		for _, entry := range entries {
+			streams.Broadcast(entry)
+		}
+	}
+}
+
+// streamMessage is what WebSocket streams send: a log, or the number of
+// logs the stream missed because the client fell behind.
+type streamMessage struct {
+	Type    string      `json:"type"` // log, dropped
+	Log     *models.Log `json:"log,omitempty"`
+	Dropped int64       `json:"dropped,omitempty"`
+}
+
+// StreamLogs tails the logs the server stores, over a WebSocket if the
+// request asks for one and as server-sent events otherwise. It accepts the
+// filters severity (the minimum), ip (the source or destination, an
+// address or a CIDR), port and protocol. Server-sent events are "log"
+// events and "dropped" events counting the logs a slow client missed.
+func StreamLogs(c *gin.Context) {
+	filter, err := NewFilter(c.Query("severity"), c.Query("ip"), c.Query("port"), c.Query("protocol"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	subscription := streams.Subscribe(filter, streamBuffer)
+	defer subscription.Close()
+
+	if c.IsWebsocket() {
+		// Origins are not checked, like the CORS policy of the API.
+		server := websocket.Server{Handler: func(ws *websocket.Conn) {
+			streamWebSocket(ws, subscription)
+		}}
+		server.ServeHTTP(c.Writer, c.Request)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	// Send the headers now so clients know the stream is open.
+	c.Writer.WriteHeader(http.StatusOK)
+	c.Writer.Flush()
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case entry := <-subscription.Logs:
+			c.SSEvent("log", entry)
+		case <-ticker.C:
+			if dropped := subscription.Dropped(); dropped > 0 {
+				c.SSEvent("dropped", gin.H{"dropped": dropped})
+			} else {
+				io.WriteString(w, ": keep-alive\n\n")
+			}
+		}
+		return true
+	})
+}
+
+func streamWebSocket(ws *websocket.Conn, subscription *Subscription) {
+	defer ws.Close()
+
+	// Clients send nothing; reading only notices them going away.
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, ws)
+		close(gone)
+	}()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		var message streamMessage
+		select {
+		case <-gone:
+			return
+		case entry := <-subscription.Logs:
+			message = streamMessage{Type: "log", Log: &entry}
+		case <-ticker.C:
+			dropped := subscription.Dropped()
+			if dropped == 0 {
+				continue
+			}
+			message = streamMessage{Type: "dropped", Dropped: dropped}
+		}
+		if err := websocket.JSON.Send(ws, message); err != nil {
+			return
+		}
+	}
+}
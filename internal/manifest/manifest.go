@@ -0,0 +1,740 @@
+package manifest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanshal101/snapwall/database/psql"
+	"github.com/hanshal101/snapwall/internal/application"
+	"github.com/hanshal101/snapwall/internal/audit"
+	"github.com/hanshal101/snapwall/internal/groups"
+	"github.com/hanshal101/snapwall/internal/policies"
+	"github.com/hanshal101/snapwall/models"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Document is the declarative form of snapwall's configuration. Objects are
+// identified by name and policies reference groups and applications by name,
+// so a document can be applied to any database.
+type Document struct {
+	AddressGroups []AddressGroup `json:"address_groups,omitempty" yaml:"address_groups,omitempty"`
+	ServiceGroups []ServiceGroup `json:"service_groups,omitempty" yaml:"service_groups,omitempty"`
+	Applications  []Application  `json:"applications,omitempty" yaml:"applications,omitempty"`
+	Policies      []Policy       `json:"policies,omitempty" yaml:"policies,omitempty"`
+}
+
+type AddressGroup struct {
+	Name        string   `json:"name" yaml:"name"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Addresses   []string `json:"addresses,omitempty" yaml:"addresses,omitempty"`
+}
+
+type Service struct {
+	Port     string `json:"port" yaml:"port"`
+	Protocol string `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+}
+
+type ServiceGroup struct {
+	Name        string    `json:"name" yaml:"name"`
+	Description string    `json:"description,omitempty" yaml:"description,omitempty"`
+	Services    []Service `json:"services,omitempty" yaml:"services,omitempty"`
+}
+
+type Application struct {
+	Name        string   `json:"name" yaml:"name"`
+	Port        string   `json:"port" yaml:"port"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+type Policy struct {
+	Name            string   `json:"name" yaml:"name"`
+	Type            string   `json:"type" yaml:"type"`
+	Severity        string   `json:"severity,omitempty" yaml:"severity,omitempty"`
+	IPs             []string `json:"ips,omitempty" yaml:"ips,omitempty"`
+	Ports           []string `json:"ports,omitempty" yaml:"ports,omitempty"`
+	AddressGroups   []string `json:"address_groups,omitempty" yaml:"address_groups,omitempty"`
+	ServiceGroups   []string `json:"service_groups,omitempty" yaml:"service_groups,omitempty"`
+	Applications    []string `json:"applications,omitempty" yaml:"applications,omitempty"`
+	ApplicationTags []string `json:"application_tags,omitempty" yaml:"application_tags,omitempty"`
+	Countries       []string `json:"countries,omitempty" yaml:"countries,omitempty"`
+	ASNs            []uint32 `json:"asns,omitempty" yaml:"asns,omitempty"`
+	Hostnames       []string `json:"hostnames,omitempty" yaml:"hostnames,omitempty"`
+}
+
+const (
+	KindAddressGroup = "address_group"
+	KindServiceGroup = "service_group"
+	KindApplication  = "application"
+	KindPolicy       = "policy"
+
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Change is one step of applying a document.
+type Change struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+}
+
+// ErrInvalidObject is returned for groups and applications a document
+// cannot store as written.
+var ErrInvalidObject = errors.New("invalid object")
+
+type Options struct {
+	// Prune deletes objects that exist in the database but not in the
+	// document.
+	Prune bool
+	// DryRun computes the changes without committing them.
+	DryRun bool
+}
+
+// Parse decodes a YAML or JSON document.
+func Parse(data []byte) (Document, error) {
+	var doc Document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return doc, fmt.Errorf("error decoding document: %w", err)
+	}
+	// Severities and country codes are stored upper case and hostnames lower
+	// case; normalise so documents compare equal to what Export produces.
+	for i := range doc.Policies {
+		doc.Policies[i].Severity = strings.ToUpper(doc.Policies[i].Severity)
+		for j, code := range doc.Policies[i].Countries {
+			doc.Policies[i].Countries[j] = strings.ToUpper(code)
+		}
+		for j, name := range doc.Policies[i].Hostnames {
+			doc.Policies[i].Hostnames[j] = strings.ToLower(strings.TrimSuffix(name, "."))
+		}
+	}
+	return doc, doc.check()
+}
+
+// Merge concatenates documents, as when syncing a directory of files.
+func Merge(docs ...Document) Document {
+	var merged Document
+	for _, doc := range docs {
+		merged.AddressGroups = append(merged.AddressGroups, doc.AddressGroups...)
+		merged.ServiceGroups = append(merged.ServiceGroups, doc.ServiceGroups...)
+		merged.Applications = append(merged.Applications, doc.Applications...)
+		merged.Policies = append(merged.Policies, doc.Policies...)
+	}
+	return merged
+}
+
+func (doc Document) check() error {
+	seen := make(map[string]bool)
+	add := func(kind, name string) error {
+		if name == "" {
+			return fmt.Errorf("%s without a name", kind)
+		}
+		if seen[kind+"/"+name] {
+			return fmt.Errorf("duplicate %s %q", kind, name)
+		}
+		seen[kind+"/"+name] = true
+		return nil
+	}
+
+	for _, group := range doc.AddressGroups {
+		if err := add(KindAddressGroup, group.Name); err != nil {
+			return err
+		}
+	}
+	for _, group := range doc.ServiceGroups {
+		if err := add(KindServiceGroup, group.Name); err != nil {
+			return err
+		}
+	}
+	for _, application := range doc.Applications {
+		if err := add(KindApplication, application.Name); err != nil {
+			return err
+		}
+	}
+	for _, policy := range doc.Policies {
+		if err := add(KindPolicy, policy.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Export reads the current configuration into a document.
+func Export(db *gorm.DB) (Document, error) {
+	var doc Document
+
+	var addressGroups []models.AddressGroup
+	if err := db.Preload("Addresses").Order("name").Find(&addressGroups).Error; err != nil {
+		return doc, fmt.Errorf("error in fetching address groups: %w", err)
+	}
+	for _, group := range addressGroups {
+		if group.Managed != "" {
+			continue
+		}
+		doc.AddressGroups = append(doc.AddressGroups, fromAddressGroup(group))
+	}
+
+	var serviceGroups []models.ServiceGroup
+	if err := db.Preload("Services").Order("name").Find(&serviceGroups).Error; err != nil {
+		return doc, fmt.Errorf("error in fetching service groups: %w", err)
+	}
+	for _, group := range serviceGroups {
+		doc.ServiceGroups = append(doc.ServiceGroups, fromServiceGroup(group))
+	}
+
+	var applications []models.Application
+	if err := db.Preload("Tags").Order("name").Find(&applications).Error; err != nil {
+		return doc, fmt.Errorf("error in fetching applications: %w", err)
+	}
+	for _, application := range applications {
+		doc.Applications = append(doc.Applications, fromApplication(application))
+	}
+
+	all, err := policies.Load(db)
+	if err != nil {
+		return doc, fmt.Errorf("error in fetching policies: %w", err)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	for _, policy := range all {
+		if policy.Managed != "" {
+			continue
+		}
+		doc.Policies = append(doc.Policies, fromPolicy(policy))
+	}
+
+	return doc, nil
+}
+
+// Apply makes the database match the document and enforces the policies
+// that changed as a result. It returns the changes made, or that would be
+// made for a dry run.
+func Apply(ctx context.Context, db *gorm.DB, doc Document, opts Options, actor audit.Actor) ([]Change, error) {
+	if err := doc.check(); err != nil {
+		return nil, err
+	}
+
+	before, err := policies.Load(db)
+	if err != nil {
+		return nil, fmt.Errorf("error in fetching policies: %w", err)
+	}
+
+	tx := db.Begin()
+	changes, err := apply(tx, doc, opts, actor)
+	if err != nil {
+		tx.Rollback()
+		return changes, err
+	}
+	if opts.DryRun {
+		tx.Rollback()
+		return changes, nil
+	}
+	if err := tx.Commit().Error; err != nil {
+		return changes, fmt.Errorf("error in committing changes: %w", err)
+	}
+
+	after, err := policies.Load(db)
+	if err != nil {
+		return changes, fmt.Errorf("error in fetching policies: %w", err)
+	}
+	policies.ReenforceChanged(ctx, before, after)
+	return changes, nil
+}
+
+// validate checks the groups and applications of a document the way their
+// API endpoints do, so nothing is stored that the enforcer would reject.
+func (doc Document) validate() error {
+	invalid := func(kind, name string, errs []policies.FieldError) error {
+		msgs := make([]string, 0, len(errs))
+		for _, e := range errs {
+			msgs = append(msgs, e.Error())
+		}
+		return fmt.Errorf("%w: %s %q: %s", ErrInvalidObject, kind, name, strings.Join(msgs, "; "))
+	}
+
+	for _, group := range doc.AddressGroups {
+		req := groups.AddressGroupRequest{Name: group.Name, Description: group.Description, Addresses: group.Addresses}
+		if errs := groups.ValidateAddressGroup(req); len(errs) > 0 {
+			return invalid(KindAddressGroup, group.Name, errs)
+		}
+	}
+	for _, group := range doc.ServiceGroups {
+		req := groups.ServiceGroupRequest{Name: group.Name, Description: group.Description}
+		for _, service := range group.Services {
+			req.Services = append(req.Services, groups.ServiceRequest{Port: service.Port, Protocol: service.Protocol})
+		}
+		if errs := groups.ValidateServiceGroup(req); len(errs) > 0 {
+			return invalid(KindServiceGroup, group.Name, errs)
+		}
+	}
+	for _, app := range doc.Applications {
+		req := application.CreateApplicationRequest{Name: app.Name, Port: app.Port, Description: app.Description, Tags: app.Tags}
+		if errs := application.Validate(req); len(errs) > 0 {
+			return invalid(KindApplication, app.Name, errs)
+		}
+	}
+	return nil
+}
+
+func apply(tx *gorm.DB, doc Document, opts Options, actor audit.Actor) ([]Change, error) {
+	var changes []Change
+	if err := doc.validate(); err != nil {
+		return changes, err
+	}
+	record := func(kind, name, action string) {
+		changes = append(changes, Change{Kind: kind, Name: name, Action: action})
+	}
+
+	// Groups and applications first, so policies can reference them.
+	var addressGroups []models.AddressGroup
+	if err := tx.Preload("Addresses").Find(&addressGroups).Error; err != nil {
+		return changes, fmt.Errorf("error in fetching address groups: %w", err)
+	}
+	addressGroupIDs := make(map[string]uint)
+	existingAddressGroups := make(map[string]models.AddressGroup)
+	for _, group := range addressGroups {
+		// Managed groups can be referenced but are not part of documents.
+		if group.Managed == "" {
+			existingAddressGroups[group.Name] = group
+		}
+		addressGroupIDs[group.Name] = group.ID
+	}
+	for _, want := range doc.AddressGroups {
+		current, ok := existingAddressGroups[want.Name]
+		delete(existingAddressGroups, want.Name)
+		if ok && equal(fromAddressGroup(current), want) {
+			continue
+		}
+
+		group := models.AddressGroup{Name: want.Name, Description: want.Description}
+		if ok {
+			group.Model = current.Model
+			if err := tx.Omit(clause.Associations).Save(&group).Error; err != nil {
+				return changes, fmt.Errorf("error saving address group %q: %w", want.Name, err)
+			}
+			if err := tx.Where("address_group_id = ?", group.ID).Delete(&models.GroupAddress{}).Error; err != nil {
+				return changes, fmt.Errorf("error deleting addresses of %q: %w", want.Name, err)
+			}
+		} else if err := tx.Create(&group).Error; err != nil {
+			return changes, fmt.Errorf("error in creating address group %q: %w", want.Name, err)
+		}
+		for _, address := range want.Addresses {
+			entry := models.GroupAddress{AddressGroupID: group.ID, Address: address}
+			if err := tx.Create(&entry).Error; err != nil {
+				return changes, fmt.Errorf("error in creating addresses of %q: %w", want.Name, err)
+			}
+			group.Addresses = append(group.Addresses, entry)
+		}
+
+		action, previous := ActionCreate, interface{}(nil)
+		if ok {
+			action, previous = ActionUpdate, current
+		}
+		if err := audit.Record(tx, actor, action, audit.ObjectAddressGroup, group.ID, previous, group); err != nil {
+			return changes, err
+		}
+		addressGroupIDs[group.Name] = group.ID
+		record(KindAddressGroup, want.Name, action)
+	}
+
+	var serviceGroups []models.ServiceGroup
+	if err := tx.Preload("Services").Find(&serviceGroups).Error; err != nil {
+		return changes, fmt.Errorf("error in fetching service groups: %w", err)
+	}
+	serviceGroupIDs := make(map[string]uint)
+	existingServiceGroups := make(map[string]models.ServiceGroup)
+	for _, group := range serviceGroups {
+		existingServiceGroups[group.Name] = group
+		serviceGroupIDs[group.Name] = group.ID
+	}
+	for _, want := range doc.ServiceGroups {
+		current, ok := existingServiceGroups[want.Name]
+		delete(existingServiceGroups, want.Name)
+		if ok && equal(fromServiceGroup(current), want) {
+			continue
+		}
+
+		group := models.ServiceGroup{Name: want.Name, Description: want.Description}
+		if ok {
+			group.Model = current.Model
+			if err := tx.Omit(clause.Associations).Save(&group).Error; err != nil {
+				return changes, fmt.Errorf("error saving service group %q: %w", want.Name, err)
+			}
+			if err := tx.Where("service_group_id = ?", group.ID).Delete(&models.GroupService{}).Error; err != nil {
+				return changes, fmt.Errorf("error deleting services of %q: %w", want.Name, err)
+			}
+		} else if err := tx.Create(&group).Error; err != nil {
+			return changes, fmt.Errorf("error in creating service group %q: %w", want.Name, err)
+		}
+		for _, service := range want.Services {
+			entry := models.GroupService{ServiceGroupID: group.ID, Port: service.Port, Protocol: service.Protocol}
+			if err := tx.Create(&entry).Error; err != nil {
+				return changes, fmt.Errorf("error in creating services of %q: %w", want.Name, err)
+			}
+			group.Services = append(group.Services, entry)
+		}
+
+		action, previous := ActionCreate, interface{}(nil)
+		if ok {
+			action, previous = ActionUpdate, current
+		}
+		if err := audit.Record(tx, actor, action, audit.ObjectServiceGroup, group.ID, previous, group); err != nil {
+			return changes, err
+		}
+		serviceGroupIDs[group.Name] = group.ID
+		record(KindServiceGroup, want.Name, action)
+	}
+
+	var applications []models.Application
+	if err := tx.Preload("Tags").Find(&applications).Error; err != nil {
+		return changes, fmt.Errorf("error in fetching applications: %w", err)
+	}
+	applicationIDs := make(map[string]uint)
+	existingApplications := make(map[string]models.Application)
+	for _, application := range applications {
+		existingApplications[application.Name] = application
+		applicationIDs[application.Name] = application.ID
+	}
+	for _, want := range doc.Applications {
+		current, ok := existingApplications[want.Name]
+		delete(existingApplications, want.Name)
+		if ok && equal(fromApplication(current), want) {
+			continue
+		}
+
+		application := models.Application{Name: want.Name, Port: want.Port, Description: want.Description}
+		if ok {
+			application.Model = current.Model
+			if err := tx.Omit(clause.Associations).Save(&application).Error; err != nil {
+				return changes, fmt.Errorf("error saving application %q: %w", want.Name, err)
+			}
+			if err := tx.Where("application_id = ?", application.ID).Delete(&models.Tags{}).Error; err != nil {
+				return changes, fmt.Errorf("error deleting tags of %q: %w", want.Name, err)
+			}
+		} else if err := tx.Create(&application).Error; err != nil {
+			return changes, fmt.Errorf("error in creating application %q: %w", want.Name, err)
+		}
+		for _, tagName := range want.Tags {
+			tag := models.Tags{ApplicationID: application.ID, Tag: tagName}
+			if err := tx.Create(&tag).Error; err != nil {
+				return changes, fmt.Errorf("error in creating tags of %q: %w", want.Name, err)
+			}
+			application.Tags = append(application.Tags, tag)
+		}
+
+		action, previous := ActionCreate, interface{}(nil)
+		if ok {
+			action, previous = ActionUpdate, current
+		}
+		if err := audit.Record(tx, actor, action, audit.ObjectApplication, application.ID, previous, application); err != nil {
+			return changes, err
+		}
+		applicationIDs[application.Name] = application.ID
+		record(KindApplication, want.Name, action)
+	}
+
+	// When pruning, objects missing from the document are about to be
+	// deleted and must not be referenced by the document's policies.
+	if opts.Prune {
+		for name := range existingAddressGroups {
+			delete(addressGroupIDs, name)
+		}
+		for name := range existingServiceGroups {
+			delete(serviceGroupIDs, name)
+		}
+		for name := range existingApplications {
+			delete(applicationIDs, name)
+		}
+	}
+
+	// Policies
+	current, err := policies.Load(tx)
+	if err != nil {
+		return changes, fmt.Errorf("error in fetching policies: %w", err)
+	}
+	existingPolicies := make(map[string]models.Policy)
+	for _, policy := range current {
+		// Policies managed by snapwall itself are not part of documents.
+		if policy.Managed == "" {
+			existingPolicies[policy.Name] = policy
+		}
+	}
+	for _, want := range doc.Policies {
+		existing, ok := existingPolicies[want.Name]
+		delete(existingPolicies, want.Name)
+		if ok && equal(fromPolicy(existing), want) {
+			continue
+		}
+
+		req, err := toRequest(want, addressGroupIDs, serviceGroupIDs, applicationIDs)
+		if err != nil {
+			return changes, err
+		}
+		lockout := policies.Lockout{ClientIP: actor.ClientIP, Ports: policies.ManagementPorts()}
+		if errs := policies.Validate(tx, req, lockout); len(errs) > 0 {
+			return changes, policies.InvalidError(want.Name, errs)
+		}
+
+		if ok {
+			before, after, err := policies.Update(tx, existing.ID, req)
+			if err != nil {
+				return changes, fmt.Errorf("error in updating policy %q: %w", want.Name, err)
+			}
+			if err := audit.Record(tx, actor, policies.ActionUpdate, audit.ObjectPolicy, after.ID, before, after); err != nil {
+				return changes, err
+			}
+			record(KindPolicy, want.Name, ActionUpdate)
+			continue
+		}
+
+		policy, err := policies.Create(tx, req)
+		if err != nil {
+			return changes, fmt.Errorf("error in creating policy %q: %w", want.Name, err)
+		}
+		if err := audit.Record(tx, actor, policies.ActionCreate, audit.ObjectPolicy, policy.ID, nil, policy); err != nil {
+			return changes, err
+		}
+		record(KindPolicy, want.Name, ActionCreate)
+	}
+
+	if !opts.Prune {
+		return changes, nil
+	}
+
+	// Deletions run last, once no remaining policy references the groups
+	// and applications being removed.
+	for _, name := range sortedKeys(existingPolicies) {
+		policy, err := policies.Delete(tx, existingPolicies[name].ID)
+		if err != nil {
+			return changes, fmt.Errorf("error in deleting policy %q: %w", name, err)
+		}
+		if err := audit.Record(tx, actor, policies.ActionDelete, audit.ObjectPolicy, policy.ID, policy, nil); err != nil {
+			return changes, err
+		}
+		record(KindPolicy, name, ActionDelete)
+	}
+	for _, name := range sortedKeys(existingAddressGroups) {
+		group := existingAddressGroups[name]
+		if err := tx.Select(clause.Associations).Delete(&group).Error; err != nil {
+			return changes, fmt.Errorf("error in deleting address group %q: %w", name, err)
+		}
+		if err := audit.Record(tx, actor, ActionDelete, audit.ObjectAddressGroup, group.ID, group, nil); err != nil {
+			return changes, err
+		}
+		record(KindAddressGroup, name, ActionDelete)
+	}
+	for _, name := range sortedKeys(existingServiceGroups) {
+		group := existingServiceGroups[name]
+		if err := tx.Select(clause.Associations).Delete(&group).Error; err != nil {
+			return changes, fmt.Errorf("error in deleting service group %q: %w", name, err)
+		}
+		if err := audit.Record(tx, actor, ActionDelete, audit.ObjectServiceGroup, group.ID, group, nil); err != nil {
+			return changes, err
+		}
+		record(KindServiceGroup, name, ActionDelete)
+	}
+	for _, name := range sortedKeys(existingApplications) {
+		application := existingApplications[name]
+		if err := tx.Delete(&application).Error; err != nil {
+			return changes, fmt.Errorf("error in deleting application %q: %w", name, err)
+		}
+		if err := audit.Record(tx, actor, ActionDelete, audit.ObjectApplication, application.ID, application, nil); err != nil {
+			return changes, err
+		}
+		record(KindApplication, name, ActionDelete)
+	}
+
+	return changes, nil
+}
+
+func toRequest(policy Policy, addressGroups, serviceGroups, applications map[string]uint) (policies.PolicyRequest, error) {
+	req := policies.PolicyRequest{
+		Name:            policy.Name,
+		Type:            policy.Type,
+		Severity:        policy.Severity,
+		IPs:             policy.IPs,
+		Ports:           policy.Ports,
+		ApplicationTags: policy.ApplicationTags,
+		Countries:       policy.Countries,
+		ASNs:            policy.ASNs,
+		Hostnames:       policy.Hostnames,
+	}
+
+	lookup := func(kind, name string, ids map[string]uint) (uint, error) {
+		id, ok := ids[name]
+		if !ok {
+			return 0, fmt.Errorf("%w: policy %q references unknown %s %q", policies.ErrInvalidReference, policy.Name, kind, name)
+		}
+		return id, nil
+	}
+	for _, name := range policy.AddressGroups {
+		id, err := lookup(KindAddressGroup, name, addressGroups)
+		if err != nil {
+			return req, err
+		}
+		req.AddressGroups = append(req.AddressGroups, id)
+	}
+	for _, name := range policy.ServiceGroups {
+		id, err := lookup(KindServiceGroup, name, serviceGroups)
+		if err != nil {
+			return req, err
+		}
+		req.ServiceGroups = append(req.ServiceGroups, id)
+	}
+	for _, name := range policy.Applications {
+		id, err := lookup(KindApplication, name, applications)
+		if err != nil {
+			return req, err
+		}
+		req.Applications = append(req.Applications, id)
+	}
+	return req, nil
+}
+
+func fromAddressGroup(group models.AddressGroup) AddressGroup {
+	out := AddressGroup{Name: group.Name, Description: group.Description}
+	for _, address := range group.Addresses {
+		out.Addresses = append(out.Addresses, address.Address)
+	}
+	return out
+}
+
+func fromServiceGroup(group models.ServiceGroup) ServiceGroup {
+	out := ServiceGroup{Name: group.Name, Description: group.Description}
+	for _, service := range group.Services {
+		out.Services = append(out.Services, Service{Port: service.Port, Protocol: service.Protocol})
+	}
+	return out
+}
+
+func fromApplication(application models.Application) Application {
+	out := Application{Name: application.Name, Port: application.Port, Description: application.Description}
+	for _, tag := range application.Tags {
+		out.Tags = append(out.Tags, tag.Tag)
+	}
+	return out
+}
+
+func fromPolicy(policy models.Policy) Policy {
+	out := Policy{Name: policy.Name, Type: policy.Type, Severity: string(policy.Severity)}
+	for _, ip := range policy.IPs {
+		out.IPs = append(out.IPs, ip.Address)
+	}
+	for _, port := range policy.Ports {
+		out.Ports = append(out.Ports, port.Number)
+	}
+	for _, group := range policy.AddressGroups {
+		out.AddressGroups = append(out.AddressGroups, group.Name)
+	}
+	for _, group := range policy.ServiceGroups {
+		out.ServiceGroups = append(out.ServiceGroups, group.Name)
+	}
+	for _, application := range policy.Applications {
+		out.Applications = append(out.Applications, application.Name)
+	}
+	for _, tag := range policy.ApplicationTags {
+		out.ApplicationTags = append(out.ApplicationTags, tag.Tag)
+	}
+	out.Countries = policy.Countries
+	out.ASNs = policy.ASNs
+	for _, hostname := range policy.Hostnames {
+		out.Hostnames = append(out.Hostnames, hostname.Name)
+	}
+	return out
+}
+
+// equal compares two document objects ignoring the order of their lists.
+func equal(a, b interface{}) bool {
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+func normalize(v interface{}) interface{} {
+	data, _ := json.Marshal(v)
+	var generic map[string]interface{}
+	json.Unmarshal(data, &generic)
+	for key, value := range generic {
+		list, ok := value.([]interface{})
+		if !ok {
+			continue
+		}
+		sort.Slice(list, func(i, j int) bool {
+			a, _ := json.Marshal(list[i])
+			b, _ := json.Marshal(list[j])
+			return string(a) < string(b)
+		})
+		generic[key] = list
+	}
+	return generic
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ExportDocument serves the current configuration as YAML, or as JSON with
+// ?format=json.
+func ExportDocument(c *gin.Context) {
+	doc, err := Export(psql.DB)
+	if err != nil {
+		log.Printf("Error in exporting document: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in exporting document"})
+		return
+	}
+
+	if c.Query("format") == "json" {
+		c.JSON(http.StatusOK, doc)
+		return
+	}
+	c.YAML(http.StatusOK, doc)
+}
+
+// ApplyDocument applies a YAML or JSON document from the request body.
+// ?prune=true deletes objects missing from the document and ?dry_run=true
+// only reports the changes.
+func ApplyDocument(c *gin.Context) {
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Printf("Error in reading document: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error in reading document"})
+		return
+	}
+
+	doc, err := Parse(data)
+	if err != nil {
+		log.Printf("Error in parsing document: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	opts := Options{
+		Prune:  c.Query("prune") == "true",
+		DryRun: c.Query("dry_run") == "true",
+	}
+	changes, err := Apply(context.TODO(), psql.DB, doc, opts, audit.ActorFrom(c))
+	if err != nil {
+		log.Printf("Error in applying document: %v", err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, policies.ErrInvalidReference) || errors.Is(err, policies.ErrInvalidPolicy) || errors.Is(err, ErrInvalidObject) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error(), "changes": changes})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"dry_run": opts.DryRun, "changes": changes})
+}
@@ -0,0 +1,132 @@
+package manifest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hanshal101/snapwall/internal/audit"
+	"gorm.io/gorm"
+)
+
+// LoadFile reads a single YAML or JSON document.
+func LoadFile(path string) (Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Document{}, err
+	}
+	doc, err := Parse(data)
+	if err != nil {
+		return doc, fmt.Errorf("%s: %w", path, err)
+	}
+	return doc, nil
+}
+
+// LoadDir reads every .yaml, .yml and .json file in dir, in name order, and
+// merges them into one document.
+func LoadDir(dir string) (Document, error) {
+	files, err := documentFiles(dir)
+	if err != nil {
+		return Document{}, err
+	}
+
+	var docs []Document
+	for _, file := range files {
+		doc, err := LoadFile(file)
+		if err != nil {
+			return Document{}, err
+		}
+		docs = append(docs, doc)
+	}
+
+	merged := Merge(docs...)
+	return merged, merged.check()
+}
+
+func documentFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".yaml", ".yml", ".json":
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// fingerprint changes whenever a document file in dir is added, removed or
+// modified.
+func fingerprint(dir string) (string, error) {
+	files, err := documentFiles(dir)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", file, info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+// Watch applies the documents in dir every time they change, checking every
+// interval until ctx is done. With prune, the directory is the only source
+// of truth and objects missing from it are deleted.
+func Watch(ctx context.Context, db *gorm.DB, dir string, interval time.Duration, prune bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last string
+	for {
+		current, err := fingerprint(dir)
+		if err != nil {
+			log.Printf("Error in scanning policy directory %s: %v", dir, err)
+		} else if current != last {
+			if err := syncDir(ctx, db, dir, prune); err != nil {
+				log.Printf("Error in syncing policy directory %s: %v", dir, err)
+			} else {
+				last = current
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func syncDir(ctx context.Context, db *gorm.DB, dir string, prune bool) error {
+	doc, err := LoadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	changes, err := Apply(ctx, db, doc, Options{Prune: prune}, audit.System("sync"))
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		log.Printf("Synced %s %s: %s", change.Kind, change.Name, change.Action)
+	}
+	return nil
+}
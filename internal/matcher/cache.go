@@ -0,0 +1,93 @@
+package matcher
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
+)
+
+// maxAge bounds how long a compiled matcher is used without a full rebuild,
+// to pick up changes made to the database outside of the API.
+const maxAge = 5 * time.Minute
+
+// Cache holds a compiled matcher and rebuilds it when the configuration
+// changes. Every change made through snapwall bumps the configuration
+// version; it is checked at most once per interval. Callers keep using the
+// current matcher while one of them rebuilds it.
+type Cache struct {
+	db       *gorm.DB
+	interval time.Duration
+
+	current atomic.Pointer[build]
+	// checked is when the version was last checked, in Unix nanoseconds.
+	checked atomic.Int64
+	// refreshing is held by the caller checking the version and rebuilding.
+	refreshing sync.Mutex
+}
+
+// build is a matcher and the configuration version it was built from.
+type build struct {
+	matcher *Matcher
+	version uint64
+	built   time.Time
+}
+
+func NewCache(db *gorm.DB, interval time.Duration) *Cache {
+	return &Cache{db: db, interval: interval}
+}
+
+// Matcher returns the current compiled matcher, rebuilding it first if the
+// configuration changed. If a rebuild fails the previous matcher, if any, is
+// returned along with the error.
+func (c *Cache) Matcher() (*Matcher, error) {
+	current := c.current.Load()
+	if current != nil && !c.due() {
+		return current.matcher, nil
+	}
+	if current == nil {
+		// There is nothing to use meanwhile; wait for the first build.
+		c.refreshing.Lock()
+	} else if !c.refreshing.TryLock() {
+		return current.matcher, nil
+	}
+	defer c.refreshing.Unlock()
+	return c.refresh()
+}
+
+func (c *Cache) due() bool {
+	return time.Since(time.Unix(0, c.checked.Load())) >= c.interval
+}
+
+// refresh checks the version and rebuilds the matcher if it changed. The
+// caller holds c.refreshing.
+func (c *Cache) refresh() (*Matcher, error) {
+	current := c.current.Load()
+	if current != nil && !c.due() {
+		// Another caller just refreshed it.
+		return current.matcher, nil
+	}
+	var previous *Matcher
+	if current != nil {
+		previous = current.matcher
+	}
+
+	now := time.Now()
+	c.checked.Store(now.UnixNano())
+	var version uint64
+	if err := c.db.Model(&models.ConfigVersion{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error; err != nil {
+		return previous, err
+	}
+	if current != nil && version == current.version && now.Sub(current.built) < maxAge {
+		return previous, nil
+	}
+
+	m, err := Load(c.db)
+	if err != nil {
+		return previous, err
+	}
+	c.current.Store(&build{matcher: m, version: version, built: now})
+	return m, nil
+}
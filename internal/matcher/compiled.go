@@ -0,0 +1,227 @@
+package matcher
+
+import (
+	"log"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/hanshal101/snapwall/internal/classify"
+	"github.com/hanshal101/snapwall/internal/enforcer"
+	"github.com/hanshal101/snapwall/internal/policies"
+	"github.com/hanshal101/snapwall/models"
+)
+
+// Matcher is a set of policies compiled for lookup: addresses go into a
+// binary prefix trie per IP family and each policy's ports into sorted,
+// merged intervals per protocol. A lookup walks at most 32 or 128 trie levels
+// and binary searches the ports of the policies found on the way.
+// Classification rules are evaluated in turn against every flow.
+type Matcher struct {
+	policies []compiled
+	rules    []compiledRule
+	v4, v6   node
+}
+
+type compiledRule struct {
+	rule models.ClassificationRule
+	expr *classify.Expr
+}
+
+type compiled struct {
+	policy models.Policy
+	ports  portIndex
+}
+
+type node struct {
+	children [2]*node
+	policies []int
+}
+
+type interval struct {
+	low, high uint16
+}
+
+// portIndex maps a protocol to its sorted, non-overlapping port intervals.
+type portIndex map[string][]interval
+
+// Compile builds a matcher from resolved policies and classification rules.
+// Disabled rules and rules that fail to compile are left out.
+func Compile(all []models.Policy, rules []models.ClassificationRule) *Matcher {
+	m := &Matcher{policies: make([]compiled, 0, len(all))}
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		expr, err := classify.Compile(rule.Expression)
+		if err != nil {
+			log.Printf("Skipping classification rule %s: %v", rule.Name, err)
+			continue
+		}
+		m.rules = append(m.rules, compiledRule{rule: rule, expr: expr})
+	}
+	for _, policy := range all {
+		i := len(m.policies)
+		ips, ports := policies.Resolve(policy)
+
+		index := make(portIndex)
+		for _, port := range ports {
+			low, high, err := policies.ParsePort(port.Number)
+			if err != nil {
+				continue
+			}
+			protocol := enforcer.Protocol(port)
+			index[protocol] = append(index[protocol], interval{low, high})
+		}
+		for protocol := range index {
+			index[protocol] = merge(index[protocol])
+		}
+		m.policies = append(m.policies, compiled{policy: policy, ports: index})
+
+		for _, ip := range ips {
+			prefix, err := policies.ParseAddress(ip.Address)
+			if err != nil {
+				continue
+			}
+			m.insert(prefix, i)
+		}
+	}
+	return m
+}
+
+func (m *Matcher) insert(prefix netip.Prefix, policy int) {
+	root := &m.v6
+	if prefix.Addr().Is4() {
+		root = &m.v4
+	}
+	bytes := prefix.Addr().AsSlice()
+	n := root
+	for bit := 0; bit < prefix.Bits(); bit++ {
+		b := bitAt(bytes, bit)
+		if n.children[b] == nil {
+			n.children[b] = &node{}
+		}
+		n = n.children[b]
+	}
+	n.policies = append(n.policies, policy)
+}
+
+// lookup returns the indexes of the policies with a prefix containing addr,
+// in policy order.
+func (m *Matcher) lookup(addr netip.Addr) []int {
+	n := &m.v6
+	if addr.Is4() {
+		n = &m.v4
+	}
+	bytes := addr.AsSlice()
+
+	var found []int
+	for bit := 0; n != nil; bit++ {
+		found = append(found, n.policies...)
+		if bit == len(bytes)*8 {
+			break
+		}
+		n = n.children[bitAt(bytes, bit)]
+	}
+
+	sort.Ints(found)
+	unique := found[:0]
+	for i, policy := range found {
+		if i == 0 || policy != found[i-1] {
+			unique = append(unique, policy)
+		}
+	}
+	return unique
+}
+
+func bitAt(bytes []byte, bit int) int {
+	return int(bytes[bit/8]>>(7-bit%8)) & 1
+}
+
+func merge(intervals []interval) []interval {
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].low < intervals[j].low })
+	merged := intervals[:0]
+	for _, iv := range intervals {
+		if last := len(merged) - 1; last >= 0 && uint32(iv.low) <= uint32(merged[last].high)+1 {
+			if iv.high > merged[last].high {
+				merged[last].high = iv.high
+			}
+			continue
+		}
+		merged = append(merged, iv)
+	}
+	return merged
+}
+
+// contains reports whether port is covered for protocol, or for any protocol
+// when protocol is empty. Ports for all protocols cover every flow, with or
+// without a port, as their rules match no port.
+func (idx portIndex) contains(protocol string, port uint16, ok bool) bool {
+	if len(idx[enforcer.ProtocolAll]) > 0 {
+		return true
+	}
+	if !ok {
+		return false
+	}
+	if protocol == "" {
+		for _, intervals := range idx {
+			if covers(intervals, port) {
+				return true
+			}
+		}
+		return false
+	}
+	return covers(idx[protocol], port)
+}
+
+func covers(intervals []interval, port uint16) bool {
+	i := sort.Search(len(intervals), func(i int) bool { return intervals[i].high >= port })
+	return i < len(intervals) && intervals[i].low <= port
+}
+
+// Evaluate matches a flow. A policy matches when the flow's source is covered
+// by one of its addresses and its destination port and protocol by one of
+// its ports. Flows matching an enforcer policy are dropped when incoming,
+// since rules live on the INPUT chain. The severity is the highest of the
+// matching policies and classification rules, LOW if nothing matches.
+func (m *Matcher) Evaluate(flow Flow) Result {
+	result := Result{Decision: DecisionAllow, Severity: models.SEVERITY_LOW}
+	raise := func(severity models.SEVERITY) {
+		if severity.Rank() > result.Severity.Rank() {
+			result.Severity = severity
+		}
+	}
+
+	fields := classify.Fields(flow)
+	for _, r := range m.rules {
+		if r.expr.Match(fields) {
+			result.Rules = append(result.Rules, r.rule)
+			raise(r.rule.Severity)
+		}
+	}
+
+	source, err := netip.ParseAddr(flow.Source)
+	if err != nil {
+		return result
+	}
+	source = source.Unmap()
+	port, _, err := policies.ParsePort(flow.Port)
+	protocol := strings.ToLower(flow.Protocol)
+
+	for _, i := range m.lookup(source) {
+		c := m.policies[i]
+		if !c.ports.contains(protocol, port, err == nil) {
+			continue
+		}
+		result.Matches = append(result.Matches, c.policy)
+		if c.policy.Severity == "" {
+			raise(models.SEVERITY_HIGH)
+		} else {
+			raise(c.policy.Severity)
+		}
+		if c.policy.Type == "enforcer" && (flow.Direction == "" || strings.EqualFold(flow.Direction, DirectionIncoming)) {
+			result.Decision = DecisionDrop
+		}
+	}
+	return result
+}
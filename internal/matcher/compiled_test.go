@@ -0,0 +1,71 @@
+package matcher
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/hanshal101/snapwall/internal/enforcer"
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
+)
+
+func TestEvaluate(t *testing.T) {
+	policy := func(id uint, kind string, severity models.SEVERITY, address string, ports ...models.Port) models.Policy {
+		return models.Policy{
+			Model:    gorm.Model{ID: id},
+			Name:     kind,
+			Type:     kind,
+			Severity: severity,
+			IPs:      []models.IP{{Address: address}},
+			Ports:    ports,
+		}
+	}
+	all := []models.Policy{
+		policy(1, "enforcer", models.SEVERITY_HIGH, "10.0.0.0/8", models.Port{Number: "22"}),
+		policy(2, "deforcer", models.SEVERITY_MEDIUM, "10.1.0.0/16", models.Port{Number: "53", Protocol: "UDP"}),
+		policy(3, "enforcer", models.SEVERITY_CRITICAL, "203.0.113.0/24", models.Port{Number: "1:65535", Protocol: enforcer.ProtocolAll}),
+		policy(4, "enforcer", "", "2001:db8::/32", models.Port{Number: "8000:9000"}),
+	}
+	rules := []models.ClassificationRule{
+		{Name: "udp", Expression: `protocol == "udp"`, Severity: models.SEVERITY_MEDIUM, Enabled: true},
+		{Name: "disabled", Expression: `port == 22`, Severity: models.SEVERITY_CRITICAL},
+		{Name: "broken", Expression: `port ==`, Severity: models.SEVERITY_CRITICAL, Enabled: true},
+	}
+	m := Compile(all, rules)
+
+	tests := []struct {
+		name     string
+		flow     Flow
+		decision string
+		severity models.SEVERITY
+		matches  []uint
+		rules    int
+	}{
+		{"enforcer", Flow{Source: "10.1.2.3", Port: "22", Protocol: "TCP", Direction: "Incoming"}, DecisionDrop, models.SEVERITY_HIGH, []uint{1}, 0},
+		{"no direction counts as incoming", Flow{Source: "10.1.2.3", Port: "22", Protocol: "tcp"}, DecisionDrop, models.SEVERITY_HIGH, []uint{1}, 0},
+		{"outgoing is not dropped", Flow{Source: "10.1.2.3", Port: "22", Protocol: "tcp", Direction: "Outgoing"}, DecisionAllow, models.SEVERITY_HIGH, []uint{1}, 0},
+		{"mapped IPv4 source", Flow{Source: "::ffff:10.0.0.1", Port: "22", Protocol: "tcp"}, DecisionDrop, models.SEVERITY_HIGH, []uint{1}, 0},
+		{"other protocol", Flow{Source: "10.1.2.3", Port: "22", Protocol: "udp"}, DecisionAllow, models.SEVERITY_MEDIUM, nil, 1},
+		{"no protocol", Flow{Source: "10.1.2.3", Port: "22"}, DecisionDrop, models.SEVERITY_HIGH, []uint{1}, 0},
+		{"deforcer", Flow{Source: "10.1.2.3", Port: "53", Protocol: "udp"}, DecisionAllow, models.SEVERITY_MEDIUM, []uint{2}, 1},
+		{"outside the addresses", Flow{Source: "192.0.2.1", Port: "22", Protocol: "tcp"}, DecisionAllow, models.SEVERITY_LOW, nil, 0},
+		{"all protocols without a port", Flow{Source: "203.0.113.5", Protocol: "icmp"}, DecisionDrop, models.SEVERITY_CRITICAL, []uint{3}, 0},
+		{"all protocols on any port", Flow{Source: "203.0.113.5", Port: "443", Protocol: "udp"}, DecisionDrop, models.SEVERITY_CRITICAL, []uint{3}, 1},
+		{"start of a port range", Flow{Source: "2001:db8::1", Port: "8000", Protocol: "tcp"}, DecisionDrop, models.SEVERITY_HIGH, []uint{4}, 0},
+		{"end of a port range", Flow{Source: "2001:db8::1", Port: "9000", Protocol: "tcp"}, DecisionDrop, models.SEVERITY_HIGH, []uint{4}, 0},
+		{"past a port range", Flow{Source: "2001:db8::1", Port: "9001", Protocol: "tcp"}, DecisionAllow, models.SEVERITY_LOW, nil, 0},
+		{"invalid source", Flow{Source: "not-an-ip", Port: "53", Protocol: "udp"}, DecisionAllow, models.SEVERITY_MEDIUM, nil, 1},
+	}
+	for _, test := range tests {
+		result := m.Evaluate(test.flow)
+		var matches []uint
+		for _, p := range result.Matches {
+			matches = append(matches, p.ID)
+		}
+		if result.Decision != test.decision || result.Severity != test.severity || len(result.Rules) != test.rules || !slices.Equal(matches, test.matches) {
+			t.Errorf("%s: got %s, %s, policies %v, %d rules; want %s, %s, policies %v, %d rules",
+				test.name, result.Decision, result.Severity, matches, len(result.Rules),
+				test.decision, test.severity, test.matches, test.rules)
+		}
+	}
+}
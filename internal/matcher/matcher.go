@@ -0,0 +1,80 @@
+package matcher
+
+import (
+	"log"
+	"net/http"
+	"net/netip"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanshal101/snapwall/database/psql"
+	"github.com/hanshal101/snapwall/internal/policies"
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
+)
+
+const (
+	DecisionDrop  = "drop"
+	DecisionAllow = "allow"
+
+	DirectionIncoming = "Incoming"
+)
+
+// Flow is a single observed or hypothetical connection, in the terms the
+// capture client reports them.
+type Flow struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Port        string `json:"port"`
+	Protocol    string `json:"protocol"`
+	Direction   string `json:"direction"`
+}
+
+// Result is what snapwall makes of a flow.
+type Result struct {
+	Matches  []models.Policy             `json:"matches"`
+	Rules    []models.ClassificationRule `json:"rules"`
+	Decision string                      `json:"decision"`
+	Severity models.SEVERITY             `json:"severity"`
+}
+
+// Load compiles the current policies and classification rules.
+func Load(db *gorm.DB) (*Matcher, error) {
+	all, err := policies.Load(db)
+	if err != nil {
+		return nil, err
+	}
+	var rules []models.ClassificationRule
+	if err := db.Where("enabled").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return Compile(all, rules), nil
+}
+
+// SimulateFlow answers "would this flow be blocked?" against the current
+// policies, using the same evaluation as the ingest server.
+func SimulateFlow(c *gin.Context) {
+	var flow Flow
+	if err := c.BindJSON(&flow); err != nil {
+		log.Printf("Error in binding flow: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error in binding flow"})
+		return
+	}
+
+	if _, err := netip.ParseAddr(flow.Source); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy flow", "errors": []policies.FieldError{{Field: "source", Message: "invalid IP address"}}})
+		return
+	}
+	if _, _, err := policies.ParsePort(flow.Port); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy flow", "errors": []policies.FieldError{{Field: "port", Message: err.Error()}}})
+		return
+	}
+
+	m, err := Load(psql.DB)
+	if err != nil {
+		log.Printf("Error in fetching policies: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching policies"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"flow": flow, "result": m.Evaluate(flow)})
+}
@@ -0,0 +1,232 @@
+package policies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanshal101/snapwall/database/psql"
+	"github.com/hanshal101/snapwall/internal/audit"
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
+)
+
+// MaxBulkOperations caps the size of one bulk request.
+const MaxBulkOperations = 1000
+
+const (
+	BulkOK      = "ok"
+	BulkInvalid = "invalid"
+	BulkFailed  = "failed"
+	BulkSkipped = "skipped"
+	// BulkConflict marks operations on policies owned by a component.
+	BulkConflict = "conflict"
+)
+
+// BulkOperation is one step of a bulk request. Op is create, update or
+// delete; ID is required for update and delete, Policy for create and update.
+type BulkOperation struct {
+	Op     string        `json:"op"`
+	ID     uint          `json:"id,omitempty"`
+	Policy PolicyRequest `json:"policy"`
+}
+
+type BulkRequest struct {
+	Operations []BulkOperation `json:"operations"`
+}
+
+// BulkResult reports what happened to one operation.
+type BulkResult struct {
+	Index  int          `json:"index"`
+	Op     string       `json:"op"`
+	ID     uint         `json:"id,omitempty"`
+	Status string       `json:"status"`
+	Error  string       `json:"error,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// validateBulk checks every operation without touching the database state and
+// reports whether all of them are valid.
+func validateBulk(db *gorm.DB, ops []BulkOperation, lockout Lockout) ([]BulkResult, bool) {
+	results := make([]BulkResult, len(ops))
+	valid := true
+	for i, op := range ops {
+		result := BulkResult{Index: i, Op: op.Op, ID: op.ID, Status: BulkOK}
+		switch op.Op {
+		case ActionCreate:
+			result.Errors = Validate(db, op.Policy, lockout)
+		case ActionUpdate:
+			if op.ID == 0 {
+				result.Errors = append(result.Errors, FieldError{Field: "id", Message: "is required"})
+			}
+			result.Errors = append(result.Errors, Validate(db, op.Policy, lockout)...)
+		case ActionDelete:
+			if op.ID == 0 {
+				result.Errors = append(result.Errors, FieldError{Field: "id", Message: "is required"})
+			}
+		default:
+			result.Errors = append(result.Errors, FieldError{Field: "op", Message: "must be one of create, update, delete"})
+		}
+		if len(result.Errors) > 0 {
+			result.Status = BulkInvalid
+			valid = false
+		}
+		results[i] = result
+	}
+	return results, valid
+}
+
+// checkBulkManaged marks the updates and deletes of managed policies as
+// conflicts and reports whether there were none. Policies that do not exist
+// are left for Bulk to report.
+func checkBulkManaged(db *gorm.DB, ops []BulkOperation, results []BulkResult) (bool, error) {
+	ok := true
+	for i, op := range ops {
+		if op.Op != ActionUpdate && op.Op != ActionDelete {
+			continue
+		}
+		managed, err := ManagedBy(db, op.ID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if managed != "" {
+			results[i].Status = BulkConflict
+			results[i].Error = fmt.Sprintf("policy %d is managed by %s", op.ID, managed)
+			ok = false
+		}
+	}
+	return ok, nil
+}
+
+// Bulk applies ops in order inside tx and audits each of them. It returns
+// the state of every touched policy before the batch, the IDs touched, and
+// the per-operation results. On the first failure the remaining operations
+// are skipped and the error returned; the caller must roll back.
+func Bulk(tx *gorm.DB, ops []BulkOperation, actor audit.Actor, results []BulkResult) ([]models.Policy, []uint, error) {
+	var before []models.Policy
+	var touched []uint
+	seen := make(map[uint]bool)
+	touch := func(previous models.Policy, id uint) {
+		if seen[id] {
+			return
+		}
+		seen[id] = true
+		touched = append(touched, id)
+		if previous.ID != 0 {
+			before = append(before, previous)
+		}
+	}
+
+	for i, op := range ops {
+		var err error
+		switch op.Op {
+		case ActionCreate:
+			var policy models.Policy
+			if policy, err = Create(tx, op.Policy); err == nil {
+				results[i].ID = policy.ID
+				touch(models.Policy{}, policy.ID)
+				err = audit.Record(tx, actor, ActionCreate, audit.ObjectPolicy, policy.ID, nil, policy)
+			}
+		case ActionUpdate:
+			var previous, policy models.Policy
+			if previous, policy, err = Update(tx, op.ID, op.Policy); err == nil {
+				touch(previous, op.ID)
+				err = audit.Record(tx, actor, ActionUpdate, audit.ObjectPolicy, policy.ID, previous, policy)
+			}
+		case ActionDelete:
+			var policy models.Policy
+			if policy, err = Delete(tx, op.ID); err == nil {
+				touch(policy, op.ID)
+				err = audit.Record(tx, actor, ActionDelete, audit.ObjectPolicy, policy.ID, policy, nil)
+			}
+		}
+
+		if err != nil {
+			results[i].Status = BulkFailed
+			results[i].Error = err.Error()
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				results[i].Error = fmt.Sprintf("policy %d does not exist", op.ID)
+			}
+			for j := i + 1; j < len(results); j++ {
+				results[j].Status = BulkSkipped
+			}
+			return nil, nil, fmt.Errorf("operation %d (%s): %w", i, op.Op, err)
+		}
+	}
+	return before, touched, nil
+}
+
+// BulkPolicies applies a list of create, update and delete operations in a
+// single transaction, then enforces the resulting changes in one pass.
+// Nothing is applied unless every operation succeeds.
+func BulkPolicies(c *gin.Context) {
+	var req BulkRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Printf("Error in binding bulk operations: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error in binding bulk operations"})
+		return
+	}
+	if len(req.Operations) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No operations given"})
+		return
+	}
+	if len(req.Operations) > MaxBulkOperations {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d operations are allowed", MaxBulkOperations)})
+		return
+	}
+
+	results, valid := validateBulk(psql.DB, req.Operations, LockoutFor(c))
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid operations", "applied": false, "results": results})
+		return
+	}
+	unmanaged, err := checkBulkManaged(psql.DB, req.Operations, results)
+	if err != nil {
+		log.Printf("Error in fetching policies: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching policies"})
+		return
+	}
+	if !unmanaged {
+		c.JSON(http.StatusConflict, gin.H{"error": "Managed policies cannot be changed", "applied": false, "results": results})
+		return
+	}
+
+	tx := psql.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			log.Printf("Transaction rolled back due to panic: %v", r)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+	}()
+
+	before, touched, err := Bulk(tx, req.Operations, audit.ActorFrom(c), results)
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error in bulk policy operations: %v", err)
+		c.JSON(errorStatus(err), gin.H{"error": "Error in bulk policy operations", "applied": false, "results": results})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("Error in committing bulk policy operations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in committing bulk policy operations"})
+		return
+	}
+
+	after, err := Load(psql.DB, touched)
+	if err != nil {
+		log.Printf("Error in reloading policies after bulk operations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in enforcement", "applied": true, "results": results})
+		return
+	}
+	ReenforceChanged(context.TODO(), before, after)
+
+	c.JSON(http.StatusOK, gin.H{"applied": true, "results": results})
+}
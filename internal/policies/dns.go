@@ -0,0 +1,128 @@
+package policies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hanshal101/snapwall/internal/audit"
+	"github.com/hanshal101/snapwall/internal/dns"
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
+)
+
+// ActionResolve is the audit action of a policy whose hostnames resolved to
+// different addresses.
+const ActionResolve = "resolve"
+
+// HostnameResolution is what a change in resolution is audited as.
+type HostnameResolution struct {
+	Name      string   `json:"name"`
+	Addresses []string `json:"addresses"`
+}
+
+// ResolveHostnames resolves the hostnames of policies whose DNS answer has
+// expired, checking every interval until ctx is done. When the addresses
+// change the policy is re-enforced, removing the rules of addresses no
+// longer resolved.
+func ResolveHostnames(ctx context.Context, db *gorm.DB, resolver *dns.Resolver, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := resolveDue(ctx, db, resolver); err != nil {
+			log.Printf("Error in resolving hostnames: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func resolveDue(ctx context.Context, db *gorm.DB, resolver *dns.Resolver) error {
+	var due []models.PolicyHostname
+	if err := db.Where("expires_at IS NULL OR expires_at <= ?", time.Now()).Find(&due).Error; err != nil {
+		return fmt.Errorf("error in fetching hostnames: %w", err)
+	}
+
+	for _, hostname := range due {
+		answer, err := resolver.Lookup(ctx, hostname.Name)
+		now := time.Now()
+		if err != nil {
+			// Keep enforcing what the name resolved to before and retry soon.
+			if !errors.Is(err, dns.ErrServer) {
+				log.Printf("Error in resolving %s: %v", hostname.Name, err)
+			}
+			expires := now.Add(dns.MinTTL)
+			hostname.ExpiresAt = &expires
+			hostname.Error = err.Error()
+			if err := saveHostname(db, hostname, nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var addresses []string
+		for _, addr := range answer.Addresses {
+			addresses = append(addresses, addr.String())
+		}
+		expires := now.Add(answer.TTL)
+		if fmt.Sprint(addresses) == fmt.Sprint(hostname.Addresses) {
+			hostname.ResolvedAt = &now
+			hostname.ExpiresAt = &expires
+			hostname.Error = ""
+			if err := saveHostname(db, hostname, nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		log.Printf("Hostname %s resolved to %v", hostname.Name, addresses)
+		previous, err := LoadOne(db, hostname.PolicyID)
+		if err != nil {
+			return fmt.Errorf("error fetching policy of %s: %w", hostname.Name, err)
+		}
+		before := &HostnameResolution{Name: hostname.Name, Addresses: hostname.Addresses}
+		hostname.Addresses = addresses
+		hostname.ResolvedAt = &now
+		hostname.ExpiresAt = &expires
+		hostname.Error = ""
+		if err := saveHostname(db, hostname, before); err != nil {
+			return err
+		}
+		current, err := LoadOne(db, hostname.PolicyID)
+		if err != nil {
+			return fmt.Errorf("error fetching policy of %s: %w", hostname.Name, err)
+		}
+		ReenforceChanged(ctx, []models.Policy{previous}, []models.Policy{current})
+	}
+	return nil
+}
+
+// saveHostname stores the resolution state of a hostname. A change of
+// addresses, given by its before state, is audited so the matcher cache of
+// the server picks it up.
+func saveHostname(db *gorm.DB, hostname models.PolicyHostname, before *HostnameResolution) error {
+	tx := db.Begin()
+	// Updates rather than Save: a hostname removed from its policy meanwhile
+	// must not be recreated.
+	if err := tx.Model(&models.PolicyHostname{ID: hostname.ID}).
+		Select("Addresses", "ResolvedAt", "ExpiresAt", "Error").
+		Updates(hostname).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error saving hostname %s: %w", hostname.Name, err)
+	}
+	if before != nil {
+		after := HostnameResolution{Name: hostname.Name, Addresses: hostname.Addresses}
+		if err := audit.Record(tx, audit.System("dns"), ActionResolve, audit.ObjectPolicy, hostname.PolicyID, before, after); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
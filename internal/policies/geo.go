@@ -0,0 +1,139 @@
+package policies
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hanshal101/snapwall/internal/audit"
+	"github.com/hanshal101/snapwall/internal/geoip"
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
+)
+
+// ActionExpand is the audit action of a policy whose countries and ASNs were
+// expanded into networks.
+const ActionExpand = "expand"
+
+// geoBatchSize bounds the networks inserted per statement.
+const geoBatchSize = 1000
+
+// GeoExpansion is what an expansion is audited as; the networks themselves
+// are too many to record.
+type GeoExpansion struct {
+	Countries []string `json:"countries,omitempty"`
+	ASNs      []uint32 `json:"asns,omitempty"`
+	Networks  int      `json:"networks"`
+}
+
+// ExpandGeo keeps the networks of policies with countries or ASNs up to date,
+// checking every interval until ctx is done. Policies are expanded again
+// when their selectors change or a GeoIP database file is replaced, and
+// their rules follow: networks that left the expansion stop being dropped.
+func ExpandGeo(ctx context.Context, db *gorm.DB, cityPath, asnPath string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var (
+		resolver *geoip.Resolver
+		version  string
+	)
+	for {
+		current, err := geoip.Version(cityPath, asnPath)
+		if err != nil {
+			log.Printf("Error in checking GeoIP databases: %v", err)
+		} else if current != version {
+			if r, err := geoip.NewResolver(cityPath, asnPath); err != nil {
+				log.Printf("Error in opening GeoIP databases: %v", err)
+			} else {
+				resolver, version = r, current
+				log.Printf("Loaded GeoIP databases %s", version)
+			}
+		}
+		if resolver != nil {
+			if err := expandPolicies(ctx, db, resolver, version); err != nil {
+				log.Printf("Error in expanding geo policies: %v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func expandPolicies(ctx context.Context, db *gorm.DB, resolver *geoip.Resolver, version string) error {
+	var all []models.Policy
+	if err := db.Where("countries IS NOT NULL OR asns IS NOT NULL OR geo_version <> ''").Find(&all).Error; err != nil {
+		return fmt.Errorf("error in fetching policies: %w", err)
+	}
+
+	for _, policy := range all {
+		want := ""
+		if len(policy.Countries) > 0 || len(policy.ASNs) > 0 {
+			want = fmt.Sprintf("%s|%v|%v", version, policy.Countries, policy.ASNs)
+		}
+		if policy.GeoVersion == want {
+			continue
+		}
+
+		var networks []string
+		if want != "" {
+			prefixes, err := resolver.Networks(policy.Countries, policy.ASNs)
+			if err != nil {
+				log.Printf("Error in expanding policy %s: %v", policy.Name, err)
+				continue
+			}
+			for _, prefix := range prefixes {
+				networks = append(networks, prefix.String())
+			}
+		}
+
+		previous, err := LoadOne(db, policy.ID)
+		if err != nil {
+			log.Printf("Error in fetching policy %s: %v", policy.Name, err)
+			continue
+		}
+		tx := db.Begin()
+		if err := writeGeoNetworks(tx, policy, networks, want); err != nil {
+			tx.Rollback()
+			log.Printf("Error in expanding policy %s: %v", policy.Name, err)
+			continue
+		}
+		if err := tx.Commit().Error; err != nil {
+			return err
+		}
+		log.Printf("Expanded policy %s to %d networks", policy.Name, len(networks))
+
+		current, err := LoadOne(db, policy.ID)
+		if err != nil {
+			log.Printf("Error in fetching policy %s: %v", policy.Name, err)
+			continue
+		}
+		ReenforceChanged(ctx, []models.Policy{previous}, []models.Policy{current})
+	}
+	return nil
+}
+
+func writeGeoNetworks(tx *gorm.DB, policy models.Policy, networks []string, version string) error {
+	if err := tx.Where("policy_id = ?", policy.ID).Delete(&models.PolicyGeoNetwork{}).Error; err != nil {
+		return fmt.Errorf("error deleting geo networks: %w", err)
+	}
+	rows := make([]models.PolicyGeoNetwork, len(networks))
+	for i, network := range networks {
+		rows[i] = models.PolicyGeoNetwork{PolicyID: policy.ID, Address: network}
+	}
+	if len(rows) > 0 {
+		if err := tx.CreateInBatches(rows, geoBatchSize).Error; err != nil {
+			return fmt.Errorf("error in creating geo networks: %w", err)
+		}
+	}
+	if err := tx.Model(&policy).Update("geo_version", version).Error; err != nil {
+		return fmt.Errorf("error saving policy: %w", err)
+	}
+	expansion := GeoExpansion{Countries: policy.Countries, ASNs: policy.ASNs, Networks: len(networks)}
+	return audit.Record(tx, audit.System("geoip"), ActionExpand, audit.ObjectPolicy, policy.ID, nil, expansion)
+}
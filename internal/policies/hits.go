@@ -0,0 +1,205 @@
+package policies
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanshal101/snapwall/database/psql"
+	"github.com/hanshal101/snapwall/internal/enforcer"
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
+)
+
+type counterValue struct {
+	packets, bytes uint64
+}
+
+// CollectHits samples the kernel counters every interval until ctx is done
+// and records what each policy's rules dropped since the previous sample.
+// A rule shared by several policies counts towards the oldest of them only,
+// as the kernel holds it once. Samples older than retention are deleted; a
+// retention of 0 keeps them all.
+func CollectHits(ctx context.Context, db *gorm.DB, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last map[string]counterValue
+	for {
+		current, err := readCounters()
+		if err != nil {
+			log.Printf("Error in reading rule counters: %v", err)
+		} else {
+			if last != nil {
+				if err := recordHits(db, last, current); err != nil {
+					log.Printf("Error in recording policy hits: %v", err)
+				}
+			}
+			last = current
+		}
+		if retention > 0 {
+			if err := db.Where("created_at < ?", time.Now().Add(-retention)).Delete(&models.PolicyHit{}).Error; err != nil {
+				log.Printf("Error in deleting old policy hits: %v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func readCounters() (map[string]counterValue, error) {
+	counters, err := enforcer.Counters()
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]counterValue, len(counters))
+	for _, counter := range counters {
+		current[counter.Rule.Key()] = counterValue{counter.Packets, counter.Bytes}
+	}
+	return current, nil
+}
+
+func recordHits(db *gorm.DB, last, current map[string]counterValue) error {
+	deltas := make(map[string]counterValue)
+	for key, count := range current {
+		previous := last[key]
+		// Counters start again from zero when a rule is re-created.
+		if count.packets < previous.packets {
+			previous = counterValue{}
+		}
+		if count.packets > previous.packets {
+			deltas[key] = counterValue{count.packets - previous.packets, count.bytes - previous.bytes}
+		}
+	}
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	allPolicies, err := Load(db)
+	if err != nil {
+		return err
+	}
+
+	hits := attributeHits(allPolicies, deltas)
+	if len(hits) == 0 {
+		return nil
+	}
+	return db.Create(&hits).Error
+}
+
+// attributeHits turns the counter deltas into a hit per enforcer policy.
+// A rule shared by several policies counts towards the one with the lowest
+// ID. deltas is consumed.
+func attributeHits(allPolicies []models.Policy, deltas map[string]counterValue) []models.PolicyHit {
+	sort.Slice(allPolicies, func(i, j int) bool { return allPolicies[i].ID < allPolicies[j].ID })
+	var hits []models.PolicyHit
+	for _, policy := range allPolicies {
+		if policy.Type != "enforcer" {
+			continue
+		}
+		hit := models.PolicyHit{PolicyID: policy.ID}
+		ips, ports := Resolve(policy)
+		for _, rule := range enforcer.Rules(policy, ips, ports) {
+			delta, ok := deltas[rule.Key()]
+			if !ok {
+				continue
+			}
+			// Claimed by this policy, so later ones do not count it again.
+			delete(deltas, rule.Key())
+			hit.Packets += delta.packets
+			hit.Bytes += delta.bytes
+		}
+		if hit.Packets > 0 {
+			hits = append(hits, hit)
+		}
+	}
+	return hits
+}
+
+type hitSummary struct {
+	PolicyID uint
+	Packets  uint64
+	LastHit  time.Time
+}
+
+// attachHits fills in the total hits and last hit time of each policy, over
+// the samples still retained.
+func attachHits(db *gorm.DB, policies []models.Policy) error {
+	if len(policies) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(policies))
+	for _, policy := range policies {
+		ids = append(ids, policy.ID)
+	}
+
+	var summaries []hitSummary
+	if err := db.Model(&models.PolicyHit{}).
+		Select("policy_id, SUM(packets) AS packets, MAX(created_at) AS last_hit").
+		Where("policy_id IN ?", ids).
+		Group("policy_id").
+		Scan(&summaries).Error; err != nil {
+		return err
+	}
+
+	byPolicy := make(map[uint]hitSummary, len(summaries))
+	for _, summary := range summaries {
+		byPolicy[summary.PolicyID] = summary
+	}
+	for i := range policies {
+		if summary, ok := byPolicy[policies[i].ID]; ok {
+			lastHit := summary.LastHit
+			policies[i].Hits = summary.Packets
+			policies[i].LastHit = &lastHit
+		}
+	}
+	return nil
+}
+
+// GetPolicyHits returns the hit samples of a policy with their totals. It
+// accepts since/until as RFC 3339 times.
+func GetPolicyHits(c *gin.Context) {
+	query := psql.DB.Where("policy_id = ?", c.Param("policyID"))
+	for param, cond := range map[string]string{"since": "created_at >= ?", "until": "created_at <= ?"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s: expected RFC 3339 time", param)})
+			return
+		}
+		query = query.Where(cond, t)
+	}
+
+	var hits []models.PolicyHit
+	if err := query.Order("created_at").Find(&hits).Error; err != nil {
+		log.Printf("Error in fetching policy hits: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching policy hits"})
+		return
+	}
+
+	var packets, bytes uint64
+	var lastHit *time.Time
+	for i := range hits {
+		packets += hits[i].Packets
+		bytes += hits[i].Bytes
+		lastHit = &hits[i].CreatedAt
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"policy_id": c.Param("policyID"),
+		"hits":      packets,
+		"bytes":     bytes,
+		"last_hit":  lastHit,
+		"samples":   hits,
+	})
+}
@@ -0,0 +1,38 @@
+package policies
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
+)
+
+func TestAttributeHits(t *testing.T) {
+	policy := func(id uint, kind string, addresses ...string) models.Policy {
+		p := models.Policy{Model: gorm.Model{ID: id}, Type: kind, Ports: []models.Port{{Number: "22"}}}
+		for _, address := range addresses {
+			p.IPs = append(p.IPs, models.IP{Address: address})
+		}
+		return p
+	}
+	all := []models.Policy{
+		policy(3, "enforcer", "10.0.0.1", "10.0.0.2"),
+		policy(1, "enforcer", "10.0.0.1"),
+		policy(2, "deforcer", "10.0.0.2"),
+	}
+	deltas := map[string]counterValue{
+		"10.0.0.1/32 tcp/22": {packets: 5, bytes: 300},
+		"10.0.0.2/32 tcp/22": {packets: 2, bytes: 120},
+		"10.0.0.3/32 tcp/22": {packets: 9, bytes: 540},
+	}
+
+	// The rule for 10.0.0.1 is shared and counts towards policy 1 only.
+	want := []models.PolicyHit{
+		{PolicyID: 1, Packets: 5, Bytes: 300},
+		{PolicyID: 3, Packets: 2, Bytes: 120},
+	}
+	if got := attributeHits(all, deltas); !reflect.DeepEqual(got, want) {
+		t.Errorf("attributeHits = %+v, want %+v", got, want)
+	}
+}
@@ -2,29 +2,50 @@ package policies
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
+	"net/netip"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hanshal101/snapwall/database/psql"
+	"github.com/hanshal101/snapwall/internal/audit"
 	"github.com/hanshal101/snapwall/internal/enforcer"
 	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
 )
 
 type PolicyRequest struct {
-	Name  string   `json:"name"`
-	IPs   []string `json:"ips"`
-	Ports []string `json:"ports"`
-	Type  string   `json:"type"`
+	Name          string   `json:"name"`
+	IPs           []string `json:"ips"`
+	Ports         []string `json:"ports"`
+	Type          string   `json:"type"`
+	Severity      string   `json:"severity,omitempty"`
+	AddressGroups []uint   `json:"address_groups"`
+	ServiceGroups []uint   `json:"service_groups"`
+	// Applications and ApplicationTags select registered applications whose
+	// ports the policy covers.
+	Applications    []uint   `json:"applications"`
+	ApplicationTags []string `json:"application_tags"`
+	// Countries and ASNs select sources by location, see models.Policy.
+	Countries []string `json:"countries,omitempty"`
+	ASNs      []uint32 `json:"asns,omitempty"`
+	// Hostnames are DNS names whose addresses the policy covers.
+	Hostnames []string `json:"hostnames,omitempty"`
 }
 
 func GetPolicies(c *gin.Context) {
-	var policies []models.Policy
-	if err := psql.DB.Preload("IPs").Preload("Ports").Find(&policies).Error; err != nil {
+	policies, err := Load(psql.DB)
+	if err != nil {
 		log.Printf("Error in fetching policies: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching policies"})
 		return
 	}
+	if err := attachHits(psql.DB, policies); err != nil {
+		log.Printf("Error in fetching policy hits: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching policy hits"})
+		return
+	}
 	c.JSON(http.StatusOK, policies)
 }
 
@@ -36,6 +57,11 @@ func CreatePolicies(c *gin.Context) {
 		return
 	}
 
+	if errs := Validate(psql.DB, req, LockoutFor(c)); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy", "errors": errs})
+		return
+	}
+
 	tx := psql.DB.Begin()
 	defer func() {
 		if r := recover(); r != nil {
@@ -45,51 +71,26 @@ func CreatePolicies(c *gin.Context) {
 		}
 	}()
 
-	policy := models.Policy{
-		Name: req.Name,
-		Type: req.Type,
-	}
-
-	if err := tx.Create(&policy).Error; err != nil {
+	policy, err := Create(tx, req)
+	if err != nil {
 		tx.Rollback()
 		log.Printf("Error in creating policy: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in creating policy"})
+		c.JSON(errorStatus(err), gin.H{"error": "Error in creating policy"})
 		return
 	}
 
-	var ips []models.IP
-	for _, ip := range req.IPs {
-		ip := models.IP{
-			PolicyID: policy.ID,
-			Address:  ip,
-		}
-		if err := tx.Create(&ip).Error; err != nil {
-			tx.Rollback()
-			log.Printf("Error in creating IPs: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in creating IPs"})
-			return
-		}
-		ips = append(ips, ip)
-	}
-
-	var ports []models.Port
-	for _, port := range req.Ports {
-		pt := models.Port{
-			PolicyID: policy.ID,
-			Number:   port,
-		}
-		if err := tx.Create(&pt).Error; err != nil {
-			tx.Rollback()
-			log.Printf("Error in creating Ports: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in creating Ports"})
-			return
-		}
-		ports = append(ports, pt)
+	if err := audit.Record(tx, audit.ActorFrom(c), ActionCreate, audit.ObjectPolicy, policy.ID, nil, policy); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing policy"})
+		return
 	}
 
+	ips, ports := Resolve(policy)
 	if err := enforcer.ReconcileEnforcer(context.TODO(), policy, ips, ports); err != nil {
-		log.Fatalf("Error in enforcement: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in creating Ports"})
+		tx.Rollback()
+		log.Printf("Error in enforcement: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in enforcement"})
 		return
 	}
 
@@ -105,7 +106,15 @@ func UpdatePolicies(c *gin.Context) {
 		return
 	}
 
+	if errs := Validate(psql.DB, policyReq, LockoutFor(c)); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy", "errors": errs})
+		return
+	}
+
 	policyID := c.Param("policyID")
+	if !checkUnmanaged(c, policyID) {
+		return
+	}
 
 	tx := psql.DB.Begin()
 	defer func() {
@@ -116,69 +125,86 @@ func UpdatePolicies(c *gin.Context) {
 		}
 	}()
 
-	var policy models.Policy
-	if err := tx.Preload("IPs").Preload("Ports").First(&policy, policyID).Error; err != nil {
+	before, after, err := Update(tx, policyID, policyReq)
+	if err != nil {
 		tx.Rollback()
-		log.Printf("Error fetching policy: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching policy"})
+		log.Printf("Error in updating policy: %v", err)
+		c.JSON(errorStatus(err), gin.H{"error": "Error in updating policy"})
 		return
 	}
 
-	policy.Name = policyReq.Name
-	policy.Type = policyReq.Type
-
-	if err := tx.Save(&policy).Error; err != nil {
+	if err := audit.Record(tx, audit.ActorFrom(c), ActionUpdate, audit.ObjectPolicy, after.ID, before, after); err != nil {
 		tx.Rollback()
-		log.Printf("Error saving policy: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving policy"})
+		log.Printf("Error in auditing policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing policy"})
 		return
 	}
 
-	if err := tx.Where("policy_id = ?", policyID).Delete(&models.IP{}).Error; err != nil {
+	if err := Reenforce(context.TODO(), before, after); err != nil {
 		tx.Rollback()
-		log.Printf("Error deleting IPs: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting IPs"})
+		log.Printf("Error in enforcement: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in enforcement"})
 		return
 	}
 
-	if err := tx.Where("policy_id = ?", policyID).Delete(&models.Port{}).Error; err != nil {
-		tx.Rollback()
-		log.Printf("Error deleting Ports: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting Ports"})
+	tx.Commit()
+	c.JSON(http.StatusOK, gin.H{"success": "Policy Updated Successfully"})
+}
+
+func DeletePolicy(c *gin.Context) {
+	id := c.Param("policyID")
+	if !checkUnmanaged(c, id) {
 		return
 	}
 
-	for _, ip := range policyReq.IPs {
-		if err := tx.Create(&models.IP{PolicyID: policy.ID, Address: ip}).Error; err != nil {
+	tx := psql.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
 			tx.Rollback()
-			log.Printf("Error in creating IPs: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in creating IPs"})
-			return
+			log.Printf("Transaction rolled back due to panic: %v", r)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		}
+	}()
+
+	policy, err := Delete(tx, id)
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error in deleting policy: %v", err)
+		c.JSON(errorStatus(err), gin.H{"error": "Error in deleting policy"})
+		return
 	}
 
-	for _, port := range policyReq.Ports {
-		if err := tx.Create(&models.Port{PolicyID: policy.ID, Number: port}).Error; err != nil {
-			tx.Rollback()
-			log.Printf("Error in creating Ports: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in creating Ports"})
-			return
-		}
+	if err := audit.Record(tx, audit.ActorFrom(c), ActionDelete, audit.ObjectPolicy, policy.ID, policy, nil); err != nil {
+		tx.Rollback()
+		log.Printf("Error in auditing policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing policy"})
+		return
 	}
 
+	ips, ports := Resolve(policy)
+	if err := enforcer.DeleteRule(context.TODO(), policy, ips, ports); err != nil {
+		tx.Rollback()
+		log.Printf("Error in Deleting rule: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in deleting tables"})
+		return
+	}
 	tx.Commit()
-	c.JSON(http.StatusOK, gin.H{"success": "Policy Updated Successfully"})
+	c.JSON(http.StatusOK, gin.H{"success": "Policy Deleted Successfully"})
 }
 
-func DeletePolicy(c *gin.Context) {
-	id := c.Param("policyID")
-
-	var policy models.Policy
-	if err := psql.DB.Where("id = ?", id).Preload("IPs").Preload("Ports").Find(&policy).Error; err != nil {
-		log.Printf("Error in fetching policies: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching policies"})
+func GetPolicyRevisions(c *gin.Context) {
+	var revisions []models.PolicyRevision
+	if err := psql.DB.Where("policy_id = ?", c.Param("policyID")).Order("revision").Find(&revisions).Error; err != nil {
+		log.Printf("Error in fetching revisions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in fetching revisions"})
 		return
 	}
+	c.JSON(http.StatusOK, revisions)
+}
+
+func RollbackPolicy(c *gin.Context) {
+	policyID := c.Param("policyID")
+	revision := c.Param("revision")
 
 	tx := psql.DB.Begin()
 	defer func() {
@@ -189,51 +215,99 @@ func DeletePolicy(c *gin.Context) {
 		}
 	}()
 
-	if err := tx.Where("id = ?", id).Delete(&models.Policy{}).Error; err != nil {
+	before, after, err := Rollback(tx, policyID, revision)
+	if err != nil {
 		tx.Rollback()
-		log.Printf("Error in deleting policy: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in deleting policy"})
+		log.Printf("Error in rolling back policy: %v", err)
+		c.JSON(errorStatus(err), gin.H{"error": "Error in rolling back policy"})
 		return
 	}
 
-	var ips []models.IP
-	if err := tx.Where("policy_id = ?", id).Delete(&models.IP{}).Find(&ips).Error; err != nil {
+	var previous interface{}
+	if before.ID != 0 {
+		previous = before
+	}
+	if err := audit.Record(tx, audit.ActorFrom(c), ActionRollback, audit.ObjectPolicy, after.ID, previous, after); err != nil {
 		tx.Rollback()
-		log.Printf("Error in deleting IPs: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in deleting IPs"})
+		log.Printf("Error in auditing policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in auditing policy"})
 		return
 	}
 
-	var ports []models.Port
-	if err := tx.Where("policy_id = ?", id).Delete(&models.Port{}).Find(&ports).Error; err != nil {
+	if err := Reenforce(context.TODO(), before, after); err != nil {
 		tx.Rollback()
-		log.Printf("Error in deleting Ports: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in deleting Ports"})
+		log.Printf("Error in enforcement: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in enforcement"})
 		return
 	}
 
-	if err := enforcer.DeleteRule(context.TODO(), policy, policy.IPs, policy.Ports); err != nil {
-		log.Fatalf("Error in Deleting rule: %v\n", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error in deleting tables"})
-		return
-	}
 	tx.Commit()
-	c.JSON(http.StatusOK, gin.H{"success": "Policy Deleted Successfully"})
+	c.JSON(http.StatusOK, gin.H{"success": "Policy Rolled Back Successfully"})
 }
 
+// GetPoliciesbyIPs returns every policy with an address entry, its own or
+// from an address group, that contains the given IP.
 func GetPoliciesbyIPs(c *gin.Context) {
-	ipAddr := c.Param("ipAddr")
-	var policies []models.Policy
+	// The lookup shares its wildcard with the per-policy routes, see
+	// router.PolicyRoutes.
+	ipAddr := c.Param("policyID")
+	addr, err := netip.ParseAddr(ipAddr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP address"})
+		return
+	}
+	addr = addr.Unmap()
 
-	if err := psql.DB.Joins("JOIN ips ON ips.policy_id = policies.id").
-		Where("ips.address = ?", ipAddr).
-		Find(&policies).Preload("Port").Error; err != nil {
+	all, err := Load(psql.DB)
+	if err != nil {
+		log.Printf("Error in fetching policies: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch policies"})
 		return
 	}
+
+	var policies []models.Policy
+	for _, policy := range all {
+		ips, _ := Resolve(policy)
+		for _, ip := range ips {
+			prefix, err := ParseAddress(ip.Address)
+			if err == nil && prefix.Contains(addr) {
+				policies = append(policies, policy)
+				break
+			}
+		}
+	}
 	if len(policies) < 1 {
 		c.JSON(http.StatusOK, gin.H{"success": "No policies found"})
 		return
 	}
 	c.JSON(http.StatusOK, policies)
 }
+
+// errorStatus maps a policy service error to the HTTP status to answer with.
+// checkUnmanaged answers requests to change a managed policy with 409,
+// as its component would undo the change, and reports whether the policy
+// may be changed.
+func checkUnmanaged(c *gin.Context, id interface{}) bool {
+	managed, err := ManagedBy(psql.DB, id)
+	if err != nil {
+		log.Printf("Error in fetching policy: %v", err)
+		c.JSON(errorStatus(err), gin.H{"error": "Error in fetching policy"})
+		return false
+	}
+	if managed != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Policy is managed by " + managed})
+		return false
+	}
+	return true
+}
+
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidReference), errors.Is(err, ErrInvalidPolicy):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
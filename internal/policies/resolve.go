@@ -0,0 +1,309 @@
+package policies
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/hanshal101/snapwall/internal/enforcer"
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
+)
+
+// Preload loads policies together with their own IPs and Ports and every
+// group and application they reference.
+func Preload(db *gorm.DB) *gorm.DB {
+	return db.Preload("IPs").
+		Preload("Ports").
+		Preload("AddressGroups.Addresses").
+		Preload("ServiceGroups.Services").
+		Preload("Applications.Tags").
+		Preload("ApplicationTags").
+		Preload("GeoNetworks").
+		Preload("Hostnames")
+}
+
+// Load fetches policies with everything Resolve needs, including the
+// applications selected through their tags.
+func Load(db *gorm.DB, conds ...interface{}) ([]models.Policy, error) {
+	var policies []models.Policy
+	if err := Preload(db).Find(&policies, conds...).Error; err != nil {
+		return nil, err
+	}
+	for i := range policies {
+		if err := loadTaggedApplications(db, &policies[i]); err != nil {
+			return nil, err
+		}
+	}
+	return policies, nil
+}
+
+// LoadOne is Load for a single policy.
+func LoadOne(db *gorm.DB, id interface{}) (models.Policy, error) {
+	var policy models.Policy
+	if err := Preload(db).First(&policy, id).Error; err != nil {
+		return policy, err
+	}
+	err := loadTaggedApplications(db, &policy)
+	return policy, err
+}
+
+func loadTaggedApplications(db *gorm.DB, policy *models.Policy) error {
+	policy.TaggedApplications = nil
+	if len(policy.ApplicationTags) == 0 {
+		return nil
+	}
+
+	var tags []string
+	for _, tag := range policy.ApplicationTags {
+		tags = append(tags, tag.Tag)
+	}
+
+	return db.Preload("Tags").
+		Where("id IN (?)", db.Model(&models.Tags{}).Select("application_id").Where("tag IN ?", tags)).
+		Find(&policy.TaggedApplications).Error
+}
+
+// Resolve flattens a policy's own entries, the entries of its groups, the
+// networks of its countries and ASNs, the addresses its hostnames resolve
+// to, and the ports of its applications into the IPs and Ports the enforcer
+// works on. Duplicates are dropped.
+func Resolve(policy models.Policy) ([]models.IP, []models.Port) {
+	var ips []models.IP
+	seenIPs := make(map[string]bool)
+	addIP := func(address string) {
+		if seenIPs[address] {
+			return
+		}
+		seenIPs[address] = true
+		ips = append(ips, models.IP{PolicyID: policy.ID, Address: address})
+	}
+
+	var ports []models.Port
+	seenPorts := make(map[string]bool)
+	addPort := func(port models.Port) {
+		if port.Number == "" {
+			return
+		}
+		key := enforcer.Protocol(port) + "/" + port.Number
+		if seenPorts[key] {
+			return
+		}
+		seenPorts[key] = true
+		ports = append(ports, port)
+	}
+
+	for _, ip := range policy.IPs {
+		addIP(ip.Address)
+	}
+	for _, group := range policy.AddressGroups {
+		for _, address := range group.Addresses {
+			addIP(address.Address)
+		}
+	}
+	for _, network := range policy.GeoNetworks {
+		addIP(network.Address)
+	}
+	for _, hostname := range policy.Hostnames {
+		for _, address := range hostname.Addresses {
+			addIP(address)
+		}
+	}
+
+	for _, port := range policy.Ports {
+		addPort(port)
+	}
+	for _, group := range policy.ServiceGroups {
+		for _, service := range group.Services {
+			addPort(models.Port{PolicyID: policy.ID, Number: service.Port, Protocol: service.Protocol})
+		}
+	}
+	for _, application := range policy.Applications {
+		addPort(models.Port{PolicyID: policy.ID, Number: application.Port})
+	}
+	for _, application := range policy.TaggedApplications {
+		addPort(models.Port{PolicyID: policy.ID, Number: application.Port})
+	}
+
+	return ips, ports
+}
+
+// Reenforce brings the kernel rules of a policy from its before state to its
+// after state: rules for entries that disappeared are removed and the after
+// state is enforced again. A set the after state no longer uses is destroyed.
+func Reenforce(ctx context.Context, before, after models.Policy) error {
+	oldIPs, oldPorts := Resolve(before)
+	newIPs, newPorts := Resolve(after)
+
+	if before.Type == "enforcer" {
+		keep := make(map[string]bool)
+		if after.Type == "enforcer" {
+			for _, rule := range enforcer.Rules(after, newIPs, newPorts) {
+				keep[rule.Key()] = true
+			}
+		}
+		var stale []enforcer.Rule
+		for _, rule := range enforcer.Rules(before, oldIPs, oldPorts) {
+			if !keep[rule.Key()] {
+				stale = append(stale, rule)
+			}
+		}
+		if err := enforcer.Remove(ctx, stale); err != nil {
+			return err
+		}
+		if enforcer.UsesSet(before) && !enforcer.UsesSet(after) {
+			if err := enforcer.DestroySet(before); err != nil {
+				return err
+			}
+		}
+	}
+
+	return enforcer.ReconcileEnforcer(ctx, after, newIPs, newPorts)
+}
+
+// ReenforceDependents re-enforces every policy that depended on a changed
+// object, given the dependents captured before and after the change.
+// Policies that stopped depending on the object are reloaded so their stale
+// rules are removed as well.
+func ReenforceDependents(ctx context.Context, db *gorm.DB, before, after []models.Policy) {
+	previous := make(map[uint]models.Policy)
+	for _, policy := range before {
+		previous[policy.ID] = policy
+	}
+
+	current := make(map[uint]bool)
+	for _, policy := range after {
+		current[policy.ID] = true
+	}
+
+	for _, policy := range before {
+		if current[policy.ID] {
+			continue
+		}
+		reloaded, err := LoadOne(db, policy.ID)
+		if err != nil {
+			log.Printf("Error in reloading policy %s: %v", policy.Name, err)
+			continue
+		}
+		after = append(after, reloaded)
+	}
+
+	for _, policy := range after {
+		if err := Reenforce(ctx, previous[policy.ID], policy); err != nil {
+			log.Printf("Error in re-enforcing policy %s: %v", policy.Name, err)
+		}
+	}
+}
+
+// ReenforceChanged compares two snapshots of all policies and updates the
+// kernel rules of every policy whose resolved entries or type changed.
+// Policies missing from after have their rules removed.
+func ReenforceChanged(ctx context.Context, before, after []models.Policy) {
+	previous := make(map[uint]models.Policy)
+	for _, policy := range before {
+		previous[policy.ID] = policy
+	}
+
+	for _, policy := range after {
+		old, ok := previous[policy.ID]
+		delete(previous, policy.ID)
+		if ok && old.Type == policy.Type && ruleKey(old) == ruleKey(policy) {
+			continue
+		}
+		if err := Reenforce(ctx, old, policy); err != nil {
+			log.Printf("Error in re-enforcing policy %s: %v", policy.Name, err)
+		}
+	}
+
+	for _, policy := range previous {
+		ips, ports := Resolve(policy)
+		if err := enforcer.DeleteRule(ctx, policy, ips, ports); err != nil {
+			log.Printf("Error in deleting rules of policy %s: %v", policy.Name, err)
+		}
+	}
+}
+
+// ruleKey summarises the resolved entries of a policy so two states can be
+// compared cheaply.
+func ruleKey(policy models.Policy) string {
+	ips, ports := Resolve(policy)
+	var keys []string
+	for _, ip := range ips {
+		keys = append(keys, "ip:"+ip.Address)
+	}
+	for _, port := range ports {
+		keys = append(keys, "port:"+enforcer.Protocol(port)+"/"+port.Number)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// DependentPolicies returns the policies referencing the given group through
+// the named many2many join table, loaded for resolution.
+func DependentPolicies(db *gorm.DB, joinTable, joinColumn string, groupID uint) ([]models.Policy, error) {
+	return Load(db, "id IN (?)", db.Table(joinTable).Select("policy_id").Where(fmt.Sprintf("%s = ?", joinColumn), groupID))
+}
+
+// ApplicationDependents returns the policies bound to an application either
+// directly or through one of the given tags, loaded for resolution.
+func ApplicationDependents(db *gorm.DB, applicationID uint, tags []string) ([]models.Policy, error) {
+	direct := db.Table("policy_applications").Select("policy_id").Where("application_id = ?", applicationID)
+	if len(tags) == 0 {
+		return Load(db, "id IN (?)", direct)
+	}
+	tagged := db.Model(&models.PolicyApplicationTag{}).Select("policy_id").Where("tag IN ?", tags)
+	return Load(db, "id IN (?) OR id IN (?)", direct, tagged)
+}
+
+func attachGroups(tx *gorm.DB, policy *models.Policy, addressGroups, serviceGroups []uint) error {
+	var ags []models.AddressGroup
+	if len(addressGroups) > 0 {
+		if err := tx.Find(&ags, addressGroups).Error; err != nil {
+			return err
+		}
+		if len(ags) != len(addressGroups) {
+			return fmt.Errorf("%w: unknown address group in %v", ErrInvalidReference, addressGroups)
+		}
+	}
+	if err := tx.Model(policy).Association("AddressGroups").Replace(ags); err != nil {
+		return err
+	}
+
+	var sgs []models.ServiceGroup
+	if len(serviceGroups) > 0 {
+		if err := tx.Find(&sgs, serviceGroups).Error; err != nil {
+			return err
+		}
+		if len(sgs) != len(serviceGroups) {
+			return fmt.Errorf("%w: unknown service group in %v", ErrInvalidReference, serviceGroups)
+		}
+	}
+	return tx.Model(policy).Association("ServiceGroups").Replace(sgs)
+}
+
+func attachApplications(tx *gorm.DB, policy *models.Policy, applications []uint, tags []string) error {
+	var apps []models.Application
+	if len(applications) > 0 {
+		if err := tx.Find(&apps, applications).Error; err != nil {
+			return err
+		}
+		if len(apps) != len(applications) {
+			return fmt.Errorf("%w: unknown application in %v", ErrInvalidReference, applications)
+		}
+	}
+	if err := tx.Model(policy).Association("Applications").Replace(apps); err != nil {
+		return err
+	}
+
+	if err := tx.Where("policy_id = ?", policy.ID).Delete(&models.PolicyApplicationTag{}).Error; err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if err := tx.Create(&models.PolicyApplicationTag{PolicyID: policy.ID, Tag: tag}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
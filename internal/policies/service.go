@@ -0,0 +1,316 @@
+package policies
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidReference is returned when a request points at a group or
+// application that does not exist.
+var ErrInvalidReference = errors.New("invalid reference")
+
+// ErrInvalidPolicy is returned for requests that fail Validate.
+var ErrInvalidPolicy = errors.New("invalid policy")
+
+const (
+	ActionCreate   = "create"
+	ActionUpdate   = "update"
+	ActionDelete   = "delete"
+	ActionRollback = "rollback"
+)
+
+// RequestFor turns a stored policy back into the request that would
+// recreate it. It is the format policy revisions are stored in.
+func RequestFor(policy models.Policy) PolicyRequest {
+	req := PolicyRequest{
+		Name:     policy.Name,
+		Type:     policy.Type,
+		Severity: string(policy.Severity),
+	}
+	for _, ip := range policy.IPs {
+		req.IPs = append(req.IPs, ip.Address)
+	}
+	for _, port := range policy.Ports {
+		req.Ports = append(req.Ports, port.Number)
+	}
+	for _, group := range policy.AddressGroups {
+		req.AddressGroups = append(req.AddressGroups, group.ID)
+	}
+	for _, group := range policy.ServiceGroups {
+		req.ServiceGroups = append(req.ServiceGroups, group.ID)
+	}
+	for _, application := range policy.Applications {
+		req.Applications = append(req.Applications, application.ID)
+	}
+	for _, tag := range policy.ApplicationTags {
+		req.ApplicationTags = append(req.ApplicationTags, tag.Tag)
+	}
+	req.Countries = policy.Countries
+	req.ASNs = policy.ASNs
+	for _, hostname := range policy.Hostnames {
+		req.Hostnames = append(req.Hostnames, hostname.Name)
+	}
+	return req
+}
+
+// ManagedBy returns the component that owns a policy, empty for operators'
+// policies. Managed policies are changed by their component only.
+func ManagedBy(db *gorm.DB, id interface{}) (string, error) {
+	var policy models.Policy
+	if err := db.Select("id", "managed").First(&policy, id).Error; err != nil {
+		return "", err
+	}
+	return policy.Managed, nil
+}
+
+// Create stores a new policy inside tx and returns it loaded for resolution.
+// Enforcement is left to the caller.
+func Create(tx *gorm.DB, req PolicyRequest) (models.Policy, error) {
+	policy := models.Policy{
+		Name:      req.Name,
+		Type:      req.Type,
+		Severity:  severity(req.Severity),
+		Countries: countries(req.Countries),
+		ASNs:      asns(req.ASNs),
+	}
+	if err := tx.Create(&policy).Error; err != nil {
+		return policy, fmt.Errorf("error in creating policy: %w", err)
+	}
+
+	if err := writeEntries(tx, &policy, req); err != nil {
+		return policy, err
+	}
+
+	policy, err := LoadOne(tx, policy.ID)
+	if err != nil {
+		return policy, fmt.Errorf("error fetching policy: %w", err)
+	}
+	return policy, recordRevision(tx, policy, ActionCreate)
+}
+
+// Update replaces the definition of a policy inside tx and returns its state
+// before and after the change.
+func Update(tx *gorm.DB, id interface{}, req PolicyRequest) (models.Policy, models.Policy, error) {
+	return update(tx, id, req, ActionUpdate)
+}
+
+func update(tx *gorm.DB, id interface{}, req PolicyRequest, action string) (models.Policy, models.Policy, error) {
+	before, err := LoadOne(tx, id)
+	if err != nil {
+		return before, before, fmt.Errorf("error fetching policy: %w", err)
+	}
+
+	policy := before
+	policy.Name = req.Name
+	policy.Type = req.Type
+	policy.Severity = severity(req.Severity)
+	policy.Countries = countries(req.Countries)
+	policy.ASNs = asns(req.ASNs)
+	// Changed selectors are expanded again by the reconciler.
+	if !sameSelectors(before, policy) {
+		policy.GeoVersion = ""
+		if err := tx.Where("policy_id = ?", policy.ID).Delete(&models.PolicyGeoNetwork{}).Error; err != nil {
+			return before, policy, fmt.Errorf("error deleting geo networks: %w", err)
+		}
+	}
+	if err := tx.Omit(clause.Associations).Save(&policy).Error; err != nil {
+		return before, policy, fmt.Errorf("error saving policy: %w", err)
+	}
+
+	if err := tx.Where("policy_id = ?", policy.ID).Delete(&models.IP{}).Error; err != nil {
+		return before, policy, fmt.Errorf("error deleting IPs: %w", err)
+	}
+	if err := tx.Where("policy_id = ?", policy.ID).Delete(&models.Port{}).Error; err != nil {
+		return before, policy, fmt.Errorf("error deleting Ports: %w", err)
+	}
+
+	if err := writeEntries(tx, &policy, req); err != nil {
+		return before, policy, err
+	}
+
+	after, err := LoadOne(tx, policy.ID)
+	if err != nil {
+		return before, after, fmt.Errorf("error fetching policy: %w", err)
+	}
+	return before, after, recordRevision(tx, after, action)
+}
+
+// Delete removes a policy and its entries inside tx and returns the state it
+// had, so the caller can remove its rules.
+func Delete(tx *gorm.DB, id interface{}) (models.Policy, error) {
+	policy, err := LoadOne(tx, id)
+	if err != nil {
+		return policy, fmt.Errorf("error fetching policy: %w", err)
+	}
+
+	if err := tx.Delete(&models.Policy{}, policy.ID).Error; err != nil {
+		return policy, fmt.Errorf("error in deleting policy: %w", err)
+	}
+	if err := tx.Where("policy_id = ?", policy.ID).Delete(&models.IP{}).Error; err != nil {
+		return policy, fmt.Errorf("error in deleting IPs: %w", err)
+	}
+	if err := tx.Where("policy_id = ?", policy.ID).Delete(&models.Port{}).Error; err != nil {
+		return policy, fmt.Errorf("error in deleting Ports: %w", err)
+	}
+	if err := tx.Where("policy_id = ?", policy.ID).Delete(&models.PolicyApplicationTag{}).Error; err != nil {
+		return policy, fmt.Errorf("error in deleting application tags: %w", err)
+	}
+	if err := tx.Where("policy_id = ?", policy.ID).Delete(&models.PolicyGeoNetwork{}).Error; err != nil {
+		return policy, fmt.Errorf("error in deleting geo networks: %w", err)
+	}
+	if err := tx.Where("policy_id = ?", policy.ID).Delete(&models.PolicyHostname{}).Error; err != nil {
+		return policy, fmt.Errorf("error in deleting hostnames: %w", err)
+	}
+	for _, association := range []string{"AddressGroups", "ServiceGroups", "Applications"} {
+		if err := tx.Model(&policy).Association(association).Clear(); err != nil {
+			return policy, fmt.Errorf("error in detaching %s: %w", association, err)
+		}
+	}
+
+	return policy, recordRevision(tx, policy, ActionDelete)
+}
+
+// Rollback restores a policy to the definition stored in one of its
+// revisions, undeleting it first if needed. It returns the state before and
+// after the rollback; a deleted policy has an empty before state.
+func Rollback(tx *gorm.DB, id interface{}, revision interface{}) (models.Policy, models.Policy, error) {
+	var rev models.PolicyRevision
+	if err := tx.Where("policy_id = ? AND revision = ?", id, revision).First(&rev).Error; err != nil {
+		return models.Policy{}, models.Policy{}, fmt.Errorf("error fetching revision: %w", err)
+	}
+
+	var req PolicyRequest
+	if err := json.Unmarshal(rev.Spec, &req); err != nil {
+		return models.Policy{}, models.Policy{}, fmt.Errorf("error decoding revision: %w", err)
+	}
+
+	var existing int64
+	if err := tx.Model(&models.Policy{}).Where("id = ?", rev.PolicyID).Count(&existing).Error; err != nil {
+		return models.Policy{}, models.Policy{}, fmt.Errorf("error fetching policy: %w", err)
+	}
+
+	if existing == 0 {
+		if err := tx.Unscoped().Model(&models.Policy{}).Where("id = ?", rev.PolicyID).Update("deleted_at", nil).Error; err != nil {
+			return models.Policy{}, models.Policy{}, fmt.Errorf("error restoring policy: %w", err)
+		}
+		_, after, err := update(tx, rev.PolicyID, req, ActionRollback)
+		return models.Policy{}, after, err
+	}
+
+	return update(tx, rev.PolicyID, req, ActionRollback)
+}
+
+// countries normalises country codes to upper case.
+func countries(codes []string) []string {
+	var upper []string
+	for _, code := range codes {
+		upper = append(upper, strings.ToUpper(code))
+	}
+	return upper
+}
+
+func asns(numbers []uint32) []uint32 {
+	if len(numbers) == 0 {
+		return nil
+	}
+	return numbers
+}
+
+func sameSelectors(a, b models.Policy) bool {
+	return fmt.Sprint(a.Countries) == fmt.Sprint(b.Countries) && fmt.Sprint(a.ASNs) == fmt.Sprint(b.ASNs)
+}
+
+// severity normalises the severity of a validated request.
+func severity(s string) models.SEVERITY {
+	parsed, _ := models.ParseSeverity(s)
+	return parsed
+}
+
+func writeEntries(tx *gorm.DB, policy *models.Policy, req PolicyRequest) error {
+	for _, ip := range req.IPs {
+		if err := tx.Create(&models.IP{PolicyID: policy.ID, Address: ip}).Error; err != nil {
+			return fmt.Errorf("error in creating IPs: %w", err)
+		}
+	}
+	for _, port := range req.Ports {
+		if err := tx.Create(&models.Port{PolicyID: policy.ID, Number: port}).Error; err != nil {
+			return fmt.Errorf("error in creating Ports: %w", err)
+		}
+	}
+	if err := writeHostnames(tx, policy, req.Hostnames); err != nil {
+		return err
+	}
+	if err := attachGroups(tx, policy, req.AddressGroups, req.ServiceGroups); err != nil {
+		return err
+	}
+	return attachApplications(tx, policy, req.Applications, req.ApplicationTags)
+}
+
+// writeHostnames makes the hostnames of a policy match names. Names it
+// already had keep what they resolved to; new ones are resolved by the
+// reconciler and enforce nothing until then.
+func writeHostnames(tx *gorm.DB, policy *models.Policy, names []string) error {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		name, _ = ParseHostname(name)
+		wanted[name] = true
+	}
+
+	var existing []models.PolicyHostname
+	if err := tx.Where("policy_id = ?", policy.ID).Find(&existing).Error; err != nil {
+		return fmt.Errorf("error fetching hostnames: %w", err)
+	}
+	for _, hostname := range existing {
+		if wanted[hostname.Name] {
+			delete(wanted, hostname.Name)
+			continue
+		}
+		if err := tx.Delete(&hostname).Error; err != nil {
+			return fmt.Errorf("error deleting hostnames: %w", err)
+		}
+	}
+
+	for _, name := range names {
+		name, _ = ParseHostname(name)
+		if !wanted[name] {
+			continue
+		}
+		delete(wanted, name)
+		if err := tx.Create(&models.PolicyHostname{PolicyID: policy.ID, Name: name}).Error; err != nil {
+			return fmt.Errorf("error in creating hostnames: %w", err)
+		}
+	}
+	return nil
+}
+
+func recordRevision(tx *gorm.DB, policy models.Policy, action string) error {
+	spec, err := json.Marshal(RequestFor(policy))
+	if err != nil {
+		return fmt.Errorf("error encoding revision: %w", err)
+	}
+
+	var latest int
+	if err := tx.Model(&models.PolicyRevision{}).
+		Where("policy_id = ?", policy.ID).
+		Select("COALESCE(MAX(revision), 0)").
+		Scan(&latest).Error; err != nil {
+		return fmt.Errorf("error fetching revisions: %w", err)
+	}
+
+	revision := models.PolicyRevision{
+		PolicyID: policy.ID,
+		Revision: latest + 1,
+		Action:   action,
+		Spec:     spec,
+	}
+	if err := tx.Create(&revision).Error; err != nil {
+		return fmt.Errorf("error in creating revision: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,514 @@
+package policies
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hanshal101/snapwall/database/psql"
+	"github.com/hanshal101/snapwall/internal/dns"
+	"github.com/hanshal101/snapwall/internal/geoip"
+	"github.com/hanshal101/snapwall/models"
+	"gorm.io/gorm"
+)
+
+// Types lists the policy types the enforcer acts on.
+var Types = []string{"enforcer", "deforcer"}
+
+// FieldError describes one problem with one field of a request. Field uses
+// the JSON name, with an index for list entries, e.g. "ips[2]".
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// Lockout describes the access a policy must not cut off: the address of the
+// operator making the change and the ports snapwall itself is managed on.
+// Locate and LookupHost, when set, find where the operator is and what a
+// policy's hostnames resolve to, so that countries, ASNs and hostnames are
+// checked as well.
+type Lockout struct {
+	ClientIP   string
+	Ports      []string
+	Locate     func(ip string) geoip.Location
+	LookupHost func(host string) ([]netip.Addr, error)
+}
+
+// ManagementPorts are SSH and the API port from APP_ADDRESS.
+func ManagementPorts() []string {
+	ports := []string{"22"}
+	if _, port, err := net.SplitHostPort(os.Getenv("APP_ADDRESS")); err == nil && port != "" {
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+// LockoutFor protects the caller of an API request on the management ports.
+// Its location comes from the GeoIP databases of GEOIP_CITY_DB and
+// GEOIP_ASN_DB, and hostnames are resolved with DNS_SERVERS, as the
+// reconciler does.
+func LockoutFor(c *gin.Context) Lockout {
+	return Lockout{
+		ClientIP:   c.ClientIP(),
+		Ports:      ManagementPorts(),
+		Locate:     lockoutGeo.locate,
+		LookupHost: lookupHost,
+	}
+}
+
+// lookupTimeout bounds the resolution of a policy's hostnames on validation.
+const lookupTimeout = 5 * time.Second
+
+func lookupHost(host string) ([]netip.Addr, error) {
+	var servers []string
+	if value := os.Getenv("DNS_SERVERS"); value != "" {
+		for _, server := range strings.Split(value, ",") {
+			servers = append(servers, strings.TrimSpace(server))
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	answer, err := dns.NewResolver(servers).Lookup(ctx, host)
+	return answer.Addresses, err
+}
+
+// geoCache keeps the GeoIP databases open between requests, reopening them
+// when their files are replaced.
+type geoCache struct {
+	mu       sync.Mutex
+	resolver *geoip.Resolver
+	version  string
+}
+
+var lockoutGeo geoCache
+
+func (g *geoCache) locate(ip string) geoip.Location {
+	cityPath, asnPath := os.Getenv("GEOIP_CITY_DB"), os.Getenv("GEOIP_ASN_DB")
+	if cityPath == "" && asnPath == "" {
+		return geoip.Location{}
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	version, err := geoip.Version(cityPath, asnPath)
+	if err != nil {
+		log.Printf("Error in checking GeoIP databases: %v", err)
+	} else if version != g.version {
+		if resolver, err := geoip.NewResolver(cityPath, asnPath); err != nil {
+			log.Printf("Error in opening GeoIP databases: %v", err)
+		} else {
+			g.resolver, g.version = resolver, version
+		}
+	}
+	return g.resolver.Lookup(ip)
+}
+
+// InvalidError wraps the field errors of a rejected request.
+func InvalidError(name string, errs []FieldError) error {
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+	return fmt.Errorf("%w: policy %q: %s", ErrInvalidPolicy, name, strings.Join(msgs, "; "))
+}
+
+// ParseAddress parses a policy address, which is either a single IP or a
+// CIDR, into a prefix.
+func ParseAddress(address string) (netip.Prefix, error) {
+	if strings.Contains(address, "/") {
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			return prefix, fmt.Errorf("invalid CIDR %q", address)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP address %q", address)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// ParseHostname checks that a hostname is a fully qualified DNS name and
+// returns it in lower case without a trailing dot.
+func ParseHostname(hostname string) (string, error) {
+	name := strings.ToLower(strings.TrimSuffix(hostname, "."))
+	if _, err := netip.ParseAddr(name); err == nil {
+		return "", fmt.Errorf("%q is an IP address, not a hostname", hostname)
+	}
+	labels := strings.Split(name, ".")
+	if len(name) > 253 || len(labels) < 2 {
+		return "", fmt.Errorf("invalid hostname %q: expected a fully qualified name", hostname)
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", fmt.Errorf("invalid hostname %q", hostname)
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+				return "", fmt.Errorf("invalid hostname %q", hostname)
+			}
+		}
+	}
+	return name, nil
+}
+
+// ParsePort parses a port number or an iptables style "low:high" range.
+func ParsePort(port string) (uint16, uint16, error) {
+	lowText, highText, isRange := strings.Cut(port, ":")
+	low, err := strconv.ParseUint(lowText, 10, 16)
+	if err != nil || low == 0 {
+		return 0, 0, fmt.Errorf("invalid port %q: expected 1-65535", port)
+	}
+	if !isRange {
+		return uint16(low), uint16(low), nil
+	}
+	high, err := strconv.ParseUint(highText, 10, 16)
+	if err != nil || high == 0 {
+		return 0, 0, fmt.Errorf("invalid port range %q: expected 1-65535", port)
+	}
+	if high < low {
+		return 0, 0, fmt.Errorf("invalid port range %q: start is after end", port)
+	}
+	return uint16(low), uint16(high), nil
+}
+
+// ValidProtocol reports whether a protocol can be used in a rule. An empty
+// protocol means tcp.
+func ValidProtocol(protocol string) bool {
+	switch strings.ToLower(protocol) {
+	case "", "tcp", "udp":
+		return true
+	}
+	return false
+}
+
+// Validate checks a policy request and returns every problem found. With a
+// non-nil db, referenced groups and applications must exist, and the ports
+// of service groups and of applications, referenced directly or by tag,
+// take part in the lockout check.
+func Validate(db *gorm.DB, req PolicyRequest, lockout Lockout) []FieldError {
+	var errs []FieldError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if strings.TrimSpace(req.Name) == "" {
+		add("name", "is required")
+	} else if len(req.Name) > 255 {
+		add("name", "must be at most 255 characters")
+	}
+
+	validType := false
+	for _, t := range Types {
+		if req.Type == t {
+			validType = true
+		}
+	}
+	if !validType {
+		add("type", "must be one of %s", strings.Join(Types, ", "))
+	}
+
+	if _, ok := models.ParseSeverity(req.Severity); req.Severity != "" && !ok {
+		add("severity", "must be one of %s", severityNames())
+	}
+
+	var prefixes []netip.Prefix
+	seenIPs := make(map[netip.Prefix]int)
+	for i, ip := range req.IPs {
+		field := fmt.Sprintf("ips[%d]", i)
+		prefix, err := ParseAddress(ip)
+		if err != nil {
+			add(field, "%v", err)
+			continue
+		}
+		if first, ok := seenIPs[prefix]; ok {
+			add(field, "duplicates ips[%d]", first)
+			continue
+		}
+		seenIPs[prefix] = i
+		prefixes = append(prefixes, prefix)
+	}
+
+	ports := append([]string(nil), req.Ports...)
+	seenPorts := make(map[string]int)
+	for i, port := range req.Ports {
+		field := fmt.Sprintf("ports[%d]", i)
+		if _, _, err := ParsePort(port); err != nil {
+			add(field, "%v", err)
+			continue
+		}
+		if first, ok := seenPorts[port]; ok {
+			add(field, "duplicates ports[%d]", first)
+			continue
+		}
+		seenPorts[port] = i
+	}
+
+	checkDuplicateIDs("address_groups", req.AddressGroups, add)
+	checkDuplicateIDs("service_groups", req.ServiceGroups, add)
+	checkDuplicateIDs("applications", req.Applications, add)
+	seenTags := make(map[string]int)
+	for i, tag := range req.ApplicationTags {
+		field := fmt.Sprintf("application_tags[%d]", i)
+		if strings.TrimSpace(tag) == "" {
+			add(field, "is empty")
+			continue
+		}
+		if first, ok := seenTags[tag]; ok {
+			add(field, "duplicates application_tags[%d]", first)
+			continue
+		}
+		seenTags[tag] = i
+	}
+
+	seenCountries := make(map[string]int)
+	for i, code := range req.Countries {
+		field := fmt.Sprintf("countries[%d]", i)
+		code = strings.ToUpper(code)
+		if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+			add(field, "%q is not an ISO 3166 country code", req.Countries[i])
+			continue
+		}
+		if first, ok := seenCountries[code]; ok {
+			add(field, "duplicates countries[%d]", first)
+			continue
+		}
+		seenCountries[code] = i
+	}
+	seenASNs := make(map[uint32]int)
+	for i, asn := range req.ASNs {
+		field := fmt.Sprintf("asns[%d]", i)
+		if asn == 0 {
+			add(field, "must be a positive AS number")
+			continue
+		}
+		if first, ok := seenASNs[asn]; ok {
+			add(field, "duplicates asns[%d]", first)
+			continue
+		}
+		seenASNs[asn] = i
+	}
+
+	seenHostnames := make(map[string]int)
+	var hostnames []string
+	for i, hostname := range req.Hostnames {
+		field := fmt.Sprintf("hostnames[%d]", i)
+		name, err := ParseHostname(hostname)
+		if err != nil {
+			add(field, "%v", err)
+			continue
+		}
+		if first, ok := seenHostnames[name]; ok {
+			add(field, "duplicates hostnames[%d]", first)
+			continue
+		}
+		seenHostnames[name] = i
+		hostnames = append(hostnames, name)
+	}
+
+	if len(req.IPs) == 0 && len(req.AddressGroups) == 0 && len(req.Countries) == 0 && len(req.ASNs) == 0 && len(req.Hostnames) == 0 {
+		add("ips", "at least one IP, address group, country, ASN or hostname is required")
+	}
+	if len(req.Ports) == 0 && len(req.ServiceGroups) == 0 && len(req.Applications) == 0 && len(req.ApplicationTags) == 0 {
+		add("ports", "at least one port, service group or application is required")
+	}
+
+	if db != nil {
+		var addressGroups []models.AddressGroup
+		if len(req.AddressGroups) > 0 {
+			if err := db.Preload("Addresses").Find(&addressGroups, req.AddressGroups).Error; err != nil {
+				add("address_groups", "could not be checked: %v", err)
+			}
+			checkMissing("address_groups", req.AddressGroups, len(addressGroups), func(i int) uint { return addressGroups[i].ID }, add)
+			for _, group := range addressGroups {
+				for _, address := range group.Addresses {
+					if prefix, err := ParseAddress(address.Address); err == nil {
+						prefixes = append(prefixes, prefix)
+					}
+				}
+			}
+		}
+
+		var serviceGroups []models.ServiceGroup
+		if len(req.ServiceGroups) > 0 {
+			if err := db.Preload("Services").Find(&serviceGroups, req.ServiceGroups).Error; err != nil {
+				add("service_groups", "could not be checked: %v", err)
+			}
+			checkMissing("service_groups", req.ServiceGroups, len(serviceGroups), func(i int) uint { return serviceGroups[i].ID }, add)
+			for _, group := range serviceGroups {
+				for _, service := range group.Services {
+					ports = append(ports, service.Port)
+				}
+			}
+		}
+
+		var applications []models.Application
+		if len(req.Applications) > 0 {
+			if err := db.Find(&applications, req.Applications).Error; err != nil {
+				add("applications", "could not be checked: %v", err)
+			}
+			checkMissing("applications", req.Applications, len(applications), func(i int) uint { return applications[i].ID }, add)
+			for _, application := range applications {
+				ports = append(ports, application.Port)
+			}
+		}
+
+		if len(req.ApplicationTags) > 0 {
+			var tagged []models.Application
+			if err := db.Where("id IN (?)", db.Model(&models.Tags{}).Select("application_id").Where("tag IN ?", req.ApplicationTags)).
+				Find(&tagged).Error; err != nil {
+				add("application_tags", "could not be checked: %v", err)
+			}
+			for _, application := range tagged {
+				ports = append(ports, application.Port)
+			}
+		}
+	}
+
+	if req.Type == "enforcer" {
+		sources := lockoutSources{prefixes: prefixes, countries: req.Countries, asns: req.ASNs, hostnames: hostnames}
+		checkLockout(sources, ports, lockout, add)
+	}
+
+	return errs
+}
+
+func severityNames() string {
+	names := make([]string, 0, len(models.Severities))
+	for _, severity := range models.Severities {
+		names = append(names, string(severity))
+	}
+	return strings.Join(names, ", ")
+}
+
+func checkDuplicateIDs(field string, ids []uint, add func(string, string, ...interface{})) {
+	seen := make(map[uint]int)
+	for i, id := range ids {
+		if first, ok := seen[id]; ok {
+			add(fmt.Sprintf("%s[%d]", field, i), "duplicates %s[%d]", field, first)
+			continue
+		}
+		seen[id] = i
+	}
+}
+
+func checkMissing(field string, ids []uint, found int, idAt func(int) uint, add func(string, string, ...interface{})) {
+	exists := make(map[uint]bool)
+	for i := 0; i < found; i++ {
+		exists[idAt(i)] = true
+	}
+	for i, id := range ids {
+		if !exists[id] {
+			add(fmt.Sprintf("%s[%d]", field, i), "%d does not exist", id)
+		}
+	}
+}
+
+// lockoutSources are the sources a policy selects, as far as the lockout
+// check goes.
+type lockoutSources struct {
+	prefixes  []netip.Prefix
+	countries []string
+	asns      []uint32
+	hostnames []string
+}
+
+// checkLockout rejects enforcer policies that would drop the operator's own
+// traffic, or everyone's, on a management port. Countries and ASNs are
+// checked against the operator's location, and hostnames by what they
+// resolve to now; either is skipped when the lockout cannot tell.
+func checkLockout(sources lockoutSources, ports []string, lockout Lockout, add func(string, string, ...interface{})) {
+	var protected []string
+	for _, port := range ports {
+		low, high, err := ParsePort(port)
+		if err != nil {
+			continue
+		}
+		for _, managed := range lockout.Ports {
+			n, err := strconv.ParseUint(managed, 10, 16)
+			if err == nil && uint16(n) >= low && uint16(n) <= high {
+				protected = append(protected, managed)
+			}
+		}
+	}
+	if len(protected) == 0 {
+		return
+	}
+	on := strings.Join(protected, ", ")
+
+	client, clientErr := netip.ParseAddr(lockout.ClientIP)
+	client = client.Unmap()
+	for _, prefix := range sources.prefixes {
+		if prefix.Bits() == 0 {
+			add("ips", "blocking %s on port %s would lock everyone out", prefix, on)
+			return
+		}
+		if clientErr == nil && prefix.Contains(client) {
+			add("ips", "blocking %s on port %s would lock out your own address %s", prefix, on, lockout.ClientIP)
+			return
+		}
+	}
+	if clientErr != nil {
+		return
+	}
+
+	if lockout.LookupHost != nil {
+		for _, hostname := range sources.hostnames {
+			addrs, err := lockout.LookupHost(hostname)
+			if err != nil {
+				continue
+			}
+			for _, addr := range addrs {
+				if addr.Unmap() == client {
+					add("hostnames", "blocking %s on port %s would lock out your own address %s", hostname, on, lockout.ClientIP)
+					return
+				}
+			}
+		}
+	}
+
+	if lockout.Locate != nil && (len(sources.countries) > 0 || len(sources.asns) > 0) {
+		location := lockout.Locate(client.String())
+		for _, code := range sources.countries {
+			if location.Country != "" && strings.EqualFold(code, location.Country) {
+				add("countries", "blocking %s on port %s would lock out your own address %s", location.Country, on, lockout.ClientIP)
+				return
+			}
+		}
+		for _, asn := range sources.asns {
+			if location.ASN != 0 && asn == location.ASN {
+				add("asns", "blocking AS%d on port %s would lock out your own address %s", asn, on, lockout.ClientIP)
+				return
+			}
+		}
+	}
+}
+
+// ValidatePolicy checks a policy request without applying it.
+func ValidatePolicy(c *gin.Context) {
+	var req PolicyRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error in binding policies"})
+		return
+	}
+
+	errs := Validate(psql.DB, req, LockoutFor(c))
+	if len(errs) > 0 {
+		c.JSON(http.StatusOK, gin.H{"valid": false, "errors": errs})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"valid": true, "errors": []FieldError{}})
+}
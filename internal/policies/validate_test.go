@@ -0,0 +1,119 @@
+package policies
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/hanshal101/snapwall/internal/geoip"
+)
+
+func TestCheckLockout(t *testing.T) {
+	lockout := Lockout{
+		ClientIP: "198.51.100.7",
+		Ports:    []string{"22", "8888"},
+		Locate: func(ip string) geoip.Location {
+			if ip == "198.51.100.7" {
+				return geoip.Location{Country: "NL", ASN: 64496}
+			}
+			return geoip.Location{}
+		},
+		LookupHost: func(host string) ([]netip.Addr, error) {
+			switch host {
+			case "office.example.com":
+				return []netip.Addr{netip.MustParseAddr("198.51.100.7")}, nil
+			case "other.example.com":
+				return []netip.Addr{netip.MustParseAddr("203.0.113.1")}, nil
+			}
+			return nil, errors.New("no such host")
+		},
+	}
+	prefix := func(s string) []netip.Prefix { return []netip.Prefix{netip.MustParsePrefix(s)} }
+
+	tests := []struct {
+		name    string
+		sources lockoutSources
+		ports   []string
+		lockout Lockout
+		field   string
+	}{
+		{"other address", lockoutSources{prefixes: prefix("203.0.113.0/24")}, []string{"22"}, lockout, ""},
+		{"own address", lockoutSources{prefixes: prefix("198.51.100.0/24")}, []string{"22"}, lockout, "ips"},
+		{"own address on another port", lockoutSources{prefixes: prefix("198.51.100.0/24")}, []string{"80"}, lockout, ""},
+		{"range over a management port", lockoutSources{prefixes: prefix("198.51.100.7/32")}, []string{"8000:9000"}, lockout, "ips"},
+		{"everyone", lockoutSources{prefixes: prefix("0.0.0.0/0")}, []string{"22"}, Lockout{Ports: []string{"22"}}, "ips"},
+		{"own country", lockoutSources{countries: []string{"nl"}}, []string{"22"}, lockout, "countries"},
+		{"other country", lockoutSources{countries: []string{"DE"}}, []string{"22"}, lockout, ""},
+		{"own ASN", lockoutSources{asns: []uint32{64496}}, []string{"22"}, lockout, "asns"},
+		{"hostname of own address", lockoutSources{hostnames: []string{"office.example.com"}}, []string{"22"}, lockout, "hostnames"},
+		{"hostname elsewhere", lockoutSources{hostnames: []string{"other.example.com"}}, []string{"22"}, lockout, ""},
+		{"hostname that does not resolve", lockoutSources{hostnames: []string{"gone.example.com"}}, []string{"22"}, lockout, ""},
+		{"country without a location", lockoutSources{countries: []string{"NL"}}, []string{"22"}, Lockout{ClientIP: "198.51.100.7", Ports: []string{"22"}}, ""},
+	}
+	for _, test := range tests {
+		var errs []FieldError
+		add := func(field, format string, args ...interface{}) {
+			errs = append(errs, FieldError{Field: field})
+		}
+		checkLockout(test.sources, test.ports, test.lockout, add)
+		switch {
+		case test.field == "" && len(errs) > 0:
+			t.Errorf("%s: rejected on %s", test.name, errs[0].Field)
+		case test.field != "" && (len(errs) != 1 || errs[0].Field != test.field):
+			t.Errorf("%s: errors = %+v, want one on %s", test.name, errs, test.field)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := PolicyRequest{Name: "block", Type: "enforcer", IPs: []string{"203.0.113.0/24"}, Ports: []string{"80"}}
+	lockout := Lockout{ClientIP: "198.51.100.7", Ports: []string{"22"}}
+
+	tests := []struct {
+		name   string
+		change func(*PolicyRequest)
+		fields []string
+	}{
+		{"valid", func(*PolicyRequest) {}, nil},
+		{"no name", func(r *PolicyRequest) { r.Name = " " }, []string{"name"}},
+		{"unknown type", func(r *PolicyRequest) { r.Type = "drop" }, []string{"type"}},
+		{"severity in any case", func(r *PolicyRequest) { r.Severity = "critical" }, nil},
+		{"unknown severity", func(r *PolicyRequest) { r.Severity = "urgent" }, []string{"severity"}},
+		{"invalid IP", func(r *PolicyRequest) { r.IPs = []string{"10.0.0.256"} }, []string{"ips[0]"}},
+		{"invalid CIDR", func(r *PolicyRequest) { r.IPs = []string{"10.0.0.0/33"} }, []string{"ips[0]"}},
+		{"duplicate IP once masked", func(r *PolicyRequest) { r.IPs = []string{"10.0.0.0/8", "10.1.0.0/8"} }, []string{"ips[1]"}},
+		{"port 0", func(r *PolicyRequest) { r.Ports = []string{"0"} }, []string{"ports[0]"}},
+		{"port past 65535", func(r *PolicyRequest) { r.Ports = []string{"65536"} }, []string{"ports[0]"}},
+		{"reversed range", func(r *PolicyRequest) { r.Ports = []string{"90:80"} }, []string{"ports[0]"}},
+		{"range", func(r *PolicyRequest) { r.Ports = []string{"1:65535"} }, nil},
+		{"duplicate port", func(r *PolicyRequest) { r.Ports = []string{"80", "80"} }, []string{"ports[1]"}},
+		{"no sources", func(r *PolicyRequest) { r.IPs = nil }, []string{"ips"}},
+		{"countries alone", func(r *PolicyRequest) { r.IPs, r.Countries = nil, []string{"nl", "DE"} }, nil},
+		{"invalid country", func(r *PolicyRequest) { r.Countries = []string{"NLD"} }, []string{"countries[0]"}},
+		{"duplicate country", func(r *PolicyRequest) { r.Countries = []string{"NL", "nl"} }, []string{"countries[1]"}},
+		{"ASN 0", func(r *PolicyRequest) { r.ASNs = []uint32{0} }, []string{"asns[0]"}},
+		{"hostname", func(r *PolicyRequest) { r.Hostnames = []string{"Example.COM."} }, nil},
+		{"IP as hostname", func(r *PolicyRequest) { r.Hostnames = []string{"192.0.2.1"} }, []string{"hostnames[0]"}},
+		{"bare hostname", func(r *PolicyRequest) { r.Hostnames = []string{"localhost"} }, []string{"hostnames[0]"}},
+		{"duplicate hostname", func(r *PolicyRequest) { r.Hostnames = []string{"example.com", "EXAMPLE.com"} }, []string{"hostnames[1]"}},
+		{"no ports", func(r *PolicyRequest) { r.Ports = nil }, []string{"ports"}},
+		{"duplicate group", func(r *PolicyRequest) { r.AddressGroups = []uint{1, 1} }, []string{"address_groups[1]"}},
+		{"empty tag", func(r *PolicyRequest) { r.ApplicationTags = []string{""} }, []string{"application_tags[0]"}},
+		{"own address on a management port", func(r *PolicyRequest) { r.IPs, r.Ports = []string{"198.51.100.0/24"}, []string{"22"} }, []string{"ips"}},
+		{"deforcers cannot lock out", func(r *PolicyRequest) {
+			r.Type, r.IPs, r.Ports = "deforcer", []string{"0.0.0.0/0"}, []string{"22"}
+		}, nil},
+	}
+	for _, test := range tests {
+		req := valid
+		test.change(&req)
+		var fields []string
+		for _, err := range Validate(nil, req, lockout) {
+			fields = append(fields, err.Field)
+		}
+		if !slices.Equal(fields, test.fields) {
+			t.Errorf("%s: errors on %v, want %v", test.name, fields, test.fields)
+		}
+	}
+}
@@ -4,9 +4,18 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/hanshal101/snapwall/internal/alerts"
 	"github.com/hanshal101/snapwall/internal/application"
+	"github.com/hanshal101/snapwall/internal/audit"
+	"github.com/hanshal101/snapwall/internal/autoblock"
 	"github.com/hanshal101/snapwall/internal/checkout"
+	"github.com/hanshal101/snapwall/internal/classify"
+	"github.com/hanshal101/snapwall/internal/detect"
+	"github.com/hanshal101/snapwall/internal/feeds"
+	"github.com/hanshal101/snapwall/internal/groups"
 	"github.com/hanshal101/snapwall/internal/logs"
+	"github.com/hanshal101/snapwall/internal/manifest"
+	"github.com/hanshal101/snapwall/internal/matcher"
 	"github.com/hanshal101/snapwall/internal/policies"
 )
 
@@ -17,9 +26,17 @@ func PolicyRoutes(r *gin.RouterGroup) {
 	// Implement Policy Routes
 	r.GET("", policies.GetPolicies)
 	r.POST("", policies.CreatePolicies)
+	r.POST("/validate", policies.ValidatePolicy)
+	r.POST("/simulate", matcher.SimulateFlow)
+	r.POST("/bulk", policies.BulkPolicies)
 	r.PUT("/:policyID", policies.UpdatePolicies)
 	r.DELETE("/:policyID", policies.DeletePolicy)
-	r.GET("/:ipAddr", policies.GetPoliciesbyIPs)
+	// gin requires one wildcard name per path segment, so the IP lookup
+	// reads its address from :policyID.
+	r.GET("/:policyID", policies.GetPoliciesbyIPs)
+	r.GET("/:policyID/revisions", policies.GetPolicyRevisions)
+	r.GET("/:policyID/hits", policies.GetPolicyHits)
+	r.POST("/:policyID/rollback/:revision", policies.RollbackPolicy)
 }
 
 func LogRoutes(r *gin.RouterGroup) {
@@ -28,9 +45,11 @@ func LogRoutes(r *gin.RouterGroup) {
 	})
 	// Implement log routes
 	r.GET("", logs.GetLogs)
+	r.GET("/stream", logs.StreamLogs)
 	r.GET("/port/:portNumber", logs.GetLogsByPort)
 	r.GET("/:ioType/ip/:ipAddress", logs.GetLogsByIP)
 	r.GET("/intruder", logs.GetIntruderLogs)
+	r.GET("/feed/:feedName", logs.GetLogsByFeed)
 }
 
 func CheckoutRoutes(r *gin.RouterGroup) {
@@ -42,7 +61,80 @@ func CheckoutRoutes(r *gin.RouterGroup) {
 func ApplicationRoutes(r *gin.RouterGroup) {
 	r.GET("", application.GetApplications)
 	r.POST("", application.CreateApplication)
-	// r.PUT("/ports")
+	r.PUT("/:applicationID", application.UpdateApplication)
 	r.DELETE("/:applicationID", application.DeleteApplication)
 	r.GET("/port/:portNumber", application.GetApplicationsbyPort)
 }
+
+func GroupRoutes(r *gin.RouterGroup) {
+	r.GET("/address", groups.GetAddressGroups)
+	r.POST("/address", groups.CreateAddressGroup)
+	r.PUT("/address/:groupID", groups.UpdateAddressGroup)
+	r.DELETE("/address/:groupID", groups.DeleteAddressGroup)
+
+	r.GET("/service", groups.GetServiceGroups)
+	r.POST("/service", groups.CreateServiceGroup)
+	r.PUT("/service/:groupID", groups.UpdateServiceGroup)
+	r.DELETE("/service/:groupID", groups.DeleteServiceGroup)
+}
+
+func AuditRoutes(r *gin.RouterGroup) {
+	r.GET("", audit.GetAuditEvents)
+}
+
+func ManifestRoutes(r *gin.RouterGroup) {
+	r.GET("/export", manifest.ExportDocument)
+	r.POST("/apply", manifest.ApplyDocument)
+}
+
+func RuleRoutes(r *gin.RouterGroup) {
+	r.GET("", classify.GetRules)
+	r.POST("", classify.CreateRule)
+	r.PUT("/:ruleID", classify.UpdateRule)
+	r.DELETE("/:ruleID", classify.DeleteRule)
+}
+
+func DetectionRoutes(r *gin.RouterGroup) {
+	r.GET("", detect.GetDetections)
+	r.GET("/:detectionID", detect.GetDetection)
+
+	r.GET("/thresholds", detect.GetThresholds)
+	r.POST("/thresholds", detect.CreateThreshold)
+	r.PUT("/thresholds/:thresholdID", detect.UpdateThreshold)
+	r.DELETE("/thresholds/:thresholdID", detect.DeleteThreshold)
+}
+
+func AutoBlockRoutes(r *gin.RouterGroup) {
+	r.GET("", autoblock.GetAutoBlocks)
+	r.POST("/:blockID/release", autoblock.ReleaseAutoBlock)
+}
+
+func FeedRoutes(r *gin.RouterGroup) {
+	r.GET("", feeds.GetFeeds)
+	r.POST("", feeds.CreateFeed)
+	r.GET("/:feedID", feeds.GetFeed)
+	r.PUT("/:feedID", feeds.UpdateFeed)
+	r.DELETE("/:feedID", feeds.DeleteFeed)
+	r.POST("/:feedID/refresh", feeds.RefreshFeed)
+}
+
+func AlertRoutes(r *gin.RouterGroup) {
+	r.GET("", alerts.GetAlerts)
+	r.GET("/:alertID", alerts.GetAlert)
+
+	r.GET("/destinations", alerts.GetDestinations)
+	r.POST("/destinations", alerts.CreateDestination)
+	r.PUT("/destinations/:destinationID", alerts.UpdateDestination)
+	r.DELETE("/destinations/:destinationID", alerts.DeleteDestination)
+	r.POST("/destinations/:destinationID/test", alerts.TestDestination)
+
+	r.GET("/rules", alerts.GetRules)
+	r.POST("/rules", alerts.CreateRule)
+	r.PUT("/rules/:ruleID", alerts.UpdateRule)
+	r.DELETE("/rules/:ruleID", alerts.DeleteRule)
+
+	r.GET("/suppressions", alerts.GetSuppressions)
+	r.POST("/suppressions", alerts.CreateSuppression)
+	r.PUT("/suppressions/:suppressionID", alerts.UpdateSuppression)
+	r.DELETE("/suppressions/:suppressionID", alerts.DeleteSuppression)
+}
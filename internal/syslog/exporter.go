@@ -0,0 +1,223 @@
+package syslog
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/hanshal101/snapwall/models"
+)
+
+// Networks messages can be sent over. TCP and TLS frame messages by octet
+// counting (RFC 6587, RFC 5425).
+const (
+	NetworkUDP = "udp"
+	NetworkTCP = "tcp"
+	NetworkTLS = "tls"
+)
+
+// DefaultFacility is local0.
+const DefaultFacility = 16
+
+const (
+	appName      = "snapwall"
+	writeTimeout = 10 * time.Second
+	minBackoff   = time.Second
+	maxBackoff   = 30 * time.Second
+)
+
+// Config sets where and how events are exported. Events below MinSeverity
+// are left out. Up to Buffer messages are kept while the receiver is
+// unavailable; beyond that the oldest are dropped.
+type Config struct {
+	Network     string
+	Address     string
+	Format      string
+	MinSeverity models.SEVERITY
+	Facility    int
+	Buffer      int
+	TLS         *tls.Config
+}
+
+// Exporter forwards logs and detections to a syslog receiver.
+type Exporter struct {
+	config   Config
+	hostname string
+	pid      int
+	queue    chan []byte
+	dropped  atomic.Int64
+}
+
+func New(config Config) (*Exporter, error) {
+	switch config.Network {
+	case NetworkUDP, NetworkTCP, NetworkTLS:
+	default:
+		return nil, fmt.Errorf("unknown network %q: expected udp, tcp or tls", config.Network)
+	}
+	validFormat := false
+	for _, format := range Formats {
+		if config.Format == format {
+			validFormat = true
+		}
+	}
+	if !validFormat {
+		return nil, fmt.Errorf("unknown format %q: expected rfc5424, cef or leef", config.Format)
+	}
+	if _, _, err := net.SplitHostPort(config.Address); err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", config.Address, err)
+	}
+	if config.Facility < 0 || config.Facility > 23 {
+		return nil, fmt.Errorf("invalid facility %d: expected 0-23", config.Facility)
+	}
+	if config.Buffer < 1 {
+		config.Buffer = 1
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &Exporter{
+		config:   config,
+		hostname: hostname,
+		pid:      os.Getpid(),
+		queue:    make(chan []byte, config.Buffer),
+	}, nil
+}
+
+// Log exports a classified flow. A nil Exporter exports nothing.
+func (e *Exporter) Log(entry models.Log) {
+	if e == nil || models.SEVERITY(entry.Severity).Rank() < e.config.MinSeverity.Rank() {
+		return
+	}
+	e.enqueue(logMessage(e.config.Format, entry))
+}
+
+// Detection exports a detection.
+func (e *Exporter) Detection(detection models.Detection) {
+	if e == nil || detection.Severity.Rank() < e.config.MinSeverity.Rank() {
+		return
+	}
+	e.enqueue(detectionMessage(e.config.Format, detection))
+}
+
+// enqueue buffers a message without blocking, making room by dropping the
+// oldest one if the buffer is full.
+func (e *Exporter) enqueue(m message) {
+	encoded := m.encode(e.config.Facility, e.hostname, appName, e.pid)
+	for {
+		select {
+		case e.queue <- encoded:
+			return
+		default:
+		}
+		select {
+		case <-e.queue:
+			e.dropped.Add(1)
+		default:
+		}
+	}
+}
+
+// Start sends buffered messages until ctx is done, reconnecting with
+// backoff while the receiver is unavailable.
+func (e *Exporter) Start(ctx context.Context) {
+	go e.run(ctx)
+}
+
+func (e *Exporter) run(ctx context.Context) {
+	var (
+		conn    net.Conn
+		pending []byte
+		backoff = minBackoff
+	)
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	for {
+		if pending == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case pending = <-e.queue:
+			}
+		}
+
+		if conn == nil {
+			var err error
+			if conn, err = e.dial(ctx); err != nil {
+				log.Printf("Error in connecting to syslog receiver %s: %v", e.config.Address, err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				if backoff *= 2; backoff > maxBackoff {
+					backoff = maxBackoff
+				}
+				continue
+			}
+			backoff = minBackoff
+			if dropped := e.dropped.Swap(0); dropped > 0 {
+				log.Printf("Dropped %d syslog messages while %s was unavailable", dropped, e.config.Address)
+			}
+		}
+
+		if err := e.write(conn, pending); err != nil {
+			// Keep the message and send it again once reconnected.
+			log.Printf("Error in sending to syslog receiver %s: %v", e.config.Address, err)
+			conn.Close()
+			conn = nil
+			continue
+		}
+		pending = nil
+	}
+}
+
+func (e *Exporter) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: writeTimeout}
+	if e.config.Network == NetworkTLS {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: e.config.TLS}
+		return tlsDialer.DialContext(ctx, "tcp", e.config.Address)
+	}
+	return dialer.DialContext(ctx, e.config.Network, e.config.Address)
+}
+
+func (e *Exporter) write(conn net.Conn, msg []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if e.config.Network == NetworkUDP {
+		_, err := conn.Write(msg)
+		return err
+	}
+	framed := append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	_, err := conn.Write(framed)
+	return err
+}
+
+// TLSConfig trusts the CA certificates in the PEM file at caPath, or the
+// system roots if caPath is empty. insecure skips verification altogether.
+func TLSConfig(caPath string, insecure bool) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if caPath == "" {
+		return config, nil
+	}
+	pem, err := os.ReadFile(caPath)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caPath)
+	}
+	config.RootCAs = pool
+	return config, nil
+}
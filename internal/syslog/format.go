@@ -0,0 +1,236 @@
+package syslog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hanshal101/snapwall/models"
+)
+
+// Formats of the exported messages. RFC 5424 messages carry the event as
+// structured data; CEF and LEEF messages carry it as the message text of an
+// RFC 5424 message.
+const (
+	FormatRFC5424 = "rfc5424"
+	FormatCEF     = "cef"
+	FormatLEEF    = "leef"
+)
+
+// Formats lists the supported formats.
+var Formats = []string{FormatRFC5424, FormatCEF, FormatLEEF}
+
+const (
+	vendor  = "Snapwall"
+	product = "snapwall"
+	version = "1.0"
+
+	// sdID names the structured data element. 32473 is the private
+	// enterprise number reserved for documentation (RFC 5612).
+	sdID = "snapwall@32473"
+
+	// timeFormat is an RFC 5424 timestamp, which allows six fractional
+	// digits at most.
+	timeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// message is an event to export: what goes into the RFC 5424 header, the
+// structured data ("-" for none) and the message text.
+type message struct {
+	time     time.Time
+	severity models.SEVERITY
+	msgID    string
+	data     string
+	text     string
+}
+
+// field is a key and value of an event.
+type field struct {
+	key, value string
+}
+
+// fields collects the fields of an event under the keys format uses. Empty
+// keys and values are left out.
+type fields struct {
+	format string
+	list   []field
+}
+
+func (f *fields) add(rfc, cef, leef, value string) {
+	key := rfc
+	switch f.format {
+	case FormatCEF:
+		key = cef
+	case FormatLEEF:
+		key = leef
+	}
+	if key != "" && value != "" {
+		f.list = append(f.list, field{key, value})
+	}
+}
+
+// custom adds a field CEF has no key for, in one of its custom slots named
+// by a label field: cs1Label=feeds cs1=...
+func (f *fields) custom(rfc, cef, leef, value string) {
+	if f.format == FormatCEF && value != "" {
+		f.list = append(f.list, field{cef + "Label", rfc})
+	}
+	f.add(rfc, cef, leef, value)
+}
+
+// logMessage formats a classified flow.
+func logMessage(format string, entry models.Log) message {
+	m := message{time: entry.Time, severity: models.SEVERITY(entry.Severity), msgID: "log"}
+	name := fmt.Sprintf("%s %s flow from %s to %s:%s", entry.Type, entry.Protocol, entry.Source, entry.Destination, entry.Port)
+
+	f := fields{format: format}
+	f.add("src", "src", "src", entry.Source)
+	f.add("dst", "dst", "dst", entry.Destination)
+	f.add("dport", "dpt", "dstPort", entry.Port)
+	f.add("proto", "proto", "proto", entry.Protocol)
+	f.add("direction", "deviceDirection", "direction", direction(format, entry.Type))
+	f.add("severity", "", "", entry.Severity)
+	f.custom("feeds", "cs1", "feeds", strings.Join(entry.Feeds, ","))
+	f.custom("country", "cs2", "srcCountry", entry.Country)
+	f.custom("city", "cs3", "srcCity", entry.City)
+	if entry.ASN != 0 {
+		f.custom("asn", "cn1", "srcASN", strconv.FormatUint(uint64(entry.ASN), 10))
+	}
+	f.custom("org", "cs4", "srcOrg", entry.Org)
+
+	m.encodeEvent(format, "flow", name, f.list)
+	return m
+}
+
+// detectionMessage formats a detection.
+func detectionMessage(format string, detection models.Detection) message {
+	m := message{time: detection.LastSeen, severity: detection.Severity, msgID: "detection"}
+	name := fmt.Sprintf("%s from %s: %d targets", detection.Kind, detection.Source, detection.Count)
+
+	f := fields{format: format}
+	f.add("kind", "cat", "cat", detection.Kind)
+	f.add("src", "src", "src", detection.Source)
+	f.add("count", "cnt", "count", strconv.Itoa(detection.Count))
+	f.add("severity", "", "", string(detection.Severity))
+	f.custom("ports", "cs5", "dstPorts", strings.Join(detection.Ports, ","))
+	f.custom("destinations", "cs6", "dstHosts", strings.Join(detection.Destinations, ","))
+	if !detection.FirstSeen.IsZero() {
+		f.add("firstSeen", "start", "startTime", timestamp(format, detection.FirstSeen))
+		f.add("lastSeen", "end", "endTime", timestamp(format, detection.LastSeen))
+	}
+	if detection.ID != 0 {
+		f.add("id", "externalId", "externalId", strconv.FormatUint(uint64(detection.ID), 10))
+	}
+
+	m.encodeEvent(format, detection.Kind, name, f.list)
+	return m
+}
+
+// encodeEvent sets the structured data and text of m for format.
+func (m *message) encodeEvent(format, eventID, name string, fields []field) {
+	if m.time.IsZero() {
+		m.time = time.Now()
+	}
+	switch format {
+	case FormatCEF:
+		ext := make([]string, 0, len(fields)+1)
+		ext = append(ext, "rt="+timestamp(format, m.time))
+		for _, f := range fields {
+			ext = append(ext, f.key+"="+cefValue(f.value))
+		}
+		m.data = "-"
+		m.text = fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+			cefHeader(vendor), cefHeader(product), cefHeader(version), cefHeader(eventID), cefHeader(name),
+			score(m.severity), strings.Join(ext, " "))
+
+	case FormatLEEF:
+		attrs := make([]string, 0, len(fields)+3)
+		attrs = append(attrs, "devTime="+timestamp(format, m.time), "devTimeFormat=yyyy-MM-dd'T'HH:mm:ss.SSSXXX",
+			"sev="+strconv.Itoa(score(m.severity)))
+		for _, f := range fields {
+			attrs = append(attrs, f.key+"="+leefValue(f.value))
+		}
+		m.data = "-"
+		m.text = fmt.Sprintf("LEEF:1.0|%s|%s|%s|%s|%s", vendor, product, version, leefValue(eventID), strings.Join(attrs, "\t"))
+
+	default:
+		params := make([]string, 0, len(fields))
+		for _, f := range fields {
+			params = append(params, fmt.Sprintf(`%s="%s"`, f.key, sdValue(f.value)))
+		}
+		m.data = "[" + sdID + " " + strings.Join(params, " ") + "]"
+		m.text = name
+	}
+}
+
+// encode renders m as an RFC 5424 message.
+func (m message) encode(facility int, hostname, appName string, pid int) []byte {
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		facility*8+syslogSeverity(m.severity), m.time.Format(timeFormat), hostname, appName, pid, m.msgID, m.data, m.text))
+}
+
+// syslogSeverity maps a severity to a syslog severity: critical, error,
+// warning and informational.
+func syslogSeverity(severity models.SEVERITY) int {
+	switch severity {
+	case models.SEVERITY_CRITICAL:
+		return 2
+	case models.SEVERITY_HIGH:
+		return 3
+	case models.SEVERITY_MEDIUM:
+		return 4
+	default:
+		return 6
+	}
+}
+
+// score maps a severity to the 0-10 scale of CEF and LEEF.
+func score(severity models.SEVERITY) int {
+	switch severity {
+	case models.SEVERITY_CRITICAL:
+		return 10
+	case models.SEVERITY_HIGH:
+		return 8
+	case models.SEVERITY_MEDIUM:
+		return 5
+	default:
+		return 3
+	}
+}
+
+// direction is the CEF deviceDirection, 0 for inbound and 1 for outbound,
+// or the flow type as is for other formats.
+func direction(format, flowType string) string {
+	if format != FormatCEF {
+		return flowType
+	}
+	switch strings.ToLower(flowType) {
+	case "incoming":
+		return "0"
+	case "outgoing":
+		return "1"
+	}
+	return ""
+}
+
+// timestamp renders a time as CEF expects, milliseconds since the epoch, or
+// as an RFC 3339 time with milliseconds.
+func timestamp(format string, t time.Time) string {
+	if format == FormatCEF {
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	}
+	return t.Format("2006-01-02T15:04:05.000Z07:00")
+}
+
+var (
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefValueEscaper  = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+	leefValueEscaper = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ", "|", " ")
+	sdValueEscaper   = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+)
+
+func cefHeader(s string) string { return cefHeaderEscaper.Replace(s) }
+func cefValue(s string) string  { return cefValueEscaper.Replace(s) }
+func leefValue(s string) string { return leefValueEscaper.Replace(s) }
+func sdValue(s string) string   { return sdValueEscaper.Replace(s) }
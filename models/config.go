@@ -1,6 +1,9 @@
 package models
 
 import (
+	"encoding/json"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/shirou/gopsutil/cpu"
@@ -13,17 +16,88 @@ import (
 type SEVERITY string
 
 const (
-	SEVERITY_LOW    SEVERITY = "LOW"
-	SEVERITY_MEDIUM SEVERITY = "MEDIUM"
-	SEVERITY_HIGH   SEVERITY = "HIGH"
+	SEVERITY_LOW      SEVERITY = "LOW"
+	SEVERITY_MEDIUM   SEVERITY = "MEDIUM"
+	SEVERITY_HIGH     SEVERITY = "HIGH"
+	SEVERITY_CRITICAL SEVERITY = "CRITICAL"
 )
 
+// Severities lists the severities from least to most severe.
+var Severities = []SEVERITY{SEVERITY_LOW, SEVERITY_MEDIUM, SEVERITY_HIGH, SEVERITY_CRITICAL}
+
+// Rank orders severities, higher is more severe. Unknown severities rank
+// below LOW.
+func (s SEVERITY) Rank() int {
+	for i, severity := range Severities {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// ParseSeverity accepts a severity name in any case.
+func ParseSeverity(s string) (SEVERITY, bool) {
+	severity := SEVERITY(strings.ToUpper(s))
+	return severity, severity.Rank() >= 0
+}
+
 type Policy struct {
 	gorm.Model
-	Name  string `json:"name"`
-	Type  string `json:"type"`
-	IPs   []IP   `json:"ips" gorm:"foreignKey:PolicyID;constraint:OnDelete:CASCADE;"`
-	Ports []Port `json:"ports" gorm:"foreignKey:PolicyID;constraint:OnDelete:CASCADE;"`
+	Name          string         `json:"name"`
+	Type          string         `json:"type"`
+	Severity      SEVERITY       `json:"severity"` // of matching flows, HIGH if empty
+	IPs           []IP           `json:"ips" gorm:"foreignKey:PolicyID;constraint:OnDelete:CASCADE;"`
+	Ports         []Port         `json:"ports" gorm:"foreignKey:PolicyID;constraint:OnDelete:CASCADE;"`
+	AddressGroups []AddressGroup `json:"address_groups" gorm:"many2many:policy_address_groups;"`
+	ServiceGroups []ServiceGroup `json:"service_groups" gorm:"many2many:policy_service_groups;"`
+	// Applications and ApplicationTags bind the policy to the ports of
+	// registered applications, resolved at enforcement time.
+	Applications       []Application          `json:"applications" gorm:"many2many:policy_applications;"`
+	ApplicationTags    []PolicyApplicationTag `json:"application_tags" gorm:"foreignKey:PolicyID;constraint:OnDelete:CASCADE;"`
+	TaggedApplications []Application          `json:"tagged_applications,omitempty" gorm:"-"`
+	// Hits and LastHit summarise the policy's PolicyHit samples.
+	Hits    uint64     `json:"hits" gorm:"-"`
+	LastHit *time.Time `json:"last_hit" gorm:"-"`
+	// Managed names the component that owns the policy, such as
+	// "autoblock". Operators' policies leave it empty.
+	Managed string `json:"managed,omitempty" gorm:"index"`
+	// Countries (ISO codes) and ASNs select sources by location. The
+	// reconciler expands them into GeoNetworks from the GeoIP databases;
+	// GeoVersion records the databases and selectors they came from.
+	Countries   []string           `json:"countries,omitempty" gorm:"type:jsonb;serializer:json"`
+	ASNs        []uint32           `json:"asns,omitempty" gorm:"type:jsonb;serializer:json"`
+	GeoNetworks []PolicyGeoNetwork `json:"-" gorm:"foreignKey:PolicyID;constraint:OnDelete:CASCADE;"`
+	GeoVersion  string             `json:"-"`
+	// Hostnames are resolved by the reconciler as their DNS records expire;
+	// the addresses they currently resolve to are enforced.
+	Hostnames []PolicyHostname `json:"hostnames,omitempty" gorm:"foreignKey:PolicyID;constraint:OnDelete:CASCADE;"`
+}
+
+// PolicyHostname is a DNS name in a policy and what it last resolved to.
+// ExpiresAt is when the answer's TTL runs out and it is resolved again;
+// Error is set while resolution fails, keeping the previous addresses.
+type PolicyHostname struct {
+	ID         uint       `json:"id" gorm:"primarykey"`
+	PolicyID   uint       `json:"policy_id" gorm:"index"`
+	Name       string     `json:"name"`
+	Addresses  []string   `json:"addresses" gorm:"type:jsonb;serializer:json"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	ExpiresAt  *time.Time `json:"expires_at" gorm:"index"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// PolicyGeoNetwork is a network a policy's countries or ASNs expand to.
+type PolicyGeoNetwork struct {
+	ID       uint   `json:"id" gorm:"primarykey"`
+	PolicyID uint   `json:"policy_id" gorm:"index"`
+	Address  string `json:"address"`
+}
+
+type PolicyApplicationTag struct {
+	gorm.Model
+	PolicyID uint   `json:"policy_id"`
+	Tag      string `json:"tag"`
 }
 
 type IP struct {
@@ -36,6 +110,153 @@ type Port struct {
 	gorm.Model
 	PolicyID uint   `json:"policy_id"`
 	Number   string `json:"number"`
+	Protocol string `json:"protocol"`
+}
+
+// PolicyRevision is an immutable snapshot of a policy definition, taken on
+// every create, update, delete and rollback.
+type PolicyRevision struct {
+	ID        uint            `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time       `json:"created_at"`
+	PolicyID  uint            `json:"policy_id" gorm:"index"`
+	Revision  int             `json:"revision"`
+	Action    string          `json:"action"`
+	Spec      json.RawMessage `json:"spec" gorm:"type:jsonb"`
+}
+
+// ClassificationRule assigns a severity to flows matching an expression, such
+// as `protocol == "UDP" && port in [53, 123]`, independently of policies.
+type ClassificationRule struct {
+	gorm.Model
+	Name       string   `json:"name" gorm:"uniqueIndex:idx_classification_rules_live_name,where:deleted_at IS NULL"`
+	Expression string   `json:"expression"`
+	Severity   SEVERITY `json:"severity"`
+	Enabled    bool     `json:"enabled"`
+}
+
+// Detection is a suspicious pattern found in the flows of one source, such
+// as a port scan. Destinations and Ports list what the source touched;
+// FirstSeen and LastSeen span the times the client gave those flows, which
+// their logs are stored with.
+type Detection struct {
+	ID           uint      `json:"id" gorm:"primarykey"`
+	CreatedAt    time.Time `json:"created_at" gorm:"index"`
+	Kind         string    `json:"kind" gorm:"index"`
+	Source       string    `json:"source" gorm:"index"`
+	Destinations []string  `json:"destinations" gorm:"type:jsonb;serializer:json"`
+	Ports        []string  `json:"ports" gorm:"type:jsonb;serializer:json"`
+	Count        int       `json:"count"`
+	Severity     SEVERITY  `json:"severity"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// DetectionThreshold overrides when a rate detector fires for one port, or
+// for the port of a registered application: more than Count connections
+// from one source within Window seconds.
+type DetectionThreshold struct {
+	gorm.Model
+	Kind          string `json:"kind"`
+	Port          string `json:"port,omitempty"`
+	ApplicationID *uint  `json:"application_id,omitempty"`
+	Count         int    `json:"count"`
+	Window        int    `json:"window"`
+}
+
+// AutoBlock is a temporary enforcer policy created in response to an
+// intruder. Triggers records the events that led to it.
+type AutoBlock struct {
+	gorm.Model
+	PolicyID   uint               `json:"policy_id" gorm:"index"`
+	Source     string             `json:"source" gorm:"index"`
+	Ports      []string           `json:"ports" gorm:"type:jsonb;serializer:json"`
+	Triggers   []AutoBlockTrigger `json:"triggers" gorm:"type:jsonb;serializer:json"`
+	ExpiresAt  time.Time          `json:"expires_at" gorm:"index"`
+	ReleasedAt *time.Time         `json:"released_at"`
+}
+
+// AutoBlockTrigger is one event behind an AutoBlock: a detection, or a flow
+// classified at or above the blocking severity.
+type AutoBlockTrigger struct {
+	Time        time.Time `json:"time"`
+	Kind        string    `json:"kind"`
+	DetectionID uint      `json:"detection_id,omitempty"`
+	Destination string    `json:"destination,omitempty"`
+	Port        string    `json:"port,omitempty"`
+	Severity    SEVERITY  `json:"severity"`
+}
+
+// PolicyHit is one sample of the kernel counters attributed to a policy:
+// the packets and bytes its rules dropped since the previous sample.
+type PolicyHit struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"time" gorm:"index"`
+	PolicyID  uint      `json:"policy_id" gorm:"index"`
+	Packets   uint64    `json:"packets"`
+	Bytes     uint64    `json:"bytes"`
+}
+
+var ErrImmutable = errors.New("record is immutable")
+
+func (PolicyRevision) BeforeUpdate(*gorm.DB) error { return ErrImmutable }
+
+func (PolicyRevision) BeforeDelete(*gorm.DB) error { return ErrImmutable }
+
+// AuditEvent records a single configuration change. Events are append-only.
+// ClaimedActor is the name an API caller gave, which is not verified.
+type AuditEvent struct {
+	ID           uint            `json:"id" gorm:"primarykey"`
+	CreatedAt    time.Time       `json:"time" gorm:"index"`
+	Actor        string          `json:"actor" gorm:"index"`
+	ClaimedActor string          `json:"claimed_actor,omitempty"`
+	ClientIP     string          `json:"client_ip"`
+	Action       string          `json:"action"`
+	ObjectType   string          `json:"object_type" gorm:"index:idx_audit_object"`
+	ObjectID     uint            `json:"object_id" gorm:"index:idx_audit_object"`
+	Before       json.RawMessage `json:"before" gorm:"type:jsonb"`
+	After        json.RawMessage `json:"after" gorm:"type:jsonb"`
+}
+
+func (AuditEvent) BeforeUpdate(*gorm.DB) error { return ErrImmutable }
+
+func (AuditEvent) BeforeDelete(*gorm.DB) error { return ErrImmutable }
+
+// ConfigVersion is a single row counting configuration changes. Every audit
+// event bumps it in the same transaction, so its version only grows in commit
+// order and tells caches when to rebuild.
+type ConfigVersion struct {
+	ID      uint   `gorm:"primarykey"`
+	Version uint64 `gorm:"not null"`
+}
+
+// GROUP Models
+type AddressGroup struct {
+	gorm.Model
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Addresses   []GroupAddress `json:"addresses" gorm:"foreignKey:AddressGroupID;constraint:OnDelete:CASCADE;"`
+	// Managed names the component that owns the group, such as "feed".
+	Managed string `json:"managed,omitempty" gorm:"index"`
+}
+
+type GroupAddress struct {
+	gorm.Model
+	AddressGroupID uint   `json:"address_group_id"`
+	Address        string `json:"address"`
+}
+
+type ServiceGroup struct {
+	gorm.Model
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Services    []GroupService `json:"services" gorm:"foreignKey:ServiceGroupID;constraint:OnDelete:CASCADE;"`
+}
+
+type GroupService struct {
+	gorm.Model
+	ServiceGroupID uint   `json:"service_group_id"`
+	Port           string `json:"port"`
+	Protocol       string `json:"protocol"`
 }
 
 type Log struct {
@@ -46,6 +267,14 @@ type Log struct {
 	Port        string    `json:"port"`
 	Protocol    string    `json:"protocol"`
 	Severity    string    `json:"severity"`
+	// Feeds names the threat-intel feeds listing the source.
+	Feeds []string `json:"feeds"`
+	// Country, City, ASN and Org locate the source, from the GeoIP
+	// databases. They are empty when the source is unknown to them.
+	Country string `json:"country,omitempty"`
+	City    string `json:"city,omitempty"`
+	ASN     uint32 `json:"asn,omitempty"`
+	Org     string `json:"org,omitempty"`
 }
 
 type SystemInfo struct {
@@ -69,3 +298,107 @@ type Tags struct {
 	ApplicationID uint   `json:"application_id"`
 	Tag           string `json:"tag"`
 }
+
+// Feed is a threat-intel blocklist read from a file on disk. Its entries are
+// kept in a managed address group and, if Block is set, enforced by a
+// managed policy.
+type Feed struct {
+	gorm.Model
+	Name   string `json:"name" gorm:"uniqueIndex:idx_feeds_live_name,where:deleted_at IS NULL"`
+	Path   string `json:"path"`
+	Format string `json:"format"` // plain, spamhaus or csv
+	// Column is the zero-based CSV column holding the address.
+	Column   int      `json:"column"`
+	Interval int      `json:"interval"` // seconds between refreshes
+	Enabled  bool     `json:"enabled"`
+	Block    bool     `json:"block"`
+	Severity SEVERITY `json:"severity"`
+
+	AddressGroupID *uint `json:"address_group_id"`
+	PolicyID       *uint `json:"policy_id"`
+
+	// The outcome of the last refresh. Skipped counts the lines that held
+	// no valid address.
+	Entries     int        `json:"entries"`
+	Skipped     int        `json:"skipped"`
+	Checksum    string     `json:"-"`
+	RefreshedAt *time.Time `json:"refreshed_at"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// AlertDestination is where alerts are sent. A webhook receives each alert
+// as a JSON POST to URL, signed with Secret if one is set. An smtp
+// destination emails each alert from From to To through the server at
+// SMTPAddress (host:port), logging in as Username with Secret as the
+// password if Username is set. SubjectTemplate and BodyTemplate replace the
+// default text/template of the email.
+type AlertDestination struct {
+	gorm.Model
+	Name    string            `json:"name" gorm:"uniqueIndex:idx_alert_destinations_live_name,where:deleted_at IS NULL"`
+	Type    string            `json:"type"` // webhook, smtp
+	URL     string            `json:"url,omitempty"`
+	Secret  string            `json:"-"`
+	Headers map[string]string `json:"headers,omitempty" gorm:"type:jsonb;serializer:json"`
+	Enabled bool              `json:"enabled"`
+
+	SMTPAddress     string   `json:"smtp_address,omitempty"`
+	SMTPSecurity    string   `json:"smtp_security,omitempty"` // starttls, tls, none
+	Username        string   `json:"username,omitempty"`
+	From            string   `json:"from,omitempty"`
+	To              []string `json:"to,omitempty" gorm:"type:jsonb;serializer:json"`
+	SubjectTemplate string   `json:"subject_template,omitempty"`
+	BodyTemplate    string   `json:"body_template,omitempty"`
+
+	// The outcome of the last delivery.
+	DeliveredAt *time.Time `json:"delivered_at"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// AlertRule sends the events of the given Kinds, all kinds if empty, at or
+// above MinSeverity to its destinations. Kinds are "classification" for
+// flows the server classified and the detection kinds. Events with the same
+// source, kind, policies and classification rules are grouped into one
+// alert for GroupWindow seconds, five minutes if unset.
+type AlertRule struct {
+	gorm.Model
+	Name           string   `json:"name" gorm:"uniqueIndex:idx_alert_rules_live_name,where:deleted_at IS NULL"`
+	Enabled        bool     `json:"enabled"`
+	MinSeverity    SEVERITY `json:"min_severity"`
+	Kinds          []string `json:"kinds" gorm:"type:jsonb;serializer:json"`
+	DestinationIDs []uint   `json:"destination_ids" gorm:"type:jsonb;serializer:json"`
+	GroupWindow    int      `json:"group_window"`
+}
+
+// Alert is a group of events one rule matched. The first event is sent when
+// the alert opens; if more follow, a summary is sent when it closes.
+// Suppressed names the suppression that kept it from being sent.
+type Alert struct {
+	ID         uint       `json:"id" gorm:"primarykey"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RuleID     uint       `json:"rule_id" gorm:"index"`
+	Kind       string     `json:"kind"`
+	Source     string     `json:"source" gorm:"index"`
+	Policies   []string   `json:"policies" gorm:"type:jsonb;serializer:json"`
+	Rules      []string   `json:"classification_rules" gorm:"type:jsonb;serializer:json"`
+	Severity   SEVERITY   `json:"severity"`
+	Count      int        `json:"count"`
+	FirstSeen  time.Time  `json:"first_seen"`
+	LastSeen   time.Time  `json:"last_seen" gorm:"index"`
+	ClosedAt   *time.Time `json:"closed_at"`
+	Suppressed string     `json:"suppressed,omitempty"`
+}
+
+// AlertSuppression keeps matching events from being sent. Without Sources
+// it is a maintenance window silencing every source between StartsAt and
+// EndsAt; with Sources, IPs or CIDRs, it allow-lists known scanners, for
+// good if it has no window. Kinds narrows it to some event kinds.
+type AlertSuppression struct {
+	gorm.Model
+	Name     string     `json:"name" gorm:"uniqueIndex:idx_alert_suppressions_live_name,where:deleted_at IS NULL"`
+	Comment  string     `json:"comment"`
+	Sources  []string   `json:"sources" gorm:"type:jsonb;serializer:json"`
+	Kinds    []string   `json:"kinds" gorm:"type:jsonb;serializer:json"`
+	StartsAt *time.Time `json:"starts_at"`
+	EndsAt   *time.Time `json:"ends_at"`
+	Enabled  bool       `json:"enabled"`
+}
@@ -21,6 +21,7 @@ const _ = grpc.SupportPackageIsVersion9
 const (
 	Sender_Send_FullMethodName         = "/service.Sender/Send"
 	Sender_SendNodeData_FullMethodName = "/service.Sender/SendNodeData"
+	Sender_Subscribe_FullMethodName    = "/service.Sender/Subscribe"
 )
 
 // SenderClient is the client API for Sender service.
@@ -29,6 +30,7 @@ const (
 type SenderClient interface {
 	Send(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ServiceRequest, ServiceResponse], error)
 	SendNodeData(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[NodeRequest, NodeResponse], error)
+	Subscribe(ctx context.Context, in *Filter, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ServiceResponse], error)
 }
 
 type senderClient struct {
@@ -65,12 +67,32 @@ func (c *senderClient) SendNodeData(ctx context.Context, opts ...grpc.CallOption
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type Sender_SendNodeDataClient = grpc.BidiStreamingClient[NodeRequest, NodeResponse]
 
+func (c *senderClient) Subscribe(ctx context.Context, in *Filter, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ServiceResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Sender_ServiceDesc.Streams[2], Sender_Subscribe_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Filter, ServiceResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Sender_SubscribeClient = grpc.ServerStreamingClient[ServiceResponse]
+
 // SenderServer is the server API for Sender service.
 // All implementations must embed UnimplementedSenderServer
 // for forward compatibility.
 type SenderServer interface {
 	Send(grpc.BidiStreamingServer[ServiceRequest, ServiceResponse]) error
 	SendNodeData(grpc.BidiStreamingServer[NodeRequest, NodeResponse]) error
+	Subscribe(*Filter, grpc.ServerStreamingServer[ServiceResponse]) error
 	mustEmbedUnimplementedSenderServer()
 }
 
@@ -87,6 +109,9 @@ func (UnimplementedSenderServer) Send(grpc.BidiStreamingServer[ServiceRequest, S
 func (UnimplementedSenderServer) SendNodeData(grpc.BidiStreamingServer[NodeRequest, NodeResponse]) error {
 	return status.Errorf(codes.Unimplemented, "method SendNodeData not implemented")
 }
+func (UnimplementedSenderServer) Subscribe(*Filter, grpc.ServerStreamingServer[ServiceResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
 func (UnimplementedSenderServer) mustEmbedUnimplementedSenderServer() {}
 func (UnimplementedSenderServer) testEmbeddedByValue()                {}
 
@@ -122,6 +147,17 @@ func _Sender_SendNodeData_Handler(srv interface{}, stream grpc.ServerStream) err
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type Sender_SendNodeDataServer = grpc.BidiStreamingServer[NodeRequest, NodeResponse]
 
+func _Sender_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Filter)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SenderServer).Subscribe(m, &grpc.GenericServerStream[Filter, ServiceResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Sender_SubscribeServer = grpc.ServerStreamingServer[ServiceResponse]
+
 // Sender_ServiceDesc is the grpc.ServiceDesc for Sender service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -142,6 +178,11 @@ var Sender_ServiceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "Subscribe",
+			Handler:       _Sender_Subscribe_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "service.proto",
 }
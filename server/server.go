@@ -8,15 +8,27 @@ import (
 	"net"
 	"os"
 	"regexp"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/hanshal101/snapwall/database/clickhouse"
 	"github.com/hanshal101/snapwall/database/psql"
+	"github.com/hanshal101/snapwall/internal/alerts"
+	"github.com/hanshal101/snapwall/internal/autoblock"
+	"github.com/hanshal101/snapwall/internal/detect"
+	"github.com/hanshal101/snapwall/internal/feeds"
+	"github.com/hanshal101/snapwall/internal/geoip"
 	"github.com/hanshal101/snapwall/internal/logs"
+	"github.com/hanshal101/snapwall/internal/matcher"
+	"github.com/hanshal101/snapwall/internal/syslog"
 	"github.com/hanshal101/snapwall/models"
 	snapwall "github.com/hanshal101/snapwall/proto"
 	"github.com/joho/godotenv"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 type Server struct {
@@ -24,7 +36,20 @@ type Server struct {
 }
 
 var (
-	port = flag.Int("port", 50051, "The server port")
+	port        = flag.Int("port", 50051, "The server port")
+	policyCache *matcher.Cache
+	detectors   *detect.Engine
+	bruteForce  *detect.RateDetector
+	flood       *detect.RateDetector
+	responder   *autoblock.Responder
+	feedIndex   atomic.Pointer[feeds.Index]
+	geo         *geoip.Resolver
+	dispatcher  *alerts.Dispatcher
+	exporter    *syslog.Exporter
+	subscribers = logs.NewHub()
+	publisher   = logs.NewPublisher(10000)
+	// subscriberBuffer is how many events a subscriber may fall behind by.
+	subscriberBuffer int
 )
 
 func init() {
@@ -34,6 +59,150 @@ func init() {
 	}
 	psql.InitDB()
 	clickhouse.InitClickhouse(context.Background())
+
+	resolver, err := geoip.NewResolver(os.Getenv("GEOIP_CITY_DB"), os.Getenv("GEOIP_ASN_DB"))
+	if err != nil {
+		log.Fatalf("Error in opening GeoIP databases: %v", err)
+	}
+	geo = resolver
+	subscriberBuffer = envInt("SUBSCRIBER_BUFFER", 1000)
+
+	policyCache = matcher.NewCache(psql.DB, envDuration("MATCHER_REFRESH_INTERVAL", 5*time.Second))
+	bruteForce = detect.NewRateDetector(detect.KindBruteForce, nil, nil)
+	flood = detect.NewRateDetector(detect.KindConnectionFlood, &detect.Threshold{
+		Count:  envInt("FLOOD_COUNT", 1000),
+		Window: envDuration("FLOOD_WINDOW", 10*time.Second),
+	}, nil)
+	detectors = detect.NewEngine(
+		detect.NewScanDetector(detect.ScanConfig{
+			Window:   envDuration("SCAN_WINDOW", time.Minute),
+			MaxPorts: envInt("SCAN_MAX_PORTS", 20),
+			MaxHosts: envInt("SCAN_MAX_HOSTS", 10),
+		}),
+		bruteForce,
+		flood,
+	)
+
+	dispatcher = alerts.New(psql.DB, alerts.Config{
+		Attempts:   envInt("ALERT_ATTEMPTS", 5),
+		Backoff:    envDuration("ALERT_BACKOFF", time.Second),
+		MaxBackoff: envDuration("ALERT_MAX_BACKOFF", time.Minute),
+	})
+
+	if address := os.Getenv("SYSLOG_ADDRESS"); address != "" {
+		network := os.Getenv("SYSLOG_NETWORK")
+		if network == "" {
+			network = syslog.NetworkUDP
+		}
+		format := os.Getenv("SYSLOG_FORMAT")
+		if format == "" {
+			format = syslog.FormatRFC5424
+		}
+		minSeverity, ok := models.ParseSeverity(os.Getenv("SYSLOG_MIN_SEVERITY"))
+		if !ok {
+			minSeverity = models.SEVERITY_LOW
+		}
+		tlsConfig, err := syslog.TLSConfig(os.Getenv("SYSLOG_TLS_CA"), os.Getenv("SYSLOG_TLS_INSECURE") == "true")
+		if err != nil {
+			log.Fatalf("Error in loading SYSLOG_TLS_CA: %v", err)
+		}
+		exporter, err = syslog.New(syslog.Config{
+			Network:     network,
+			Address:     address,
+			Format:      format,
+			MinSeverity: minSeverity,
+			Facility:    envInt("SYSLOG_FACILITY", syslog.DefaultFacility),
+			Buffer:      envInt("SYSLOG_BUFFER", 10000),
+			TLS:         tlsConfig,
+		})
+		if err != nil {
+			log.Fatalf("Error in configuring syslog export: %v", err)
+		}
+	}
+
+	if os.Getenv("AUTOBLOCK_ENABLED") == "true" {
+		allowlist, err := autoblock.ParseAllowlist(os.Getenv("AUTOBLOCK_ALLOWLIST"))
+		if err != nil {
+			log.Fatalf("Error in parsing AUTOBLOCK_ALLOWLIST: %v", err)
+		}
+		minSeverity, ok := models.ParseSeverity(os.Getenv("AUTOBLOCK_MIN_SEVERITY"))
+		if !ok {
+			minSeverity = models.SEVERITY_CRITICAL
+		}
+		responder = autoblock.New(psql.DB, autoblock.Config{
+			Duration:       envDuration("AUTOBLOCK_DURATION", time.Hour),
+			MinSeverity:    minSeverity,
+			Threshold:      envInt("AUTOBLOCK_THRESHOLD", 5),
+			Window:         envDuration("AUTOBLOCK_WINDOW", time.Minute),
+			Detections:     os.Getenv("AUTOBLOCK_DETECTIONS") != "false",
+			Allowlist:      allowlist,
+			AllowlistGroup: os.Getenv("AUTOBLOCK_ALLOWLIST_GROUP"),
+		})
+	}
+}
+
+// watchThresholds applies the configured detection thresholds on top of the
+// defaults, and again every interval. Brute force is watched on
+// detect.AuthPorts and on every port with a threshold of its own.
+func watchThresholds(interval time.Duration) {
+	defaults := detect.Threshold{
+		Count:  envInt("BRUTE_FORCE_COUNT", 10),
+		Window: envDuration("BRUTE_FORCE_WINDOW", time.Minute),
+	}
+	for {
+		configured, err := detect.LoadThresholds(psql.DB)
+		if err != nil {
+			log.Printf("Error in loading detection thresholds: %v", err)
+		} else {
+			ports := make(map[string]detect.Threshold)
+			for _, port := range detect.AuthPorts {
+				ports[port] = defaults
+			}
+			for port, threshold := range configured[detect.KindBruteForce] {
+				ports[port] = threshold
+			}
+			bruteForce.SetThresholds(ports)
+			flood.SetThresholds(configured[detect.KindConnectionFlood])
+		}
+		time.Sleep(interval)
+	}
+}
+
+// watchFeeds reloads the entries of the threat-intel feeds every interval.
+func watchFeeds(interval time.Duration) {
+	for {
+		index, err := feeds.LoadIndex(psql.DB)
+		if err != nil {
+			log.Printf("Error in loading feeds: %v", err)
+		} else {
+			feedIndex.Store(index)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Error in parsing %s: %v", name, err)
+	}
+	return d
+}
+
+func envInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Error in parsing %s: %v", name, err)
+	}
+	return n
 }
 
 func (s *Server) Send(
@@ -45,25 +214,42 @@ func (s *Server) Send(
 			return err
 		}
 
-		fmt.Println("matching policy..........")
-		if matchPolicy(inp) {
-			inp.Severity = "HIGH"
-		} else {
-			inp.Severity = "LOW"
-		}
-
 		iTime, err := convTime(inp.Time)
 		if err != nil {
 			return fmt.Errorf("error in converting time: %v", err)
 		}
 
+		flow := detect.Flow{
+			Time:        iTime,
+			Source:      inp.Source,
+			Destination: inp.Destination,
+			Port:        inp.Port,
+			Protocol:    inp.Protocol,
+			Direction:   inp.Type,
+		}
+
+		fmt.Println("matching policy..........")
+		result := matchPolicy(inp)
+		severity := result.Severity
+		verdict := detectFlow(flow)
+		if verdict.Severity.Rank() > severity.Rank() {
+			severity = verdict.Severity
+		}
+		inp.Severity = string(severity)
+
+		if responder != nil {
+			responder.Observe(flow, severity, verdict.Detections)
+		}
+		notify(flow, severity, result, verdict.Detections)
+
 		// if true {
 		// 	fmt.Println(iTime)
 		// 	return nil
 		// }
 
-		log.Printf("Storing in Clickhouse: %v\n", inp)
-		if err := logs.StoreLogs(context.Background(), &models.Log{
+		location := geo.Lookup(inp.Source)
+
+		entry := models.Log{
 			Time:        iTime,
 			Source:      inp.Source,
 			Destination: inp.Destination,
@@ -71,10 +257,21 @@ func (s *Server) Send(
 			Port:        inp.Port,
 			Protocol:    inp.Protocol,
 			Severity:    inp.Severity,
-		}); err != nil {
+			Feeds:       feedIndex.Load().Match(inp.Source),
+			Country:     location.Country,
+			City:        location.City,
+			ASN:         location.ASN,
+			Org:         location.Org,
+		}
+
+		log.Printf("Storing in Clickhouse: %v\n", inp)
+		if err := logs.StoreLogs(context.Background(), &entry); err != nil {
 			log.Printf("Error in storing logs:\n Log: %v\n Error: %v\n", inp, err)
 			return err
 		}
+		exporter.Log(entry)
+		publisher.Publish(entry)
+		subscribers.Broadcast(entry)
 
 		resp := &snapwall.ServiceResponse{
 			Time:        inp.Time,
@@ -92,27 +289,120 @@ func (s *Server) Send(
 	}
 }
 
-func matchPolicy(inp *snapwall.ServiceRequest) bool {
-	var policies []models.Policy
-	if err := psql.DB.Preload("IPs").Preload("Ports").Find(&policies).Error; err != nil {
-		log.Printf("Error in fetching policies: %v", err)
-		return false
-	}
-
-	for _, policy := range policies {
-		for _, ips := range policy.IPs {
-			if ips.Address == inp.Source {
-				for _, port := range policy.Ports {
-					if port.Number == inp.Port {
-						log.Println("INTRUDER FOUND !!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
-						return true
-					}
-				}
+// Subscribe streams the events processed from now on that filter selects.
+// A subscriber that falls behind by more than SUBSCRIBER_BUFFER events
+// misses events rather than slowing down ingestion; the number missed is
+// logged. Event times are sent as RFC 3339 in UTC.
+func (s *Server) Subscribe(
+	filter *snapwall.Filter,
+	stream snapwall.Sender_SubscribeServer,
+) error {
+	f, err := logs.NewFilter(filter.Severity, filter.Ip, filter.Port, filter.Protocol)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	subscriber := "unknown"
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		subscriber = p.Addr.String()
+	}
+	subscription := subscribers.Subscribe(f, subscriberBuffer)
+	defer subscription.Close()
+	log.Printf("Subscriber %s connected", subscriber)
+
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stream.Context().Done():
+			log.Printf("Subscriber %s disconnected", subscriber)
+			return nil
+		case entry := <-subscription.Logs:
+			resp := &snapwall.ServiceResponse{
+				Time:        entry.Time.UTC().Format(time.RFC3339Nano),
+				Source:      entry.Source,
+				Destination: entry.Destination,
+				Type:        entry.Type,
+				Port:        entry.Port,
+				Protocol:    entry.Protocol,
+				Severity:    entry.Severity,
+			}
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if dropped := subscription.Dropped(); dropped > 0 {
+				log.Printf("Subscriber %s missed %d events: it is not keeping up", subscriber, dropped)
 			}
 		}
 	}
+}
+
+func matchPolicy(inp *snapwall.ServiceRequest) matcher.Result {
+	m, err := policyCache.Matcher()
+	if err != nil {
+		log.Printf("Error in refreshing policy matcher: %v", err)
+	}
+	if m == nil {
+		return matcher.Result{Severity: models.SEVERITY_LOW}
+	}
+
+	result := m.Evaluate(matcher.Flow{
+		Source:      inp.Source,
+		Destination: inp.Destination,
+		Port:        inp.Port,
+		Protocol:    inp.Protocol,
+		Direction:   inp.Type,
+	})
+	if len(result.Matches) > 0 {
+		log.Println("INTRUDER FOUND !!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
+	}
+	return result
+}
+
+// notify raises alerts for a classified flow and the detections it started.
+func notify(flow detect.Flow, severity models.SEVERITY, result matcher.Result, detections []models.Detection) {
+	event := alerts.Event{
+		Time:        flow.Time,
+		Kind:        alerts.KindClassification,
+		Severity:    severity,
+		Source:      flow.Source,
+		Destination: flow.Destination,
+		Port:        flow.Port,
+		Protocol:    flow.Protocol,
+		Direction:   flow.Direction,
+	}
+	for _, policy := range result.Matches {
+		event.Policies = append(event.Policies, policy.Name)
+	}
+	for _, rule := range result.Rules {
+		event.Rules = append(event.Rules, rule.Name)
+	}
+	dispatcher.Notify(event)
+
+	for _, detection := range detections {
+		dispatcher.Notify(alerts.DetectionEvent(detection))
+	}
+}
+
+// detectFlow feeds a flow to the detectors and stores what they find.
+func detectFlow(flow detect.Flow) detect.Verdict {
+	verdict := detectors.Observe(flow)
 
-	return false
+	for i := range verdict.Detections {
+		detection := &verdict.Detections[i]
+		log.Printf("Detected %s from %s: %d targets", detection.Kind, detection.Source, detection.Count)
+		if err := psql.DB.Create(detection).Error; err != nil {
+			log.Printf("Error in storing detection: %v", err)
+		}
+		exporter.Detection(*detection)
+		go func(detection models.Detection) {
+			if err := logs.MarkDetection(context.Background(), detection); err != nil {
+				log.Printf("Error in marking logs: %v", err)
+			}
+		}(*detection)
+	}
+	return verdict
 }
 
 func convTime(s string) (time.Time, error) {
@@ -144,6 +434,17 @@ func main() {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
+	go watchThresholds(envDuration("MATCHER_REFRESH_INTERVAL", 5*time.Second))
+	go watchFeeds(envDuration("FEED_RELOAD_INTERVAL", time.Minute))
+	dispatcher.Start(context.Background(), envDuration("MATCHER_REFRESH_INTERVAL", 5*time.Second))
+	if exporter != nil {
+		exporter.Start(context.Background())
+	}
+	publisher.Start(context.Background(), envDuration("STREAM_PUBLISH_INTERVAL", 250*time.Millisecond))
+	if responder != nil {
+		responder.Start(context.Background())
+	}
+
 	s := grpc.NewServer()
 	snapwall.RegisterSenderServer(s, &Server{})
 